@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"strings"
+
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/prettyfmt"
+
+	"github.com/bitfield/script"
+	"github.com/spf13/cobra"
+)
+
+var explainFlag bool
+
+var certnameCmd = &cobra.Command{
+	Use:   "certname",
+	Short: "Print the certname of this node",
+	Long: `Print the certname linuxaid-cli uses. The certname is taken from the first of
+the certificate in PUPPETCERT, the private key, the --certname flag or CERTNAME env
+and puppet.conf. With --explain every source is shown, along with the certname
+puppet itself would use.`,
+	Example: `$ linuxaid-cli certname
+$ linuxaid-cli certname --explain`,
+	Annotations: map[string]string{annotationNoCertname: ""},
+	Run: func(*cobra.Command, []string) {
+		Certname()
+	},
+}
+
+// certnameExplanation adds what puppet reports to the resolution, puppet falls back
+// to the FQDN when puppet.conf has no certname so it isn't used to resolve it
+type certnameExplanation struct {
+	*helper.CertnameResolution
+	Puppet string `json:"puppet,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func Certname() {
+	resolution, err := helper.ResolveCertname()
+
+	if !explainFlag && !jsonFlag {
+		if err != nil {
+			slog.Error("failed to resolve the certname", slog.Any("error", err))
+			os.Exit(1)
+		}
+		prettyfmt.PrettyPrintln(resolution.Certname)
+		return
+	}
+
+	explanation := &certnameExplanation{
+		CertnameResolution: resolution,
+		Puppet:             puppetConfigCertname(),
+	}
+	if err != nil {
+		explanation.Error = err.Error()
+	}
+
+	if jsonFlag {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(explanation); err != nil {
+			slog.Error("failed to encode certname", slog.Any("error", err))
+			os.Exit(1)
+		}
+	} else {
+		printCertnameExplanation(explanation)
+	}
+
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// puppetConfigCertname asks puppet for the certname the agent would use, empty if puppet isn't installed
+func puppetConfigCertname() string {
+	if err := os.Setenv("PATH", constant.PuppetPath); err != nil {
+		return ""
+	}
+
+	certname, err := script.Exec("puppet config print certname --section agent").String()
+	if err != nil {
+		slog.Debug("unable to ask puppet for its certname", slog.Any("error", err))
+		return ""
+	}
+
+	return strings.TrimSpace(certname)
+}
+
+func printCertnameExplanation(explanation *certnameExplanation) {
+	prettyfmt.PrettyPrintf("\n %s  %s\n", prettyfmt.IconGear, prettyfmt.FontWhite("Certname sources, highest precedence first"))
+
+	for _, candidate := range explanation.Candidates {
+		value := prettyfmt.FontWhite("not set")
+		switch {
+		case candidate.Error != "":
+			value = prettyfmt.FontRed(candidate.Error)
+		case candidate.Certname == explanation.Certname && candidate.Source == explanation.Source:
+			value = prettyfmt.FontGreen(candidate.Certname + " (used)")
+		case candidate.Certname != "" && candidate.Certname != explanation.Certname:
+			value = prettyfmt.FontYellow(candidate.Certname + " (conflicts)")
+		case candidate.Certname != "":
+			value = candidate.Certname
+		}
+		prettyfmt.PrettyPrintf("    %-60s %s\n", candidate.Source, value)
+	}
+
+	if explanation.Puppet != "" {
+		puppet := explanation.Puppet
+		if explanation.Certname != "" && puppet != explanation.Certname {
+			puppet = prettyfmt.FontYellow(puppet + " (conflicts)")
+		}
+		prettyfmt.PrettyPrintf("    %-60s %s\n", "puppet config print certname", puppet)
+	}
+
+	if explanation.Error != "" {
+		prettyfmt.PrettyPrintf("\n %s  %s\n\n", prettyfmt.IconCheckFail, prettyfmt.FontRed(explanation.Error))
+		return
+	}
+
+	prettyfmt.PrettyPrintf("\n %s  %s %s\n\n", prettyfmt.IconCheckPass, prettyfmt.FontWhite("Certname:"), prettyfmt.FontGreen(explanation.Certname))
+}
+
+func init() {
+	rootCmd.AddCommand(certnameCmd)
+
+	certnameCmd.Flags().BoolVar(&explainFlag, constant.CobraFlagExplain, false, "Show every certname source and which one was used")
+	certnameCmd.Flags().BoolVar(&jsonFlag, constant.CobraFlagJSON, false, "Print the explanation as JSON")
+}
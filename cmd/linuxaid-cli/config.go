@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/config"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/prettyfmt"
+
+	"github.com/spf13/cobra"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the linuxaid-cli configuration",
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the effective configuration and where each value comes from",
+	Long: `Show the effective configuration. Values are taken from the first of: command line flags,
+env variables, ` + constant.ConfigDropInDir + `/*.yaml (last file wins), ` + constant.ConfigFile + `
+and the built-in defaults.`,
+	Example:     `$ linuxaid-cli config show`,
+	Annotations: map[string]string{annotationNoCertname: ""},
+	Run: func(*cobra.Command, []string) {
+		ConfigShow()
+	},
+}
+
+type configValue struct {
+	Key    string `json:"key"`
+	Value  any    `json:"value"`
+	Origin string `json:"origin"`
+}
+
+func ConfigShow() {
+	values := make([]configValue, 0, len(config.Keys()))
+	for _, key := range config.Keys() {
+		values = append(values, configValue{Key: key, Value: config.Get(key), Origin: config.Origin(key)})
+	}
+
+	if jsonFlag {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(map[string]any{"files": config.LoadedFiles(), "values": values}); err != nil {
+			slog.Error("failed to encode config", slog.Any("error", err))
+			os.Exit(1)
+		}
+		return
+	}
+
+	files := "none"
+	if loaded := config.LoadedFiles(); len(loaded) > 0 {
+		files = strings.Join(loaded, ", ")
+	}
+
+	prettyfmt.PrettyPrintf("\n %s  %s\n", prettyfmt.IconGear, prettyfmt.FontWhite("Linuxaid configuration"))
+	prettyfmt.PrettyPrintf("    files: %s\n\n", files)
+
+	for _, value := range values {
+		origin := prettyfmt.FontWhite(value.Origin)
+		if value.Origin != config.OriginDefault {
+			origin = prettyfmt.FontYellow(value.Origin)
+		}
+		prettyfmt.PrettyPrintf("    %-26s %-40s %s\n", value.Key, formatConfigValue(value.Value), origin)
+	}
+
+	prettyfmt.PrettyPrintln("")
+}
+
+func formatConfigValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "-"
+	case []string:
+		return strings.Join(v, ",")
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, fmt.Sprint(item))
+		}
+		return strings.Join(items, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configShowCmd)
+
+	configShowCmd.Flags().BoolVar(&jsonFlag, constant.CobraFlagJSON, false, "Print the configuration as JSON")
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/config"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/checkconnectivity"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/daemon"
+	api "gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/obmondo"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/prettyfmt"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/puppet"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/webtee"
+
+	"github.com/spf13/cobra"
+)
+
+// Daemon job names, also used with `linuxaid-cli daemon run <job>`
+const (
+	jobOpenvox       = "openvox"
+	jobSystemUpdate  = "system-update"
+	jobConnectivity  = "connectivity"
+	jobPing          = "ping"
+	timeFormatStatus = "2006-01-02 15:04:05"
+)
+
+var (
+	puppetIntervalFlag        time.Duration
+	connectivityIntervalFlag  time.Duration
+	pingIntervalFlag          time.Duration
+	serviceWindowIntervalFlag time.Duration
+	socketFlag                string
+)
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Run linuxaid-cli as a long-running agent",
+	Long: `Run the openvox agent, connectivity checks, server ping and service window polling
+on their own schedule, instead of separate timers calling run-openvox and system-update.
+The daemon is controlled through a unix socket, see the status, run, pause and resume subcommands.`,
+	Example: `$ linuxaid-cli daemon --mode noop --puppet-interval 30m
+$ linuxaid-cli daemon status
+$ linuxaid-cli daemon run openvox
+$ linuxaid-cli daemon pause`,
+	PreRun: func(cmd *cobra.Command, _ []string) {
+		bindOpenvoxRunFlags(cmd)
+	},
+	Run: func(*cobra.Command, []string) {
+		Daemon()
+	},
+}
+
+var daemonStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the state of the running daemon and its jobs",
+	Run: func(*cobra.Command, []string) {
+		status, err := daemon.NewClient(config.GetControlSocket()).Status()
+		if err != nil {
+			slog.Error("unable to get daemon status", slog.Any("error", err))
+			os.Exit(1)
+		}
+		printDaemonStatus(status)
+	},
+}
+
+var daemonRunCmd = &cobra.Command{
+	Use:       "run [job]",
+	Short:     "Run a daemon job right away, openvox by default",
+	Args:      cobra.MaximumNArgs(1),
+	ValidArgs: []string{jobOpenvox, jobSystemUpdate, jobConnectivity, jobPing},
+	Run: func(_ *cobra.Command, args []string) {
+		job := jobOpenvox
+		if len(args) == 1 {
+			job = args[0]
+		}
+		if err := daemon.NewClient(config.GetControlSocket()).Trigger(job); err != nil {
+			slog.Error("unable to trigger job", slog.String("job", job), slog.Any("error", err))
+			os.Exit(1)
+		}
+		prettyfmt.PrettyPrintf(" %s  %s %s\n", prettyfmt.IconCheckPass, prettyfmt.FontWhite("Queued"), prettyfmt.FontYellow(job))
+	},
+}
+
+var daemonPauseCmd = &cobra.Command{
+	Use:   "pause",
+	Short: "Stop the daemon from running openvox and system updates",
+	Run: func(*cobra.Command, []string) {
+		if err := daemon.NewClient(config.GetControlSocket()).Pause(); err != nil {
+			slog.Error("unable to pause the daemon", slog.Any("error", err))
+			os.Exit(1)
+		}
+		prettyfmt.PrettyPrintf(" %s  %s\n", prettyfmt.IconCheckPass, prettyfmt.FontWhite("Daemon paused"))
+	},
+}
+
+var daemonResumeCmd = &cobra.Command{
+	Use:   "resume",
+	Short: "Resume openvox runs and system updates after a pause",
+	Run: func(*cobra.Command, []string) {
+		if err := daemon.NewClient(config.GetControlSocket()).Resume(); err != nil {
+			slog.Error("unable to resume the daemon", slog.Any("error", err))
+			os.Exit(1)
+		}
+		prettyfmt.PrettyPrintf(" %s  %s\n", prettyfmt.IconCheckPass, prettyfmt.FontWhite("Daemon resumed"))
+	},
+}
+
+// Daemon runs all the scheduled work of linuxaid-cli in a single process
+func Daemon() {
+	helper.RequireRootUser()
+
+	mode, err := puppet.ParseRunMode(config.GetOpenvoxMode())
+	if err != nil {
+		slog.Error("unable to start the daemon", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	if err := os.Setenv("PATH", constant.PuppetPath); err != nil {
+		slog.Error("failed to set the PATH env, exiting")
+		os.Exit(1)
+	}
+
+	obmondoAPI := api.NewObmondoClient(api.GetObmondoURL(), false)
+	puppetService := puppet.NewService(obmondoAPI, webtee.NewWebtee(obmondoAPI))
+
+	// Spread the first run over the fleet, restarts tend to happen everywhere at once
+	puppetInterval := config.GetPuppetInterval()
+	splay := helper.Splay(helper.GetCertname(), min(config.GetSplayLimit(), puppetInterval))
+
+	jobs := []*daemon.Job{
+		{
+			Name:         jobOpenvox,
+			Interval:     puppetInterval,
+			InitialDelay: splay,
+			Pausable:     true,
+			Run: func() error {
+				return daemonOpenvoxRun(mode)
+			},
+		},
+		{
+			Name:     jobSystemUpdate,
+			Interval: config.GetServiceWindowInterval(),
+			Pausable: true,
+			Run: func() error {
+				return daemonSystemUpdate(obmondoAPI)
+			},
+		},
+		{
+			Name:     jobConnectivity,
+			Interval: config.GetConnectivityInterval(),
+			Run: func() error {
+				if !checkconnectivity.CheckTCPConnection() {
+					return errors.New("api not accessible")
+				}
+				return nil
+			},
+		},
+		{
+			Name:     jobPing,
+			Interval: config.GetPingInterval(),
+			Run:      obmondoAPI.ServerPing,
+		},
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	d := daemon.New(daemon.Config{
+		SocketPath:    config.GetControlSocket(),
+		ShutdownGrace: constant.DaemonShutdownGrace,
+		OnShutdown: func() {
+			reenableAgentAfterSystemUpdate(puppetService)
+		},
+	}, jobs...)
+
+	slog.Info("openvox runs scheduled", slog.String("mode", string(mode)), slog.Duration("interval", puppetInterval), slog.Duration("splay", splay))
+	if err := d.Run(ctx); err != nil {
+		slog.Error("daemon failed", slog.Any("error", err))
+		os.Exit(1)
+	}
+}
+
+// daemonOpenvoxRun shares the run-openvox lock, so a run-openvox started by hand doesn't overlap
+func daemonOpenvoxRun(mode puppet.RunMode) error {
+	lock, err := helper.TryLock(constant.RunOpenvoxLockFile)
+	if errors.Is(err, helper.ErrLocked) {
+		slog.Info("run-openvox is already in progress, skipping this run")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// nolint:errcheck
+	defer lock.Unlock()
+
+	return openvoxRun(mode)
+}
+
+// daemonSystemUpdate only starts the system update when the service window is open
+func daemonSystemUpdate(obmondoAPI api.ObmondoClient) error {
+	serviceWindowNow, err := obmondoAPI.GetServiceWindowStatus()
+	if err != nil {
+		return err
+	}
+
+	if !serviceWindowNow.IsWindowOpen {
+		slog.Debug("service window is inactive")
+		return nil
+	}
+
+	return systemUpdate()
+}
+
+// reenableAgentAfterSystemUpdate enables the agent if we got stopped in the middle of a system update,
+// an agent disabled by someone else is left alone
+func reenableAgentAfterSystemUpdate(puppetService *puppet.Service) {
+	status, err := puppetService.Status()
+	if err != nil {
+		slog.Error("unable to read puppet agent status", slog.Any("error", err))
+		return
+	}
+
+	if status.Disabled && status.DisabledMessage == constant.SystemUpdateDisableMessage {
+		if err := puppetService.EnableAgent(); err != nil {
+			slog.Error("unable to enable puppet agent", slog.Any("error", err))
+		}
+	}
+}
+
+func printDaemonStatus(status *daemon.Status) {
+	paused := prettyfmt.FontGreen("no")
+	if status.Paused {
+		paused = prettyfmt.FontYellow("yes")
+	}
+
+	prettyfmt.PrettyPrintf("\n %s  %s\n", prettyfmt.IconGear, prettyfmt.FontWhite("Linuxaid daemon"))
+	prettyfmt.PrettyPrintf("    started:  %s\n", status.StartedAt.Format(timeFormatStatus))
+	prettyfmt.PrettyPrintf("    paused:   %s\n\n", paused)
+
+	for _, job := range status.Jobs {
+		state := prettyfmt.FontGreen("ok")
+		switch {
+		case job.Running:
+			state = prettyfmt.FontYellow("running")
+		case job.LastError != "":
+			state = prettyfmt.FontRed("failed: " + job.LastError)
+		case job.LastRun.IsZero():
+			state = prettyfmt.FontWhite("not run yet")
+		}
+
+		lastRun := "never"
+		if !job.LastRun.IsZero() {
+			lastRun = job.LastRun.Format(timeFormatStatus)
+		}
+
+		prettyfmt.PrettyPrintf("    %-15s every %-8s last %-19s next %s  %s\n",
+			job.Name, job.Interval, lastRun, job.NextRun.Format(timeFormatStatus), state)
+	}
+
+	prettyfmt.PrettyPrintln("")
+}
+
+func init() {
+	rootCmd.AddCommand(daemonCmd)
+	daemonCmd.AddCommand(daemonStatusCmd, daemonRunCmd, daemonPauseCmd, daemonResumeCmd)
+
+	addOpenvoxRunFlags(daemonCmd)
+	daemonCmd.Flags().DurationVar(&puppetIntervalFlag, constant.CobraFlagPuppetInterval, constant.DefaultPuppetInterval, "How often to run the openvox agent")
+	daemonCmd.Flags().DurationVar(&connectivityIntervalFlag, constant.CobraFlagConnectivityInterval, constant.DefaultConnectivityInterval, "How often to check connectivity to Obmondo")
+	daemonCmd.Flags().DurationVar(&pingIntervalFlag, constant.CobraFlagPingInterval, constant.DefaultPingInterval, "How often to ping the Obmondo API")
+	daemonCmd.Flags().DurationVar(&serviceWindowIntervalFlag, constant.CobraFlagServiceWindowInterval, constant.DefaultServiceWindowInterval, "How often to poll for an open service window")
+	daemonCmd.PersistentFlags().StringVar(&socketFlag, constant.CobraFlagSocket, constant.ControlSocketPath, "Path of the daemon control socket")
+
+	// Bind flags to viper
+	v := config.GetViperInstance()
+	config.BindPFlag(constant.CobraFlagPuppetInterval, daemonCmd.Flags().Lookup(constant.CobraFlagPuppetInterval))
+	config.BindPFlag(constant.CobraFlagConnectivityInterval, daemonCmd.Flags().Lookup(constant.CobraFlagConnectivityInterval))
+	config.BindPFlag(constant.CobraFlagPingInterval, daemonCmd.Flags().Lookup(constant.CobraFlagPingInterval))
+	config.BindPFlag(constant.CobraFlagServiceWindowInterval, daemonCmd.Flags().Lookup(constant.CobraFlagServiceWindowInterval))
+	config.BindPFlag(constant.CobraFlagSocket, daemonCmd.PersistentFlags().Lookup(constant.CobraFlagSocket))
+
+	// Bind environment variables
+	config.BindEnv(constant.CobraFlagPuppetInterval, "PUPPET_INTERVAL")
+	config.BindEnv(constant.CobraFlagConnectivityInterval, "CONNECTIVITY_INTERVAL")
+	config.BindEnv(constant.CobraFlagPingInterval, "PING_INTERVAL")
+	config.BindEnv(constant.CobraFlagServiceWindowInterval, "SERVICE_WINDOW_INTERVAL")
+	config.BindEnv(constant.CobraFlagSocket, "LINUXAID_SOCKET")
+
+	// Set default values
+	v.SetDefault(constant.CobraFlagSocket, constant.ControlSocketPath)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"log/slog"
+	"os"
+	"strings"
+
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/decommission"
+	api "gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/obmondo"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/prettyfmt"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	keepCertsFlag bool
+	dryRunFlag    bool
+	yesFlag       bool
+	forceFlag     bool
+)
+
+var decommissionCmd = &cobra.Command{
+	Use:   "decommission",
+	Short: "Remove linuxaid and the openvox agent from this node",
+	Long: `Tell Obmondo the server is decommissioned, then stop and remove the linuxaid timers,
+the openvox-agent package, puppet.conf, the puppet SSL directory, the facter file and the metrics textfiles.`,
+	Example: `$ linuxaid-cli decommission --dry-run
+$ linuxaid-cli decommission --keep-certs --yes`,
+	Run: func(*cobra.Command, []string) {
+		Decommission()
+	},
+}
+
+func Decommission() {
+	osRelease, err := helper.ReadOSRelease()
+	if err != nil {
+		slog.Error("unable to determine the distribution", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	actions := decommission.Plan(decommission.Options{
+		OS:        osRelease,
+		KeepCerts: keepCertsFlag,
+	})
+
+	prettyfmt.PrettyPrintf("\n %s  %s %s\n", prettyfmt.IconGear, prettyfmt.FontWhite("Decommissioning"), prettyfmt.FontYellow(helper.GetCertname()))
+	prettyfmt.PrettyPrintf("    notify Obmondo the server is decommissioned\n")
+	for _, action := range actions {
+		prettyfmt.PrettyPrintf("    %s\n", action.Description)
+	}
+	prettyfmt.PrettyPrintln("")
+
+	if dryRunFlag {
+		return
+	}
+
+	helper.RequireRootUser()
+
+	if !confirmDecommission() {
+		prettyfmt.PrettyPrintf(" Exiting without changes...\n")
+		return
+	}
+
+	// The API call needs the puppet certificate, so it goes first
+	obmondoAPI := api.NewObmondoClient(api.GetObmondoURL(), false)
+	if err := obmondoAPI.NotifyDecommission(); err != nil {
+		if !forceFlag {
+			slog.Error("unable to notify obmondo, nothing was removed, use --force to decommission anyway", slog.Any("error", err))
+			os.Exit(1)
+		}
+		slog.Warn("unable to notify obmondo, decommissioning anyway", slog.Any("error", err))
+	}
+
+	if err := decommission.Run(actions); err != nil {
+		prettyfmt.PrettyPrintf(" %s  %s\n\n", prettyfmt.IconCheckFail, prettyfmt.FontRed("Decommission finished with errors"))
+		os.Exit(1)
+	}
+
+	prettyfmt.PrettyPrintf(" %s  %s\n\n", prettyfmt.FontGreen(prettyfmt.IconCheckPass), prettyfmt.FontWhite("Decommissioned"))
+}
+
+// confirmDecommission asks before removing anything, without a terminal --yes is required
+func confirmDecommission() bool {
+	if yesFlag {
+		return true
+	}
+
+	if !helper.IsTerminal(os.Stdin) {
+		slog.Error("stdin is not a terminal, use --yes to decommission")
+		return false
+	}
+
+	prettyfmt.PrettyPrintf(" %s Remove all of the above (Yes/No)? ", prettyfmt.IconQuestion)
+	input, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	input = strings.ToLower(strings.TrimSpace(input))
+
+	return input == "y" || input == "yes"
+}
+
+func init() {
+	rootCmd.AddCommand(decommissionCmd)
+
+	decommissionCmd.Flags().BoolVar(&keepCertsFlag, constant.CobraFlagKeepCerts, false, "Keep the puppet SSL directory")
+	decommissionCmd.Flags().BoolVar(&dryRunFlag, constant.CobraFlagDryRun, false, "Only list what would be removed")
+	decommissionCmd.Flags().BoolVarP(&yesFlag, constant.CobraFlagYes, "y", false, "Don't ask for confirmation")
+	decommissionCmd.Flags().BoolVar(&forceFlag, constant.CobraFlagForce, false, "Decommission even when Obmondo can't be notified")
+}
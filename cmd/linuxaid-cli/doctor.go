@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"time"
+
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/config"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/doctor"
+	api "gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/obmondo"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/prettyfmt"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/puppet"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/webtee"
+
+	"github.com/spf13/cobra"
+)
+
+var uploadFlag bool
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Diagnose common problems with this node",
+	Long: `Run the checks support starts every ticket with: certificates, certname, clock,
+DNS and TCP to Obmondo, webtee, the openvox server, disk space and leftover locks.
+Exits with 1 when any check fails.`,
+	Example: `$ linuxaid-cli doctor
+$ linuxaid-cli doctor --json
+$ linuxaid-cli doctor --upload`,
+	Annotations: map[string]string{annotationNoCertname: ""},
+	Run: func(*cobra.Command, []string) {
+		Doctor()
+	},
+}
+
+func Doctor() {
+	certname := helper.GetCertname()
+	certPath, keyPath := helper.PuppetCertPaths(certname)
+
+	obmondoAPI := api.NewObmondoClient(api.GetObmondoURL(), false)
+	webteeClient := webtee.NewWebtee(obmondoAPI)
+	puppetService := puppet.NewService(obmondoAPI, webteeClient)
+
+	report := doctor.Run(certname, []doctor.Check{
+		doctor.Root(),
+		doctor.OSRelease(),
+		doctor.Certname(),
+		doctor.PuppetCertificate(certPath, time.Duration(config.GetCertExpiryDays())*24*time.Hour),
+		doctor.CertificateKeyPair(certPath, keyPath),
+		doctor.ClockSkew(api.GetObmondoURL()),
+		doctor.DNS(),
+		doctor.TCP(),
+		doctor.Webtee(webteeClient),
+		doctor.PuppetServer(puppetService),
+		doctor.DiskSpace(),
+		doctor.StaleLocks(puppetService),
+		doctor.PackageManagerLock(),
+	})
+
+	if jsonFlag {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			slog.Error("failed to encode doctor report", slog.Any("error", err))
+			os.Exit(1)
+		}
+	} else {
+		printDoctorReport(report)
+	}
+
+	if uploadFlag {
+		uploadDoctorReport(webteeClient, report)
+	}
+
+	if report.Count(doctor.StatusFail) > 0 {
+		os.Exit(1)
+	}
+}
+
+// uploadDoctorReport sends the report through webtee, so support can read it without logging in
+func uploadDoctorReport(webteeClient *webtee.Webtee, report *doctor.Report) {
+	if report.Certname == "" {
+		slog.Error("unable to upload the doctor report without a certname")
+		return
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		slog.Error("failed to encode doctor report", slog.Any("error", err))
+		return
+	}
+
+	webteeClient.Send([]string{"linuxaid-cli doctor report", string(data)}, report.Certname)
+	slog.Info("doctor report uploaded")
+}
+
+func printDoctorReport(report *doctor.Report) {
+	prettyfmt.PrettyPrintf("\n %s  %s\n", prettyfmt.IconGear, prettyfmt.FontWhite("Linuxaid doctor"))
+
+	for _, result := range report.Results {
+		switch result.Status {
+		case doctor.StatusPass:
+			prettyfmt.PrettyPrintf("    %s  %-22s %s\n", prettyfmt.FontGreen(prettyfmt.IconCheckPass), result.Name, result.Message)
+		case doctor.StatusWarn:
+			prettyfmt.PrettyPrintf("    %s  %-22s %s\n", prettyfmt.FontYellow("!"), result.Name, prettyfmt.FontYellow(result.Message))
+		case doctor.StatusFail:
+			prettyfmt.PrettyPrintf("    %s  %-22s %s\n", prettyfmt.IconCheckFail, result.Name, prettyfmt.FontRed(result.Message))
+		}
+
+		if result.Hint != "" {
+			prettyfmt.PrettyPrintf("       %-22s %s\n", "", prettyfmt.FontBlue(result.Hint))
+		}
+	}
+
+	prettyfmt.PrettyPrintf("\n    %d passed, %d warnings, %d failed\n\n",
+		report.Count(doctor.StatusPass), report.Count(doctor.StatusWarn), report.Count(doctor.StatusFail))
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+
+	doctorCmd.Flags().BoolVar(&jsonFlag, constant.CobraFlagJSON, false, "Print the report as JSON")
+	doctorCmd.Flags().BoolVar(&uploadFlag, constant.CobraFlagUpload, false, "Send the report to Obmondo through webtee")
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"log/slog"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 
@@ -10,41 +11,132 @@ import (
 	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
 	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper"
 	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper/logger"
+	api "gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/obmondo"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/webtee"
 )
 
 var Version string
 
+// annotationNoCertname marks commands that don't require the certname to be known
+const annotationNoCertname = "no-certname"
+
 var (
-	debugFlag       bool
-	rebootFlag      bool
-	certnameFlag    string
-	skipOpenvoxFlag bool
+	debugFlag          bool
+	rebootFlag         bool
+	certnameFlag       string
+	puppetServerFlag   string
+	certExpiryDaysFlag int
+	skipOpenvoxFlag    bool
+	modeFlag           string
+	environmentFlag    string
+	tagsFlag           []string
+	skipTagsFlag       []string
+	splayFlag          bool
+	splayLimitFlag     time.Duration
+	logFormatFlag      string
+	logOutputFlag      string
+	logFileFlag        string
+	logWebteeFlag      bool
+	plainFlag          bool
 )
 
+// logWebtee streams the log records to webtee when --log-webtee is set
+var logWebtee *webtee.LogWriter
+
 var rootCmd = &cobra.Command{
 	Use:   "linuxaid-cli",
 	Short: "Manage your server with linuxaid-cli",
 	Example: `
 	$ linuxaid-cli run-openvox --certname web01.example
 	$ linuxaid-cli system-update --certname web01.example --no-reboot
+	$ linuxaid-cli openvox status
+	$ linuxaid-cli status --json
+	$ linuxaid-cli doctor
+	$ linuxaid-cli openvox renew-cert
+	$ linuxaid-cli certname --explain
 	`,
 	Version: Version,
 	CompletionOptions: cobra.CompletionOptions{
 		HiddenDefaultCmd: true,
 	},
 	PersistentPreRun: func(cmd *cobra.Command, _ []string) {
-		logger.InitLogger(nil, config.IsDebug())
+		// Config files are read first, they can turn on debug logging
+		configErr := config.Load()
+		if err := logger.Init(logger.ConfigOptions()); err != nil {
+			// nolint: errcheck
+			logger.Init(logger.Options{Debug: config.IsDebug()})
+			slog.Error("failed to set up logging", slog.Any("error", err))
+			os.Exit(1)
+		}
+		if configErr != nil {
+			slog.Error("failed to load the config", slog.Any("error", configErr))
+			os.Exit(1)
+		}
 
 		// Print version first
-		slog.Info("linuxaid-cli", slog.String("version", cmd.Root().Version))
+		slog.Info("linuxaid-cli", slog.String("version", cmd.Root().Version), slog.String("command", cmd.CommandPath()))
 
-		// Get certname from viper (cert, flag, or env)
-		if helper.GetCertname() == "" {
-			slog.Error("failed to fetch the certname")
+		// Commands that diagnose a broken node have to run without a certname
+		if _, ok := cmd.Annotations[annotationNoCertname]; ok {
+			return
+		}
+
+		// Resolve certname from the puppet certificate, private key, flag, env or puppet.conf
+		resolution, err := helper.ResolveCertname()
+		if err != nil {
+			slog.Error("failed to fetch the certname, see `linuxaid-cli certname --explain`", slog.Any("error", err))
 			os.Exit(1)
 		}
+		helper.SetResolvedCertname(resolution)
+		for _, conflict := range resolution.Conflicts() {
+			slog.Warn("certname sources disagree", slog.String("certname", resolution.Certname), slog.String("source", resolution.Source),
+				slog.String("conflicting_certname", conflict.Certname), slog.String("conflicting_source", conflict.Source))
+		}
+
+		if config.ShouldTeeLogs() {
+			teeLogsToWebtee(resolution.Certname)
+		}
+
+		checkCertExpiry()
 
 	},
+	PersistentPostRun: func(*cobra.Command, []string) {
+		closeLogging()
+	},
+}
+
+// teeLogsToWebtee sends the log records to webtee as well, so Obmondo sees why the cli did what it did
+func teeLogsToWebtee(certname string) {
+	writer, err := webtee.NewWebtee(api.NewObmondoClient(api.GetObmondoURL(), false)).NewLogWriter(certname)
+	if err != nil {
+		slog.Warn("unable to stream the logs to webtee", slog.Any("error", err))
+		return
+	}
+
+	opts := logger.ConfigOptions()
+	opts.Tee = writer
+	if err := logger.Init(opts); err != nil {
+		// nolint: errcheck
+		writer.Close()
+		slog.Warn("unable to stream the logs to webtee", slog.Any("error", err))
+		return
+	}
+
+	logWebtee = writer
+}
+
+// closeLogging flushes the webtee log stream and closes the log output
+func closeLogging() {
+	if logWebtee != nil {
+		// Stop teeing first, records logged while closing would be lost
+		opts := logger.ConfigOptions()
+		// nolint: errcheck
+		logger.Init(opts)
+		// nolint: errcheck
+		logWebtee.Close()
+		logWebtee = nil
+	}
+	logger.Close()
 }
 
 func init() {
@@ -52,15 +144,38 @@ func init() {
 
 	rootCmd.PersistentFlags().BoolVar(&debugFlag, constant.CobraFlagDebug, false, "Enable debug logs")
 	rootCmd.PersistentFlags().StringVar(&certnameFlag, constant.CobraFlagCertname, "", "Certificate name (required)")
+	rootCmd.PersistentFlags().StringVar(&puppetServerFlag, constant.CobraFlagPuppetServer, "", "Puppet server hostname, defaults to the server in puppet.conf or <customer id>.puppet.obmondo.com")
+	rootCmd.PersistentFlags().StringVar(&logFormatFlag, constant.CobraFlagLogFormat, logger.FormatLogfmt, "Log format: text, json or logfmt")
+	rootCmd.PersistentFlags().StringVar(&logOutputFlag, constant.CobraFlagLogOutput, logger.OutputStderr, "Log output: stderr, file or journald")
+	rootCmd.PersistentFlags().StringVar(&logFileFlag, constant.CobraFlagLogFile, constant.DefaultCLILogFile, "Log file for --log-output file, rotated at 10MiB")
+	rootCmd.PersistentFlags().BoolVar(&logWebteeFlag, constant.CobraFlagLogWebtee, false, "Stream the log records to Obmondo next to the command output")
+	rootCmd.PersistentFlags().IntVar(&certExpiryDaysFlag, constant.CobraFlagCertExpiryDays, constant.DefaultCertExpiryDays, "Warn when the puppet host certificate expires within this many days")
 
 	// Bind flags to viper
-	v.BindPFlag(constant.CobraFlagDebug, rootCmd.PersistentFlags().Lookup(constant.CobraFlagDebug))
-	v.BindPFlag(constant.CobraFlagCertname, rootCmd.PersistentFlags().Lookup(constant.CobraFlagCertname))
+	config.BindPFlag(constant.CobraFlagDebug, rootCmd.PersistentFlags().Lookup(constant.CobraFlagDebug))
+	config.BindPFlag(constant.CobraFlagCertname, rootCmd.PersistentFlags().Lookup(constant.CobraFlagCertname))
+	config.BindPFlag(constant.CobraFlagPuppetServer, rootCmd.PersistentFlags().Lookup(constant.CobraFlagPuppetServer))
+	config.BindPFlag(constant.CobraFlagCertExpiryDays, rootCmd.PersistentFlags().Lookup(constant.CobraFlagCertExpiryDays))
+	config.BindPFlag(constant.CobraFlagLogFormat, rootCmd.PersistentFlags().Lookup(constant.CobraFlagLogFormat))
+	config.BindPFlag(constant.CobraFlagLogOutput, rootCmd.PersistentFlags().Lookup(constant.CobraFlagLogOutput))
+	config.BindPFlag(constant.CobraFlagLogFile, rootCmd.PersistentFlags().Lookup(constant.CobraFlagLogFile))
+	config.BindPFlag(constant.CobraFlagLogWebtee, rootCmd.PersistentFlags().Lookup(constant.CobraFlagLogWebtee))
 
 	// Bind environment variables
-	v.BindEnv(constant.CobraFlagDebug)
-	v.BindEnv(constant.CobraFlagCertname)
-
+	config.BindEnv(constant.CobraFlagDebug)
+	config.BindEnv(constant.CobraFlagCertname)
+	config.BindEnv(constant.CobraFlagPuppetServer, "PUPPET_SERVER")
+	config.BindEnv(constant.CobraFlagCertExpiryDays, "CERT_EXPIRY_DAYS")
+	config.BindEnv(constant.CobraFlagLogFormat, "LOG_FORMAT")
+	config.BindEnv(constant.CobraFlagLogOutput, "LOG_OUTPUT")
+	config.BindEnv(constant.CobraFlagLogFile, "LOG_FILE")
+	config.BindEnv(constant.CobraFlagLogWebtee, "LOG_WEBTEE")
+
+	// Set default values
+	v.SetDefault(constant.CobraFlagCertExpiryDays, constant.DefaultCertExpiryDays)
+	v.SetDefault(constant.CobraFlagLogFormat, logger.FormatLogfmt)
+	v.SetDefault(constant.CobraFlagLogOutput, logger.OutputStderr)
+	v.SetDefault(constant.CobraFlagLogFile, constant.DefaultCLILogFile)
 }
 
 func main() {
@@ -72,6 +187,7 @@ func main() {
 
 	if err := rootCmd.Execute(); err != nil {
 		slog.Error(err.Error())
+		closeLogging()
 		os.Exit(1)
 	}
 }
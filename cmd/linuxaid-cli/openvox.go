@@ -0,0 +1,143 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/config"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/metrics"
+	api "gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/obmondo"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/prettyfmt"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/puppet"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/webtee"
+
+	"github.com/spf13/cobra"
+)
+
+var openvoxCmd = &cobra.Command{
+	Use:   "openvox",
+	Short: "Inspect and manage the openvox agent",
+}
+
+var openvoxStatusCmd = &cobra.Command{
+	Use:     "status",
+	Short:   "Show whether the openvox agent is running or disabled",
+	Example: `$ linuxaid-cli openvox status`,
+	Run: func(*cobra.Command, []string) {
+		OpenvoxStatus()
+	},
+}
+
+var openvoxRenewCertCmd = &cobra.Command{
+	Use:   "renew-cert",
+	Short: "Renew the puppet host certificate with the puppet CA",
+	Long: `Ask the puppet CA for a new host certificate, authenticating with the current one.
+The old certificate is only replaced once the new one loads with the private key.`,
+	Example: `$ linuxaid-cli openvox renew-cert`,
+	Run: func(*cobra.Command, []string) {
+		OpenvoxRenewCert()
+	},
+}
+
+func newPuppetService() *puppet.Service {
+	obmondoAPI := api.NewObmondoClient(api.GetObmondoURL(), false)
+	return puppet.NewService(obmondoAPI, webtee.NewWebtee(obmondoAPI))
+}
+
+// writePuppetRunMetrics exports the outcome of a puppet run for the node_exporter textfile collector
+func writePuppetRunMetrics(puppetService *puppet.Service, lastRun *puppet.LastRun, exitCode int, duration time.Duration) {
+	run := &metrics.PuppetRun{
+		Duration: duration,
+		ExitCode: exitCode,
+	}
+
+	if lastRun != nil && lastRun.Summary != nil {
+		run.ResourcesChanged = lastRun.Summary.Resources["changed"]
+		run.ResourcesFailed = lastRun.Summary.Resources["failed"]
+	}
+
+	if status, err := puppetService.Status(); err == nil {
+		run.AgentDisabled = status.Disabled
+	}
+
+	if err := metrics.WritePuppetRun(run); err != nil {
+		slog.Warn("failed to write puppet run metrics", slog.Any("error", err))
+	}
+}
+
+func OpenvoxStatus() {
+	status, err := newPuppetService().Status()
+	if err != nil {
+		slog.Error("unable to read openvox agent status", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	running := prettyfmt.FontGreen("no")
+	if status.Running {
+		running = prettyfmt.FontYellow("yes (pid " + strconv.Itoa(status.PID) + ")")
+	}
+
+	disabled := prettyfmt.FontGreen("no")
+	if status.Disabled {
+		reason := status.DisabledMessage
+		if reason == "" {
+			reason = "no reason given"
+		}
+		disabled = prettyfmt.FontRed("yes (" + reason + ")")
+	}
+
+	prettyfmt.PrettyPrintf("\n %s  %s\n", prettyfmt.IconGear, prettyfmt.FontWhite("Openvox agent"))
+	prettyfmt.PrettyPrintf("    running:  %s\n", running)
+	prettyfmt.PrettyPrintf("    disabled: %s\n\n", disabled)
+}
+
+func OpenvoxRenewCert() {
+	helper.RequireRootUser()
+
+	cert, err := newPuppetService().RenewCertificate()
+	if err != nil {
+		slog.Error("unable to renew the puppet host certificate", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	slog.Info("puppet host certificate renewed", slog.Time("expires", cert.NotAfter))
+	if err := metrics.RemoveCertificateExpiry(); err != nil {
+		slog.Warn("failed to remove certificate metrics", slog.Any("error", err))
+	}
+
+	prettyfmt.PrettyPrintf("\n %s  %s %s\n\n", prettyfmt.IconCheckPass, prettyfmt.FontWhite("Certificate renewed, valid until"),
+		prettyfmt.FontGreen(cert.NotAfter.Format(time.DateOnly)))
+}
+
+// checkCertExpiry warns when the puppet host certificate is about to expire and exports the expiry
+// for node_exporter, a node that isn't installed yet has no certificate and is skipped
+func checkCertExpiry() {
+	certPath, _ := helper.PuppetCertPaths(helper.GetCertname())
+	cert, err := helper.ParseCertFile(certPath)
+	if err != nil {
+		slog.Debug("unable to check the puppet host certificate expiry", slog.Any("error", err))
+		return
+	}
+
+	warnWithin := time.Duration(config.GetCertExpiryDays()) * 24 * time.Hour
+	if time.Until(cert.NotAfter) > warnWithin {
+		if err := metrics.RemoveCertificateExpiry(); err != nil {
+			slog.Debug("failed to remove certificate metrics", slog.Any("error", err))
+		}
+		return
+	}
+
+	slog.Warn("puppet host certificate is about to expire, renew it with `linuxaid-cli openvox renew-cert`",
+		slog.String("certificate", certPath), slog.Time("expires", cert.NotAfter))
+	if err := metrics.WriteCertificateExpiry(cert.NotAfter); err != nil {
+		slog.Debug("failed to write certificate metrics", slog.Any("error", err))
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(openvoxCmd)
+	openvoxCmd.AddCommand(openvoxStatusCmd, openvoxRenewCertCmd)
+}
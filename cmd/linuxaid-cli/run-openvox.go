@@ -1,86 +1,230 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log/slog"
+	"os"
+	"time"
 
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/config"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
 	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper"
 	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/checkconnectivity"
 	api "gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/obmondo"
-	"github.com/bitfield/script"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/prettyfmt"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/puppet"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/webtee"
 	"github.com/spf13/cobra"
 )
 
 var runOpenvoxCmd = &cobra.Command{
-	Use:     "run-openvox",
-	Short:   "Execute run-openvox command",
-	Long:    "A longer description of run-openvox command",
-	Example: `$ linuxaid-cli run-openvox --certname web01.example`,
+	Use:   "run-openvox",
+	Short: "Execute run-openvox command",
+	Long:  "Run the openvox agent in noop or enforce mode and report the result to Obmondo",
+	Example: `$ linuxaid-cli run-openvox --certname web01.example
+$ linuxaid-cli run-openvox --mode enforce --tags nginx,profile::web
+$ linuxaid-cli run-openvox --mode server --environment staging
+$ linuxaid-cli run-openvox --splay --splay-limit 30m`,
+	PreRun: func(cmd *cobra.Command, _ []string) {
+		bindOpenvoxRunFlags(cmd)
+	},
 	Run: func(*cobra.Command, []string) {
 		RunOpenvox()
 	},
 }
 
-// Run the puppet agent in noop mode for now
-func runOpenvoxAgent() error {
-	// Puppet run execution returns total 5 status codes
-	//
-	// 0: The run succeeded with no changes or failures; the system was already in the desired state.
-	// 1: The run failed, or wasn't attempted due to another run already in progress.
-	// 2: The run succeeded, and some resources were changed.
-	// 4: The run succeeded, and some resources failed.
-	// 6: The run succeeded, and included both changes and failures.
-	// [Source: https://www.puppet.com/docs/puppet/7/man/agent.html#usage-notes]
-	//
-	// We throw error at status code 1, and return.
-	// Status codes other than 2 are considered as warning.
-	// Status code 0 doesn't count as error, so no need to handle it.
-
-	statusCodeFailed := 1
-	statusCodeSucceededWithChanges := 2
-
-	slog.Info("executing the puppet agent command")
-	cmdPipe := script.Exec("/opt/puppetlabs/bin/puppet agent -t --noop")
-	_, err := cmdPipe.Stdout()
-	if err != nil {
-		// When encountering status code 1, consider it as an error, and return.
-		if cmdPipe.ExitStatus() == statusCodeFailed {
-			slog.Error("puppet agent command execution failed", slog.String("status", err.Error()))
-			return err
-		}
+// runOpenvoxAgent runs the puppet agent and logs the outcome, returns the exit code of the run
+func runOpenvoxAgent(puppetService *puppet.Service, opts *puppet.RunOptions) int {
+	exitCode := puppetService.RunAgent(opts)
+	description := puppet.DescribeExitCode(exitCode)
 
-		// When encountering status codes other than 2, just log it as a warning.
-		if cmdPipe.ExitStatus() != statusCodeSucceededWithChanges {
-			slog.Warn("puppet agent run succeeded, but with failures", slog.String("status", err.Error()))
-		}
+	switch {
+	case exitCode == puppet.ExitCodeFailed:
+		slog.Error("puppet agent command execution failed", slog.Int("exit_code", exitCode), slog.String("status", description))
+	case !puppet.IsSuccessExitCode(exitCode):
+		slog.Warn("puppet agent run succeeded, but with failures", slog.Int("exit_code", exitCode), slog.String("status", description))
+	default:
+		slog.Info("completed the puppet agent command execution", slog.Int("exit_code", exitCode), slog.String("status", description))
 	}
 
-	slog.Info("completed the puppet agent command execution")
-	return nil
+	return exitCode
 }
 
 // Entry point
 func RunOpenvox() {
-	helper.LoadPuppetEnv()
+	mode, err := puppet.ParseRunMode(config.GetOpenvoxMode())
+	if err != nil {
+		slog.Error("unable to run the puppet agent", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	if err := os.Setenv("PATH", constant.PuppetPath); err != nil {
+		slog.Error("failed to set the PATH env, exiting")
+		os.Exit(1)
+	}
+
+	// Timers on many nodes fire at the same time, only one run-openvox at a time per node
+	lock, err := helper.TryLock(constant.RunOpenvoxLockFile)
+	if errors.Is(err, helper.ErrLocked) {
+		slog.Warn("another run-openvox is already in progress, exiting")
+		return
+	}
+	if err != nil {
+		slog.Error("unable to take the run-openvox lock", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	// nolint:errcheck
+	defer lock.Unlock()
+
+	if config.ShouldSplay() {
+		splay := helper.Splay(helper.GetCertname(), config.GetSplayLimit())
+		slog.Info("sleeping before the openvox run", slog.Duration("splay", splay))
+		time.Sleep(splay)
+	}
+
+	// nolint:errcheck
+	openvoxRun(mode)
+}
 
+// openvoxRun checks connectivity, runs the agent and reports the result back to Obmondo.
+// The error is already logged, it's returned for the daemon to keep track of.
+func openvoxRun(mode puppet.RunMode) error {
 	allAPIReachable := checkconnectivity.CheckTCPConnection()
 	if !allAPIReachable {
-		slog.Error("unable to connect to obmondo api, aborting", slog.String("error", "api not accessible"))
-		return
+		err := errors.New("api not accessible")
+		slog.Error("unable to connect to obmondo api, aborting", slog.String("error", err.Error()))
+		return err
 	}
 	obmondoAPI := api.NewObmondoClient(api.GetObmondoURL(), false)
 
 	// nolint:errcheck
 	obmondoAPI.ServerPing()
 
-	// Need to have case here later in future, when we migrate the endpoints in go-api
-	if err := runOpenvoxAgent(); err != nil {
-		slog.Error("unable to run the puppet agent", slog.String("error", err.Error()))
+	puppetService := puppet.NewService(obmondoAPI, webtee.NewWebtee(obmondoAPI))
+	opts := &puppet.RunOptions{
+		Mode:        puppetService.ResolveRunMode(mode),
+		Environment: config.GetOpenvoxEnvironment(),
+		Tags:        config.GetOpenvoxTags(),
+		SkipTags:    config.GetOpenvoxSkipTags(),
+		RemoteLog:   true,
+		Output:      os.Stdout,
 	}
 
-	// nolint:errcheck
-	obmondoAPI.UpdatePuppetLastRunReport()
+	// Let a run started by hand or by the agent finish first
+	puppetService.WaitForAgent(constant.PuppetWaitForCertTimeOut)
+
+	startTime := time.Now()
+	exitCode := runOpenvoxAgent(puppetService, opts)
+	duration := time.Since(startTime)
+
+	lastRun, err := puppetService.UpdateLastRunReport()
+	if err != nil {
+		slog.Error("unable to update puppet last run report", slog.String("error", err.Error()))
+	}
+
+	writePuppetRunMetrics(puppetService, lastRun, exitCode, duration)
+
+	if lastRun != nil {
+		printLastRunSummary(lastRun)
+	}
+
+	if exitCode == puppet.ExitCodeFailed {
+		return fmt.Errorf("puppet agent run failed with exit code %d", exitCode)
+	}
+
+	return nil
+}
+
+// addOpenvoxRunFlags adds the flags deciding how the agent runs, shared by run-openvox and daemon
+func addOpenvoxRunFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&modeFlag, constant.CobraFlagMode, string(puppet.RunModeNoop), "Run mode: noop, enforce or server (decided by Obmondo)")
+	cmd.Flags().StringVar(&environmentFlag, constant.CobraFlagEnvironment, "", "Puppet environment to run against")
+	cmd.Flags().StringSliceVar(&tagsFlag, constant.CobraFlagTags, nil, "Only apply resources with these tags")
+	cmd.Flags().StringSliceVar(&skipTagsFlag, constant.CobraFlagSkipTags, nil, "Skip resources with these tags")
+	cmd.Flags().BoolVar(&splayFlag, constant.CobraFlagSplay, false, "Sleep for a per-node delay derived from the certname before running")
+	cmd.Flags().DurationVar(&splayLimitFlag, constant.CobraFlagSplayLimit, constant.DefaultSplayLimit, "Maximum delay when --splay is set")
+}
+
+// bindOpenvoxRunFlags binds the flags of the command that is actually running, a viper key
+// can only be bound to one flag so this can't happen in init
+func bindOpenvoxRunFlags(cmd *cobra.Command) {
+	config.BindPFlag(constant.CobraFlagMode, cmd.Flags().Lookup(constant.CobraFlagMode))
+	config.BindPFlag(constant.CobraFlagEnvironment, cmd.Flags().Lookup(constant.CobraFlagEnvironment))
+	config.BindPFlag(constant.CobraFlagTags, cmd.Flags().Lookup(constant.CobraFlagTags))
+	config.BindPFlag(constant.CobraFlagSkipTags, cmd.Flags().Lookup(constant.CobraFlagSkipTags))
+	config.BindPFlag(constant.CobraFlagSplay, cmd.Flags().Lookup(constant.CobraFlagSplay))
+	config.BindPFlag(constant.CobraFlagSplayLimit, cmd.Flags().Lookup(constant.CobraFlagSplayLimit))
 }
 
 func init() {
 	rootCmd.AddCommand(runOpenvoxCmd)
+	addOpenvoxRunFlags(runOpenvoxCmd)
+
+	// Bind environment variables
+	config.BindEnv(constant.CobraFlagMode, "OPENVOX_MODE")
+	config.BindEnv(constant.CobraFlagEnvironment, "OPENVOX_ENVIRONMENT")
+	config.BindEnv(constant.CobraFlagTags, "OPENVOX_TAGS")
+	config.BindEnv(constant.CobraFlagSkipTags, "OPENVOX_SKIP_TAGS")
+	config.BindEnv(constant.CobraFlagSplay, "SPLAY")
+	config.BindEnv(constant.CobraFlagSplayLimit, "SPLAY_LIMIT")
+
+	// Set default values, the flags are only bound once a command using them runs
+	v := config.GetViperInstance()
+	v.SetDefault(constant.CobraFlagMode, string(puppet.RunModeNoop))
+	v.SetDefault(constant.CobraFlagSplay, false)
+	v.SetDefault(constant.CobraFlagSplayLimit, constant.DefaultSplayLimit)
+}
+
+// printLastRunSummary prints a human readable overview of the last puppet run
+func printLastRunSummary(lastRun *puppet.LastRun) {
+	report, summary := lastRun.Report, lastRun.Summary
+	if report == nil {
+		prettyfmt.PrettyPrintf("\n %s  %s\n\n", prettyfmt.IconCheckFail, prettyfmt.FontWhite("No openvox run report found"))
+		return
+	}
+
+	mode := "enforce"
+	if report.Noop {
+		mode = "noop"
+	}
+
+	status := prettyfmt.FontGreen(report.Status)
+	if report.Status == "failed" {
+		status = prettyfmt.FontRed(report.Status)
+	}
+
+	prettyfmt.PrettyPrintf("\n %s  %s (%s, environment %s, puppet %s)\n", prettyfmt.IconGear,
+		prettyfmt.FontWhite("Openvox run summary"), mode, report.Environment, report.PuppetVersion)
+	prettyfmt.PrettyPrintf("    status:     %s\n", status)
+	prettyfmt.PrettyPrintf("    catalog:    %s\n", report.ConfigurationVersion)
+
+	if summary != nil {
+		prettyfmt.PrettyPrintf("    resources:  %d total, %d changed, %d failed, %d out of sync\n",
+			summary.Resources["total"], summary.Resources["changed"], summary.Resources["failed"], summary.Resources["out_of_sync"])
+		prettyfmt.PrettyPrintf("    events:     %d total, %d failure, %d noop\n",
+			summary.Events["total"], summary.Events["failure"], summary.Events["noop"])
+		prettyfmt.PrettyPrintf("    duration:   %.2fs (fact generation %.2fs, config retrieval %.2fs, catalog application %.2fs)\n",
+			summary.Time["total"], summary.Time["fact_generation"], summary.Time["config_retrieval"], summary.Time["catalog_application"])
+	}
+
+	for _, rs := range report.FailedResources() {
+		prettyfmt.PrettyPrintf("    %s %s\n", prettyfmt.FontRed("failed:"), rs.Resource)
+		for _, event := range rs.Events {
+			prettyfmt.PrettyPrintf("        %s\n", event.Message)
+		}
+	}
+	for _, rs := range report.ChangedResources() {
+		prettyfmt.PrettyPrintf("    %s %s\n", prettyfmt.FontYellow("changed:"), rs.Resource)
+	}
+	if report.Noop {
+		for _, rs := range report.OutOfSyncResources() {
+			if rs.Failed {
+				continue
+			}
+			prettyfmt.PrettyPrintf("    %s %s\n", prettyfmt.FontYellow("pending:"), rs.Resource)
+		}
+	}
+
+	prettyfmt.PrettyPrintln("")
 }
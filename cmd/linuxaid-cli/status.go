@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/config"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/checkconnectivity"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/daemon"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/disk"
+	api "gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/obmondo"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/prettyfmt"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/puppet"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/security"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/webtee"
+
+	"github.com/spf13/cobra"
+)
+
+var jsonFlag bool
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the linuxaid state of this node",
+	Long: `Show the openvox agent state, the last openvox run, the service window,
+connectivity to Obmondo, disk headroom and pending updates in one place.`,
+	Example: `$ linuxaid-cli status
+$ linuxaid-cli status --json`,
+	Run: func(*cobra.Command, []string) {
+		Status()
+	},
+}
+
+// nodeStatus is the aggregated state of the node, every section is left empty
+// with its error set when it couldn't be collected
+type nodeStatus struct {
+	Certname   string `json:"certname"`
+	CustomerID string `json:"customer_id"`
+
+	Agent      *puppet.AgentStatus `json:"agent,omitempty"`
+	AgentError string              `json:"agent_error,omitempty"`
+
+	LastRun      *api.PuppetLastRunReport `json:"last_run,omitempty"`
+	LastRunError string                   `json:"last_run_error,omitempty"`
+
+	ServiceWindow      *api.ServiceWindow `json:"service_window,omitempty"`
+	ServiceWindowError string             `json:"service_window_error,omitempty"`
+
+	Connectivity      []checkconnectivity.HostStatus `json:"connectivity,omitempty"`
+	ConnectivityError string                         `json:"connectivity_error,omitempty"`
+
+	PuppetServerReachable bool   `json:"puppet_server_reachable"`
+	PuppetServerError     string `json:"puppet_server_error,omitempty"`
+
+	Disk      []disk.MountCheck `json:"disk,omitempty"`
+	DiskError string            `json:"disk_error,omitempty"`
+
+	Updates      *security.TotalNumberOfPackagesWithUpdateResponse `json:"updates,omitempty"`
+	UpdatesError string                                            `json:"updates_error,omitempty"`
+
+	Daemon      *daemon.Status `json:"daemon,omitempty"`
+	DaemonError string         `json:"daemon_error,omitempty"`
+
+	lastRun *puppet.LastRun
+}
+
+// Status prints the aggregated state of the node
+func Status() {
+	status := collectNodeStatus()
+
+	if jsonFlag {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(status); err != nil {
+			slog.Error("failed to encode status", slog.Any("error", err))
+			os.Exit(1)
+		}
+		return
+	}
+
+	printNodeStatus(status)
+}
+
+func collectNodeStatus() *nodeStatus {
+	certname := helper.GetCertname()
+	status := &nodeStatus{
+		Certname:   certname,
+		CustomerID: helper.GetCustomerID(certname),
+	}
+
+	obmondoAPI := api.NewObmondoClient(api.GetObmondoURL(), false)
+	puppetService := puppet.NewService(obmondoAPI, webtee.NewWebtee(obmondoAPI))
+
+	if agent, err := puppetService.Status(); err != nil {
+		status.AgentError = err.Error()
+	} else {
+		status.Agent = agent
+	}
+
+	if lastRun, err := puppet.ReadLastRun(); err != nil {
+		status.LastRunError = err.Error()
+	} else {
+		status.lastRun = lastRun
+		status.LastRun = lastRun.APIReport()
+	}
+
+	if serviceWindow, err := obmondoAPI.GetServiceWindowStatus(); err != nil {
+		status.ServiceWindowError = err.Error()
+	} else {
+		status.ServiceWindow = serviceWindow
+	}
+
+	if hosts, err := checkconnectivity.CheckHosts(); err != nil {
+		status.ConnectivityError = err.Error()
+	} else {
+		status.Connectivity = hosts
+	}
+
+	if err := puppetService.CheckServerStatus(); err != nil {
+		status.PuppetServerError = err.Error()
+	} else {
+		status.PuppetServerReachable = true
+	}
+
+	if result, err := disk.CheckConfigured(nil); err != nil {
+		status.DiskError = err.Error()
+	} else {
+		status.Disk = result.Mounts
+	}
+
+	if updates, err := security.NewSecurityExporter(securityExporterURL).GetNumberOfPackageUpdates(); err != nil {
+		status.UpdatesError = err.Error()
+	} else {
+		status.Updates = updates
+	}
+
+	// The daemon is optional, nodes running from timers don't have a control socket
+	if daemonStatus, err := daemon.NewClient(config.GetControlSocket()).Status(); err != nil {
+		status.DaemonError = err.Error()
+	} else {
+		status.Daemon = daemonStatus
+	}
+
+	return status
+}
+
+func printNodeStatus(status *nodeStatus) {
+	prettyfmt.PrettyPrintf("\n %s  %s\n", prettyfmt.IconGear, prettyfmt.FontWhite("Node"))
+	prettyfmt.PrettyPrintf("    certname:    %s\n", status.Certname)
+	prettyfmt.PrettyPrintf("    customer id: %s\n", status.CustomerID)
+
+	prettyfmt.PrettyPrintf("\n %s  %s\n", prettyfmt.IconGear, prettyfmt.FontWhite("Openvox agent"))
+	if status.Agent != nil {
+		running := prettyfmt.FontGreen("no")
+		if status.Agent.Running {
+			running = prettyfmt.FontYellow("yes (pid " + strconv.Itoa(status.Agent.PID) + ")")
+		}
+		disabled := prettyfmt.FontGreen("no")
+		if status.Agent.Disabled {
+			reason := status.Agent.DisabledMessage
+			if reason == "" {
+				reason = "no reason given"
+			}
+			disabled = prettyfmt.FontRed("yes (" + reason + ")")
+		}
+		prettyfmt.PrettyPrintf("    running:  %s\n", running)
+		prettyfmt.PrettyPrintf("    disabled: %s\n", disabled)
+	} else {
+		printStatusError(status.AgentError)
+	}
+
+	if status.lastRun != nil {
+		printLastRunSummary(status.lastRun)
+	} else {
+		prettyfmt.PrettyPrintf("\n %s  %s\n", prettyfmt.IconGear, prettyfmt.FontWhite("Openvox run summary"))
+		printStatusError(status.LastRunError)
+	}
+
+	prettyfmt.PrettyPrintf(" %s  %s\n", prettyfmt.IconGear, prettyfmt.FontWhite("Service window"))
+	if status.ServiceWindow != nil {
+		open := prettyfmt.FontWhite("closed")
+		if status.ServiceWindow.IsWindowOpen {
+			open = prettyfmt.FontYellow("open")
+		}
+		prettyfmt.PrettyPrintf("    state:    %s\n", open)
+		if status.ServiceWindow.WindowType != "" {
+			prettyfmt.PrettyPrintf("    type:     %s (%s)\n", status.ServiceWindow.WindowType, status.ServiceWindow.Timezone)
+		}
+	} else {
+		printStatusError(status.ServiceWindowError)
+	}
+
+	prettyfmt.PrettyPrintf("\n %s  %s\n", prettyfmt.IconGear, prettyfmt.FontWhite("Connectivity"))
+	for _, host := range status.Connectivity {
+		printStatusCheck(host.Reachable, host.Host+":"+host.Port, host.Error)
+	}
+	if status.ConnectivityError != "" {
+		printStatusError(status.ConnectivityError)
+	}
+	printStatusCheck(status.PuppetServerReachable, "openvox server "+helper.GetPuppetServer(), status.PuppetServerError)
+
+	prettyfmt.PrettyPrintf("\n %s  %s\n", prettyfmt.IconGear, prettyfmt.FontWhite("Disk"))
+	for _, mount := range status.Disk {
+		detail := fmt.Sprintf("%-12s %s free (%.0f%% used)", mount.Path, humanBytes(mount.Free), mount.UsedPercent)
+		if mount.Skipped != "" {
+			detail = fmt.Sprintf("%-12s skipped, %s", mount.Path, mount.Skipped)
+		}
+		printStatusCheck(mount.OK(), detail, strings.Join(mount.Problems, ", "))
+	}
+	if status.DiskError != "" {
+		printStatusError(status.DiskError)
+	}
+
+	prettyfmt.PrettyPrintf("\n %s  %s\n", prettyfmt.IconGear, prettyfmt.FontWhite("Pending updates"))
+	if status.Updates != nil {
+		kernel := prettyfmt.FontGreen("no")
+		if status.Updates.HasKernelUpdate {
+			kernel = prettyfmt.FontYellow("yes")
+		}
+		prettyfmt.PrettyPrintf("    packages: %d\n", status.Updates.TotalNumberOfPackagesWithUpdate)
+		prettyfmt.PrettyPrintf("    kernel:   %s\n", kernel)
+	} else {
+		printStatusError(status.UpdatesError)
+	}
+
+	if status.Daemon != nil {
+		printDaemonStatus(status.Daemon)
+		return
+	}
+
+	prettyfmt.PrettyPrintf("\n %s  %s\n", prettyfmt.IconGear, prettyfmt.FontWhite("Linuxaid daemon"))
+	prettyfmt.PrettyPrintf("    %s\n\n", prettyfmt.FontWhite("not running"))
+}
+
+func printStatusCheck(ok bool, what, reason string) {
+	if ok {
+		prettyfmt.PrettyPrintf("    %s  %s\n", prettyfmt.FontGreen(prettyfmt.IconCheckPass), what)
+		return
+	}
+	if reason != "" {
+		what += " (" + reason + ")"
+	}
+	prettyfmt.PrettyPrintf("    %s  %s\n", prettyfmt.IconCheckFail, prettyfmt.FontRed(what))
+}
+
+func printStatusError(reason string) {
+	prettyfmt.PrettyPrintf("    %s  %s\n", prettyfmt.IconCheckFail, prettyfmt.FontRed(reason))
+}
+
+func humanBytes(size uint64) string {
+	const unit = 1024
+	if size < unit {
+		return strconv.FormatUint(size, 10) + " B"
+	}
+
+	div, exp := uint64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+
+	statusCmd.Flags().BoolVar(&jsonFlag, constant.CobraFlagJSON, false, "Print the status as JSON")
+}
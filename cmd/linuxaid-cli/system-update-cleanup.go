@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper"
+)
+
+// Prefixes of the Debian and Ubuntu kernel image packages, the kernel release follows
+var debianKernelPrefixes = []string{"linux-image-unsigned-", "linux-image-"}
+
+// cleanupBeforeUpdate removes the kernels older than the newest keepKernels and cleans the package
+// cache, to make room for the upgrade. Every removed package is logged. Cleanup failures are only
+// logged, the disk space check after it decides whether the upgrade goes ahead.
+func cleanupBeforeUpdate(family string, keepKernels int, environment helper.Environment) {
+	before, err := installedPackages(family)
+	if err != nil {
+		slog.Warn("unable to list the installed packages, removed packages won't be logged", slog.String("error", err.Error()))
+	}
+
+	// The host owns the kernel of a container
+	if environment.IsContainer() {
+		slog.Info("running in a container, leaving the kernels alone")
+	} else if err := removeOldKernels(family, keepKernels); err != nil {
+		slog.Warn("failed to remove old kernels", slog.String("error", err.Error()))
+	}
+
+	if err := cleanPackageCache(family); err != nil {
+		slog.Warn("failed to clean the package cache", slog.String("error", err.Error()))
+	}
+
+	if before == nil {
+		return
+	}
+	after, err := installedPackages(family)
+	if err != nil {
+		slog.Warn("unable to list the installed packages, removed packages won't be logged", slog.String("error", err.Error()))
+		return
+	}
+
+	removed := withoutExisting(before, after)
+	for _, pkg := range removed {
+		slog.Info("removed package", slog.String("package", pkg))
+	}
+	slog.Info("cleanup before the update done", slog.Int("removed_packages", len(removed)))
+}
+
+func removeOldKernels(family string, keepKernels int) error {
+	if keepKernels < 1 {
+		return fmt.Errorf("invalid number of kernels to keep: %d", keepKernels)
+	}
+	slog.Info("removing old kernels", slog.Int("keep", keepKernels))
+
+	switch family {
+	case helper.FamilyDebian:
+		return removeOldDebianKernels(keepKernels)
+	case helper.FamilyRedHat:
+		if packageManager := helper.RedHatPackageManager(); packageManager == "dnf" {
+			return runCleanup("dnf", "remove", "-y", "--oldinstallonly", "--setopt=installonly_limit="+strconv.Itoa(keepKernels))
+		}
+		// yum has no --oldinstallonly, package-cleanup from yum-utils does the same
+		return runCleanup("package-cleanup", "-y", "--oldkernels", "--count="+strconv.Itoa(keepKernels))
+	case helper.FamilySUSE:
+		// zypper keeps the kernels multiversion.kernels in /etc/zypp/zypp.conf names
+		return runCleanup("zypper", "--non-interactive", "purge-kernels")
+	}
+	return fmt.Errorf("unknown distribution family %q", family)
+}
+
+// removeOldDebianKernels purges the kernel images older than the newest keepKernels, apt autoremove
+// keeps a fixed number of kernels, then lets autoremove take the modules and headers they leave behind
+func removeOldDebianKernels(keepKernels int) error {
+	out, err := exec.Command("dpkg-query", "-W", "-f=${db:Status-Abbrev} ${Package}\n", "linux-image-*").Output()
+	if err != nil {
+		return fmt.Errorf("failed to list the installed kernels: %w", err)
+	}
+
+	running, err := exec.Command("uname", "-r").Output()
+	if err != nil {
+		return fmt.Errorf("failed to read the running kernel: %w", err)
+	}
+
+	remove := oldDebianKernels(string(out), strings.TrimSpace(string(running)), keepKernels)
+	if len(remove) > 0 {
+		if err := runCleanup("apt-get", append([]string{"purge", "-y"}, remove...)...); err != nil {
+			return err
+		}
+	}
+
+	return runCleanup("apt-get", "autoremove", "--purge", "-y")
+}
+
+// oldDebianKernels returns the installed kernel image packages, from dpkg-query output, to remove
+// for only the newest keepKernels and the running kernel to be left
+func oldDebianKernels(installed, running string, keepKernels int) []string {
+	type kernel struct{ pkg, release string }
+
+	var kernels []kernel
+	for _, line := range strings.Split(installed, "\n") {
+		// ii linux-image-6.1.0-18-amd64, meta packages like linux-image-amd64 have no release
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !strings.HasPrefix(fields[0], "ii") {
+			continue
+		}
+		pkg := fields[1]
+		for _, prefix := range debianKernelPrefixes {
+			if release, ok := strings.CutPrefix(pkg, prefix); ok {
+				if release != "" && unicode.IsDigit(rune(release[0])) {
+					kernels = append(kernels, kernel{pkg: pkg, release: release})
+				}
+				break
+			}
+		}
+	}
+
+	// Newest first
+	slices.SortFunc(kernels, func(a, b kernel) int { return compareVersions(b.release, a.release) })
+
+	var remove []string
+	for i, k := range kernels {
+		if i < keepKernels || k.release == running {
+			continue
+		}
+		remove = append(remove, k.pkg)
+	}
+	return remove
+}
+
+// compareVersions compares versions like sort -V does: runs of digits by value, the rest as text
+func compareVersions(a, b string) int {
+	for a != "" && b != "" {
+		aChunk, aRest := versionChunk(a)
+		bChunk, bRest := versionChunk(b)
+
+		aNum, aErr := strconv.Atoi(aChunk)
+		bNum, bErr := strconv.Atoi(bChunk)
+		switch {
+		case aErr == nil && bErr == nil && aNum != bNum:
+			return aNum - bNum
+		case (aErr != nil || bErr != nil) && aChunk != bChunk:
+			return strings.Compare(aChunk, bChunk)
+		}
+		a, b = aRest, bRest
+	}
+	return len(a) - len(b)
+}
+
+// versionChunk splits off the leading run of digits, or of anything else, of version
+func versionChunk(version string) (string, string) {
+	digit := unicode.IsDigit(rune(version[0]))
+	end := strings.IndexFunc(version, func(r rune) bool { return unicode.IsDigit(r) != digit })
+	if end < 0 {
+		return version, ""
+	}
+	return version[:end], version[end:]
+}
+
+func cleanPackageCache(family string) error {
+	slog.Info("cleaning the package cache")
+
+	switch family {
+	case helper.FamilyDebian:
+		return runCleanup("apt-get", "clean")
+	case helper.FamilyRedHat:
+		return runCleanup(helper.RedHatPackageManager(), "clean", "packages")
+	case helper.FamilySUSE:
+		return runCleanup("zypper", "--non-interactive", "clean", "--all")
+	}
+	return fmt.Errorf("unknown distribution family %q", family)
+}
+
+// installedPackages lists the installed packages with their versions, e.g. linux-image-6.1.0-18-amd64=6.1.76-1
+func installedPackages(family string) ([]string, error) {
+	switch family {
+	case helper.FamilyDebian:
+		out, err := exec.Command("dpkg-query", "-W", "-f=${db:Status-Abbrev} ${Package}=${Version}\n").Output()
+		if err != nil {
+			return nil, fmt.Errorf("dpkg-query failed: %w", err)
+		}
+
+		var packages []string
+		for _, line := range strings.Split(string(out), "\n") {
+			// Removed packages dpkg still has the config files of aren't installed
+			if fields := strings.Fields(line); len(fields) == 2 && strings.HasPrefix(fields[0], "ii") {
+				packages = append(packages, fields[1])
+			}
+		}
+		return packages, nil
+	case helper.FamilyRedHat, helper.FamilySUSE:
+		out, err := exec.Command("rpm", "-qa", "--qf", "%{NAME}-%{VERSION}-%{RELEASE}.%{ARCH}\n").Output()
+		if err != nil {
+			return nil, fmt.Errorf("rpm -qa failed: %w", err)
+		}
+		return strings.Fields(string(out)), nil
+	}
+	return nil, fmt.Errorf("unknown distribution family %q", family)
+}
+
+// runCleanup runs a cleanup command with its output next to ours
+func runCleanup(name string, args ...string) error {
+	slog.Debug("running cleanup command", slog.String("command", name+" "+strings.Join(args, " ")))
+
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = updateOutput
+	cmd.Stderr = updateOutput
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s failed: %w", name, strings.Join(args, " "), err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const zypperLocksFile = "/etc/zypp/locks"
+
+// Package names as apt, yum and zypper accept them, yum globs included
+var packageNameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._+*:-]*$`)
+
+// runHooks runs the configured hook commands in order through the shell, stopping at the first failure
+func runHooks(stage string, hooks []string) error {
+	for _, hook := range hooks {
+		slog.Info("running hook", slog.String("stage", stage), slog.String("command", hook))
+
+		cmd := exec.Command("/bin/sh", "-c", hook)
+		cmd.Stdout = updateOutput
+		cmd.Stderr = updateOutput
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s hook %q failed: %w", stage, hook, err)
+		}
+	}
+
+	return nil
+}
+
+func validatePackageNames(packages []string) error {
+	for _, pkg := range packages {
+		if !packageNameRegexp.MatchString(pkg) {
+			return fmt.Errorf("invalid package name in excludes: %q", pkg)
+		}
+	}
+	return nil
+}
+
+// holdDebianPackages puts the excluded packages on hold for the upgrade, the returned
+// func releases the holds we added and leaves holds that were already there alone
+func holdDebianPackages(packages []string) (func(), error) {
+	out, err := exec.Command("apt-mark", "showhold").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list held packages: %w", err)
+	}
+	held := strings.Fields(string(out))
+
+	toHold := withoutExisting(packages, held)
+	if len(toHold) == 0 {
+		return func() {}, nil
+	}
+
+	if out, err := exec.Command("apt-mark", append([]string{"hold"}, toHold...)...).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed to hold %s: %w: %s", strings.Join(toHold, ", "), err, strings.TrimSpace(string(out)))
+	}
+	slog.Info("holding excluded packages during the upgrade", slog.Any("packages", toHold))
+
+	return func() {
+		if out, err := exec.Command("apt-mark", append([]string{"unhold"}, toHold...)...).CombinedOutput(); err != nil {
+			slog.Error("failed to release held packages", slog.Any("packages", toHold), slog.String("output", strings.TrimSpace(string(out))))
+		}
+	}, nil
+}
+
+// lockSUSEPackages is holdDebianPackages for zypper
+func lockSUSEPackages(packages []string) (func(), error) {
+	locked, err := readZypperLocks(zypperLocksFile)
+	if err != nil {
+		return nil, err
+	}
+
+	toLock := withoutExisting(packages, locked)
+	if len(toLock) == 0 {
+		return func() {}, nil
+	}
+
+	if out, err := exec.Command("zypper", append([]string{"--non-interactive", "addlock"}, toLock...)...).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed to lock %s: %w: %s", strings.Join(toLock, ", "), err, strings.TrimSpace(string(out)))
+	}
+	slog.Info("locking excluded packages during the update", slog.Any("packages", toLock))
+
+	return func() {
+		if out, err := exec.Command("zypper", append([]string{"--non-interactive", "removelock"}, toLock...)...).CombinedOutput(); err != nil {
+			slog.Error("failed to remove package locks", slog.Any("packages", toLock), slog.String("output", strings.TrimSpace(string(out))))
+		}
+	}, nil
+}
+
+// readZypperLocks returns the package names locked in /etc/zypp/locks
+func readZypperLocks(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var locked []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if name, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "solvable_name:"); ok {
+			locked = append(locked, strings.TrimSpace(name))
+		}
+	}
+
+	return locked, scanner.Err()
+}
+
+// yumExcludeArgs turns the excludes into --exclude flags for yum and dnf
+func yumExcludeArgs(packages []string) string {
+	args := make([]string, 0, len(packages))
+	for _, pkg := range packages {
+		args = append(args, "--exclude="+pkg)
+	}
+	return strings.Join(args, " ")
+}
+
+func withoutExisting(packages, existing []string) []string {
+	var missing []string
+	for _, pkg := range packages {
+		if !slices.Contains(existing, pkg) {
+			missing = append(missing, pkg)
+		}
+	}
+	return missing
+}
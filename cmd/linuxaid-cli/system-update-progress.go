@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/config"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper/logger"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper/progress"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/prettyfmt"
+)
+
+var (
+	// updateOutput gets the output of the package manager, puppet and the hooks
+	updateOutput io.Writer = os.Stdout
+	// showProgress is set when system-update shows a line per phase instead of the output
+	showProgress bool
+)
+
+// How apt says how many packages it upgrades and that it's on the next one, dnf and zypper count themselves
+var (
+	aptTotalRegexp    = regexp.MustCompile(`^(\d+) upgraded, (\d+) newly installed`)
+	aptPackageRegexp  = regexp.MustCompile(`^Unpacking \S+`)
+	dnfCounterRegexp  = regexp.MustCompile(`^\s*\w+\s*: .*\s(\d+)/(\d+)\s*$`)
+	dnf5CounterRegexp = regexp.MustCompile(`^\[\s*(\d+)/(\d+)\] (?:Upgrading|Installing|Removing|Reinstalling|Downgrading)`)
+	zypperCountRegexp = regexp.MustCompile(`^\(\s*(\d+)/(\d+)\) (?:Installing|Removing)`)
+)
+
+// setupProgress sends the output of the commands system-update runs to the log file, for the terminal
+// to only show the phases. Log records meant for stderr go to the log file as well, a configured file
+// or journald output is kept. The returned func restores the logging and closes the log file.
+func setupProgress() (func(), error) {
+	file, err := logger.OpenFile(config.GetLogFile())
+	if err != nil {
+		return nil, err
+	}
+
+	original := logger.ConfigOptions()
+	if logWebtee != nil {
+		original.Tee = logWebtee
+	}
+
+	redirected := original.Output == logger.OutputStderr
+	if redirected {
+		opts := original
+		opts.Writer = file
+		if err := logger.Init(opts); err != nil {
+			// nolint: errcheck
+			file.Close()
+			return nil, err
+		}
+	}
+
+	progress.InitProgressBar()
+	updateOutput = file
+	showProgress = true
+
+	prettyfmt.PrettyPrintf("\n %s  %s %s\n\n", prettyfmt.IconGear, prettyfmt.FontWhite("Running system-update, the full output goes to"), prettyfmt.FontYellow(config.GetLogFile()))
+
+	return func() {
+		// Log records after this go where they went before, not to the closed file
+		if redirected {
+			// nolint: errcheck
+			logger.Init(original)
+		}
+		// nolint: errcheck
+		file.Close()
+	}, nil
+}
+
+// phase runs one phase of system-update, behind the progress bar when it's shown
+func phase(description string, run func() error) error {
+	if !showProgress {
+		return run()
+	}
+	return progress.NonDeterministicFunc(description, run)
+}
+
+// progressNote tells the one watching the progress why system-update stopped or what's next,
+// without progress the log records say it
+func progressNote(note string) {
+	if showProgress {
+		prettyfmt.PrettyPrintf("\n %s  %s\n", prettyfmt.IconGear, prettyfmt.FontWhite(note))
+	}
+}
+
+// upgradeOutput is where the package manager of family writes while upgrading, when the progress
+// is shown the packages it got to are counted in the description of the phase
+func upgradeOutput(family, description string) io.Writer {
+	if !showProgress {
+		return updateOutput
+	}
+
+	counter := &packageCounter{family: family, report: func(done, total int) {
+		progress.Describe(fmt.Sprintf("%s (%d/%d)", description, done, total))
+	}}
+	return io.MultiWriter(updateOutput, counter)
+}
+
+// packageCounter counts the packages in the output of the package manager of family
+type packageCounter struct {
+	family string
+	report func(done, total int)
+
+	line  []byte
+	done  int
+	total int
+}
+
+func (c *packageCounter) Write(p []byte) (int, error) {
+	c.line = append(c.line, p...)
+	for {
+		end := bytes.IndexAny(c.line, "\r\n")
+		if end < 0 {
+			break
+		}
+		c.count(string(c.line[:end]))
+		c.line = c.line[end+1:]
+	}
+	return len(p), nil
+}
+
+func (c *packageCounter) count(line string) {
+	switch c.family {
+	case helper.FamilyDebian:
+		if match := aptTotalRegexp.FindStringSubmatch(line); match != nil {
+			upgraded, _ := strconv.Atoi(match[1])
+			installed, _ := strconv.Atoi(match[2])
+			c.total = upgraded + installed
+			return
+		}
+		if !aptPackageRegexp.MatchString(line) || c.done >= c.total {
+			return
+		}
+		c.done++
+	case helper.FamilyRedHat, helper.FamilySUSE:
+		match := dnfCounterRegexp.FindStringSubmatch(line)
+		if match == nil {
+			match = dnf5CounterRegexp.FindStringSubmatch(line)
+		}
+		if match == nil {
+			match = zypperCountRegexp.FindStringSubmatch(line)
+		}
+		if match == nil {
+			return
+		}
+		c.done, _ = strconv.Atoi(match[1])
+		c.total, _ = strconv.Atoi(match[2])
+	default:
+		return
+	}
+
+	c.report(c.done, c.total)
+}
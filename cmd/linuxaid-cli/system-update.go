@@ -2,15 +2,17 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
-	"slices"
 	"strings"
+	"time"
 
 	"gitea.obmondo.com/EnableIT/linuxaid-cli/config"
 	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
 	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper"
 	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/disk"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/metrics"
 	api "gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/obmondo"
 	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/puppet"
 	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/security"
@@ -21,7 +23,6 @@ import (
 )
 
 const (
-	agentDisabledFile   = constant.AgentDisabledLockFile
 	bootDirectory       = "/boot"
 	securityExporterURL = "http://127.254.254.254:63396"
 )
@@ -53,33 +54,45 @@ func cleanup(puppetService *puppet.Service) {
 //
 // This function accepts a `distribution` string representing the type of Linux distribution that needs
 // to be updated. Depending on the distribution provided, it will invoke the appropriate update function.
-func UpdateSystem(distribution string) error {
-	switch distribution {
-	case "ubuntu", "debian":
-		return updateDebian()
-	case "sles":
-		return updateSUSE()
-	case "centos", "rhel":
-		return updateRedHat()
+// The package manager writes to output.
+func UpdateSystem(family string, output io.Writer) error {
+	excludes := config.GetExcludes()
+	if err := validatePackageNames(excludes); err != nil {
+		return err
+	}
+
+	switch family {
+	case helper.FamilyDebian:
+		return updateDebian(excludes, output)
+	case helper.FamilySUSE:
+		return updateSUSE(excludes, output)
+	case helper.FamilyRedHat:
+		return updateRedHat(excludes, output)
 	default:
 		slog.Error("unknown distribution")
 		return nil
 	}
 }
 
-func updateDebian() error {
+func updateDebian(excludes []string, output io.Writer) error {
 	slog.Info("running apt update/upgrade/autoremove")
-	enverr := os.Setenv("DEBIAN_FRONTEND", "noninteractive")
-	if enverr != nil {
-		slog.Error(enverr.Error())
-		os.Exit(1)
+	if err := os.Setenv("DEBIAN_FRONTEND", "noninteractive"); err != nil {
+		return fmt.Errorf("failed to set DEBIAN_FRONTEND: %w", err)
 	}
 
 	if err := script.Exec("apt-get update").Wait(); err != nil {
 		slog.Error("failed to update all repositories", slog.String("error", err.Error()))
 	}
-	pipe := script.Exec("apt-get --with-new-pkgs upgrade -y")
-	_, err := pipe.Stdout()
+
+	release, err := holdDebianPackages(excludes)
+	if err != nil {
+		slog.Error("failed to hold excluded packages", slog.String("error", err.Error()))
+		return err
+	}
+	defer release()
+
+	pipe := script.Exec("apt-get --with-new-pkgs upgrade -y").WithStdout(output)
+	_, err = pipe.Stdout()
 	if err != nil {
 		slog.Error("failed to upgrade all packages", slog.String("error", err.Error()))
 		return err
@@ -91,7 +104,7 @@ func updateDebian() error {
 		return fmt.Errorf(" apt-get update and upgrade failed: exit status %d", exitStatus)
 	}
 
-	pipe = script.Exec("apt-get autoremove -y")
+	pipe = script.Exec("apt-get autoremove -y").WithStdout(output)
 	_, err = pipe.Stdout()
 	if err != nil {
 		slog.Error("failed to remove unused dependencies", slog.String("error", err.Error()))
@@ -101,14 +114,21 @@ func updateDebian() error {
 	return nil
 }
 
-func updateSUSE() error {
+func updateSUSE(excludes []string, output io.Writer) error {
 	slog.Info("running zypper refresh/update")
 	if err := script.Exec("zypper refresh").Wait(); err != nil {
 		slog.Error("failed to refresh all repositories", slog.String("error", err.Error()))
 	}
 
-	pipe := script.Exec("zypper update -y")
-	_, err := pipe.Stdout()
+	release, err := lockSUSEPackages(excludes)
+	if err != nil {
+		slog.Error("failed to lock excluded packages", slog.String("error", err.Error()))
+		return err
+	}
+	defer release()
+
+	pipe := script.Exec("zypper update -y").WithStdout(output)
+	_, err = pipe.Stdout()
 	if err != nil {
 		slog.Error("failed to update all repositories", slog.String("error", err.Error()))
 		return err
@@ -123,13 +143,14 @@ func updateSUSE() error {
 	return nil
 }
 
-func updateRedHat() error {
-	slog.Info("running yum repolist/update")
-	if err := script.Exec("yum repolist").Wait(); err != nil {
+func updateRedHat(excludes []string, output io.Writer) error {
+	packageManager := helper.RedHatPackageManager()
+	slog.Info("running repolist/update", slog.String("package_manager", packageManager))
+	if err := script.Exec(packageManager + " repolist").Wait(); err != nil {
 		slog.Error("failed to fetch all repositories", slog.String("error", err.Error()))
 	}
 
-	pipe := script.Exec("yum update -y")
+	pipe := script.Exec(strings.TrimSpace(packageManager + " update -y " + yumExcludeArgs(excludes))).WithStdout(output)
 	_, err := pipe.Stdout()
 	if err != nil {
 		slog.Error("failed to update all packages", slog.String("error", err.Error()))
@@ -138,8 +159,8 @@ func updateRedHat() error {
 
 	exitStatus := pipe.ExitStatus()
 	if exitStatus != 0 {
-		slog.Error("exiting, yum update failed")
-		return fmt.Errorf("yum update failed: exit status %d", exitStatus)
+		slog.Error("exiting, update failed", slog.String("package_manager", packageManager))
+		return fmt.Errorf("%s update failed: exit status %d", packageManager, exitStatus)
 	}
 
 	return nil
@@ -150,55 +171,57 @@ func updateRedHat() error {
 
 // HandlePuppetRun is resposible to run the puppet-agent and handle the status codes of the execution
 func HandlePuppetRun(puppetService *puppet.Service) error {
-	exitCode := puppetService.RunAgent(false, "noop")
-	if slices.Contains(constant.PuppetSuccessExitCodes, exitCode) {
+	startTime := time.Now()
+	exitCode := puppetService.RunAgent(&puppet.RunOptions{Mode: puppet.RunModeNoop, Output: updateOutput})
+
+	lastRun, err := puppet.ReadLastRun()
+	if err != nil {
+		slog.Debug("unable to read puppet last run", slog.Any("error", err))
+	}
+	writePuppetRunMetrics(puppetService, lastRun, exitCode, time.Since(startTime))
+
+	if puppet.IsSuccessExitCode(exitCode) {
 		slog.Info("everything is fine with puppet agent run, let's continue.")
 		return nil
 	}
 
-	slog.Error("puppet failed, aborting.", slog.Int("exit_code", exitCode))
+	slog.Error("puppet failed, aborting.", slog.Int("exit_code", exitCode), slog.String("status", puppet.DescribeExitCode(exitCode)))
 	return fmt.Errorf("puppet failed with exit code: %d", exitCode)
 }
 
 // ------------------------------------------------
 // ------------------------------------------------
 
-// CheckKernelAndRebootIfNeeded checks if a new kernel is installed and reboots if necessary.
-func CheckKernelAndRebootIfNeeded() error {
+// IsNewKernelInstalled checks if a newer kernel than the running one is installed,
+// meaning the node needs a reboot to pick it up.
+func IsNewKernelInstalled() (bool, error) {
 	// Get installed kernel of the system
 	// If kernel is installed, then only we will try to reboot.
-	// In lxc kernel wont be present
 	installedKernel, err := getInstalledKernel(bootDirectory)
 	if err != nil {
 		slog.Error("error occurred while trying to find kernel", slog.String("error", err.Error()))
-		return err
+		return false, err
 	}
 	if installedKernel == "" {
 		slog.Warn("looks like no kernel is installed on the node")
-		return nil
+		return false, nil
 	}
 
 	// Get running kernel of the system
 	runningKernel, err := script.Exec("uname -r").String()
 	if err != nil {
 		slog.Error("Failed to fetch Running Kernel", slog.String("error", err.Error()))
-		return err
+		return false, err
 	}
 	runningKernel = strings.TrimSpace(runningKernel)
 
 	// Check the disk size
 	if err := disk.CheckDiskSize(); err != nil {
 		slog.Error("unable to check disk size", slog.String("error", err.Error()))
-		return err
+		return false, err
 	}
 
-	// Reboot the node, if we have installed a new kernel
-	if installedKernel != runningKernel && !config.NoReboot() {
-		slog.Info("looks like newer kernel is installed, so going ahead with reboot now")
-		script.Exec("reboot --force")
-	}
-
-	return nil
+	return installedKernel != runningKernel, nil
 }
 
 // getInstalledKernel returns the installed Kernel
@@ -213,100 +236,239 @@ func getInstalledKernel(bootDirectory string) (string, error) {
 // ------------------------------------------------
 
 func SystemUpdate() {
-	helper.LoadOSReleaseEnv()
+	// Someone watching a terminal gets a line per phase, the output goes to the log file
+	closeProgress := func() {}
+	if !plainFlag && helper.IsTerminal(os.Stdout) {
+		closer, err := setupProgress()
+		if err != nil {
+			slog.Warn("unable to show the progress, printing the output instead", slog.Any("error", err))
+		} else {
+			closeProgress = closer
+		}
+	}
 
+	err := systemUpdate()
+	closeProgress()
+	if err != nil {
+		progressNote("system-update failed, the full output is in " + config.GetLogFile())
+		os.Exit(1)
+	}
+}
+
+// systemUpdate does the work of the system-update command, the daemon calls it when the service window opens.
+// A returned error means the command should exit non-zero.
+func systemUpdate() error {
 	envErr := os.Setenv("PATH", constant.PuppetPath)
 	if envErr != nil {
 		slog.Error("failed to set the PATH env, exiting")
-		os.Exit(1)
+		return envErr
 	}
 
-	helper.RequireRootUser()
-	helper.RequireOSNameEnv()
-	cmds, err := helper.IsSupportedOS()
+	// The daemon calls this as well, it has to stay alive when a run fails
+	if err := helper.CheckRootUser(); err != nil {
+		slog.Error("system-update needs to be run as root user", slog.Any("error", err))
+		return err
+	}
+	osRelease, err := helper.ReadOSRelease()
+	if err != nil {
+		slog.Error("unable to determine the distribution", slog.Any("error", err))
+		return err
+	}
+	cmds, err := helper.IsUpdatableOS(osRelease)
 	if err != nil {
 		slog.Error("OS not supported", slog.String("err", err.Error()))
-		os.Exit(1)
+		return err
 	}
 
-	slog.Info("starting system-update")
+	environment := helper.DetectEnvironment()
+	slog.Info("starting system-update", slog.String("os", osRelease.String()), slog.Any("environment", environment))
+
+	update := &metrics.SystemUpdate{Timestamp: time.Now()}
+	outcome, newKernel, err := runSystemUpdate(cmds, osRelease, environment)
+	update.Outcome = outcome
+	update.RebootPending = newKernel && config.NoReboot()
+
+	// The metrics have to be on disk before we reboot
+	if err := metrics.WriteSystemUpdate(update); err != nil {
+		slog.Warn("failed to write system-update metrics", slog.Any("error", err))
+	}
 
-	// check if agent disable file exists
-	if _, err := os.Stat(agentDisabledFile); err == nil {
-		slog.Warn("puppet has been disabled, exiting")
-		return
+	if err != nil {
+		return err
 	}
+
+	// Reboot the node, if we have installed a new kernel
+	if newKernel && !config.NoReboot() {
+		slog.Info("looks like newer kernel is installed, so going ahead with reboot now")
+		script.Exec("reboot --force")
+	}
+
+	return nil
+}
+
+// runSystemUpdate runs the update within the service window. It returns the outcome for the
+// metrics, whether a new kernel got installed, and an error when the command should exit non-zero.
+func runSystemUpdate(cmds helper.CertificateManagerCommands, osRelease helper.OSRelease, environment helper.Environment) (string, bool, error) {
 	obmondoAPIURL := api.GetObmondoURL()
 	obmondoAPI := api.NewObmondoClient(obmondoAPIURL, false)
+	puppetService := puppet.NewService(obmondoAPI, webtee.NewWebtee(obmondoAPI))
+
+	var (
+		serviceWindowNow *api.ServiceWindow
+		skipReason       string
+	)
+	err := phase("Checking Service Window", func() error {
+		// check if the agent has been disabled
+		agentStatus, err := puppetService.Status()
+		if err != nil {
+			slog.Error("unable to read puppet agent status", slog.Any("error", err))
+			return err
+		}
+		if agentStatus.Disabled {
+			slog.Warn("puppet has been disabled, exiting", slog.String("reason", agentStatus.DisabledMessage))
+			skipReason = "puppet has been disabled: " + agentStatus.DisabledMessage
+			return nil
+		}
 
-	serviceWindowNow, err := obmondoAPI.GetServiceWindowStatus()
+		serviceWindowNow, err = obmondoAPI.GetServiceWindowStatus()
+		if err != nil {
+			slog.Error("unable to get service window status", slog.String("error", err.Error()))
+			return err
+		}
+
+		// lets fail with exit 0, otherwise systemd service will be in failed status
+		if !serviceWindowNow.IsWindowOpen {
+			slog.Warn("exiting, service window is inactive")
+			skipReason = "service window is inactive"
+		}
+		return nil
+	})
 	if err != nil {
-		slog.Error("unable to get service window status", slog.String("error", err.Error()))
-		return
+		return metrics.OutcomeFailed, false, nil
 	}
-
-	// lets fail with exit 0, otherwise systemd service will be in failed status
-	if !serviceWindowNow.IsWindowOpen {
-		slog.Warn("exiting, service window is inactive")
-		return
+	if skipReason != "" {
+		progressNote("Skipping the update, " + skipReason)
+		return metrics.OutcomeSkipped, false, nil
 	}
 
 	slog.Info("service window is active, going ahead")
 
-	if err := cmds.UpdateRepositoryList(); err != nil {
-		slog.Error("unable to update repository", slog.String("err", err.Error()))
-		os.Exit(1)
-	}
+	err = phase("Refreshing Repositories", func() error {
+		if err := cmds.UpdateRepositoryList(); err != nil {
+			slog.Error("unable to update repository", slog.String("err", err.Error()))
+			return err
+		}
 
-	if err := cmds.CheckAndInstallCaCertificates(); err != nil {
-		slog.Error("unable to check if ca certs are installed", slog.String("err", err.Error()))
-		os.Exit(1)
+		if err := cmds.CheckAndInstallCaCertificates(); err != nil {
+			slog.Error("unable to check if ca certs are installed", slog.String("err", err.Error()))
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return metrics.OutcomeFailed, false, err
 	}
 
-	puppetService := puppet.NewService(obmondoAPI, webtee.NewWebtee(obmondoAPI))
-
 	if !config.ShouldSkipOpenvox() {
-		// Check if any existing puppet agent is already running
-		puppetService.WaitForAgent(constant.PuppetWaitForCertTimeOut)
-
-		// Run puppet-agent and check the exit code, and exit this script, if it's not 0 or 2
-		if err := HandlePuppetRun(puppetService); err != nil {
-			slog.Error("unable to run puppet-agent", slog.String("error", err.Error()))
-			return
+		err := phase("Running Openvox", func() error {
+			// Check if any existing puppet agent is already running
+			puppetService.WaitForAgent(constant.PuppetWaitForCertTimeOut)
+
+			// Run puppet-agent and check the exit code, and exit this script, if it's not 0 or 2
+			if err := HandlePuppetRun(puppetService); err != nil {
+				slog.Error("unable to run puppet-agent", slog.String("error", err.Error()))
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return metrics.OutcomeFailed, false, nil
 		}
 
 		// Disable puppet-agent, since we'll be running upgrade commands
-		if err := puppetService.DisableAgent("puppet has been disabled by the system-update"); err != nil {
-			slog.Error("failed to disable agent", slog.Any("error", err))
-			return
+		err = phase("Disabling Openvox Agent", func() error {
+			if err := puppetService.DisableAgent(constant.SystemUpdateDisableMessage); err != nil {
+				slog.Error("failed to disable agent", slog.Any("error", err))
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return metrics.OutcomeFailed, false, nil
 		}
 
 		// Ensure the cleanup is done regardless of the outcome of the update script execution
 		defer cleanup(puppetService)
 	}
 
-	distribution, distIDExists := os.LookupEnv("ID")
-	if !distIDExists {
-		slog.Error("env variable ID not set")
-		return
+	family := osRelease.Family()
+	if family == "" {
+		slog.Error("unknown distribution family")
+		return metrics.OutcomeFailed, false, nil
+	}
+
+	if hooks := config.GetPreUpdateHooks(); len(hooks) > 0 {
+		err := phase("Running Pre-update Hooks", func() error {
+			return runHooks("pre-update", hooks)
+		})
+		if err != nil {
+			slog.Error("pre-update hook failed, skipping the update", slog.String("error", err.Error()))
+			return metrics.OutcomeFailed, false, nil
+		}
+	}
+
+	if config.ShouldCleanup() {
+		// nolint: errcheck
+		phase("Cleaning Up", func() error {
+			cleanupBeforeUpdate(family, config.GetKeepKernels(), environment)
+			return nil
+		})
+	}
+
+	// Refuse to start an upgrade that doesn't fit on disk
+	err = phase("Checking Disk Space", func() error {
+		return disk.CheckUpgradeSpace(family)
+	})
+	if err != nil {
+		slog.Error("not enough disk space for the update", slog.String("error", err.Error()))
+		return metrics.OutcomeFailed, false, nil
 	}
 
 	// Apt/Yum/Zypper update
-	if err := UpdateSystem(distribution); err != nil {
+	const upgradeDescription = "Upgrading Packages"
+	err = phase(upgradeDescription, func() error {
+		return UpdateSystem(family, upgradeOutput(family, upgradeDescription))
+	})
+	if err != nil {
 		slog.Error("unable to update system", slog.String("error", err.Error()))
-		return
+		return metrics.OutcomeFailed, false, nil
 	}
 
-	securityExporterService := security.NewSecurityExporter(securityExporterURL)
-	if _, err := securityExporterService.GetNumberOfPackageUpdates(); err != nil {
-		slog.Error("failed to get response from security exporter for number of package updates endpoint", slog.Any("error", err))
+	if hooks := config.GetPostUpdateHooks(); len(hooks) > 0 {
+		err := phase("Running Post-update Hooks", func() error {
+			return runHooks("post-update", hooks)
+		})
+		if err != nil {
+			slog.Error("post-update hook failed", slog.String("error", err.Error()))
+		}
 	}
 
-	// Close the service window
-	// we need to close it with diff close msg, incase if there is a failure, but that's for later
-	if err := obmondoAPI.CloseServiceWindow(serviceWindowNow.WindowType, helper.GetCertname(), serviceWindowNow.Timezone); err != nil {
-		slog.Error("unable to close the service window", slog.String("error", err.Error()))
-		return
+	err = phase("Closing Service Window", func() error {
+		securityExporterService := security.NewSecurityExporter(securityExporterURL)
+		if _, err := securityExporterService.GetNumberOfPackageUpdates(); err != nil {
+			slog.Error("failed to get response from security exporter for number of package updates endpoint", slog.Any("error", err))
+		}
+
+		// Close the service window
+		// we need to close it with diff close msg, incase if there is a failure, but that's for later
+		if err := obmondoAPI.CloseServiceWindow(serviceWindowNow.WindowType, helper.GetCertname(), serviceWindowNow.Timezone); err != nil {
+			slog.Error("unable to close the service window", slog.String("error", err.Error()))
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return metrics.OutcomeFailed, false, nil
 	}
 
 	slog.Info("service window is closed now for this respective node")
@@ -315,10 +477,28 @@ func SystemUpdate() {
 	// otherwise reboot won't be triggered
 	cleanup(puppetService)
 
-	if err := CheckKernelAndRebootIfNeeded(); err != nil {
-		slog.Error("unable to check kernel and reboot", slog.String("error", err.Error()))
-		return
+	// The host owns the kernel of a container and reboots it
+	if environment.IsContainer() {
+		slog.Info("skipping the kernel check in a container", slog.String("technology", environment.Technology))
+		return metrics.OutcomeSuccess, false, nil
+	}
+
+	var newKernel bool
+	err = phase("Checking Kernel", func() error {
+		newKernel, err = IsNewKernelInstalled()
+		if err != nil {
+			slog.Error("unable to check kernel and reboot", slog.String("error", err.Error()))
+		}
+		return err
+	})
+	if err != nil {
+		return metrics.OutcomeFailed, false, nil
+	}
+	if newKernel {
+		progressNote("A newer kernel is installed, the node needs a reboot")
 	}
+
+	return metrics.OutcomeSuccess, newKernel, nil
 }
 
 func init() {
@@ -326,13 +506,13 @@ func init() {
 
 	systemUpdateCmd.Flags().BoolVar(&rebootFlag, constant.CobraFlagNoReboot, false, "Set this flag to prevent reboot (default will reboot)")
 	systemUpdateCmd.Flags().BoolVar(&skipOpenvoxFlag, constant.CobraFlagSkipOpenvox, false, "Set this flag to prevent running openvox")
+	systemUpdateCmd.Flags().BoolVar(&plainFlag, constant.CobraFlagPlain, false, "Print the output of the update instead of the progress, implied when stdout is not a terminal")
 
 	// Bind flags to viper
-	v := config.GetViperInstance()
-	v.BindPFlag(constant.CobraFlagNoReboot, systemUpdateCmd.Flags().Lookup(constant.CobraFlagNoReboot))
-	v.BindPFlag(constant.CobraFlagSkipOpenvox, systemUpdateCmd.Flags().Lookup(constant.CobraFlagSkipOpenvox))
+	config.BindPFlag(constant.CobraFlagNoReboot, systemUpdateCmd.Flags().Lookup(constant.CobraFlagNoReboot))
+	config.BindPFlag(constant.CobraFlagSkipOpenvox, systemUpdateCmd.Flags().Lookup(constant.CobraFlagSkipOpenvox))
 
 	// Bind environment variables
-	v.BindEnv(constant.CobraFlagNoReboot, "NO_REBOOT")
-	v.BindEnv(constant.CobraFlagSkipOpenvox, "SKIP_OPENVOX")
+	config.BindEnv(constant.CobraFlagNoReboot, "NO_REBOOT")
+	config.BindEnv(constant.CobraFlagSkipOpenvox, "SKIP_OPENVOX")
 }
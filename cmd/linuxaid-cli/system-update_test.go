@@ -2,6 +2,7 @@ package main
 
 import (
 	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 
@@ -65,3 +66,50 @@ func TestGetInstalledKernel(t *testing.T) {
 }
 
 // Need tests for 204 and 208 and a failed scenario as well
+
+func TestOldDebianKernels(t *testing.T) {
+	installed := `ii  linux-image-6.1.0-9-amd64
+ii  linux-image-6.1.0-18-amd64
+rc  linux-image-5.10.0-28-amd64
+ii  linux-image-6.1.0-10-amd64
+ii  linux-image-amd64
+ii  linux-image-unsigned-6.1.0-7-amd64
+`
+	got := oldDebianKernels(installed, "6.1.0-9-amd64", 2)
+	want := []string{"linux-image-unsigned-6.1.0-7-amd64"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	got = oldDebianKernels(installed, "6.1.0-18-amd64", 1)
+	want = []string{"linux-image-6.1.0-10-amd64", "linux-image-6.1.0-9-amd64", "linux-image-unsigned-6.1.0-7-amd64"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestPackageCounter(t *testing.T) {
+	tests := []struct {
+		family string
+		output string
+		want   [2]int
+	}{
+		{helper.FamilyDebian, "3 upgraded, 1 newly installed, 0 to remove and 0 not upgraded.\nUnpacking bash (5.2.15-2+b7) over (5.2.15-2+b2) ...\nSetting up bash (5.2.15-2+b7) ...\nUnpacking curl (7.88.1-10+deb12u6) over (7.88.1-10+deb12u5) ...\n", [2]int{2, 4}},
+		{helper.FamilyRedHat, "  Upgrading        : bash-5.1.8-9.el9.x86_64          1/40 \n  Cleanup          : bash-5.1.8-6.el9.x86_64         21/40 \n", [2]int{21, 40}},
+		{helper.FamilyRedHat, "[ 3/12] Upgrading curl-0:8.6.0-7.fc40.x86_64 100% |  5.2 MiB/s |  10.6 KiB |  00m00s\n", [2]int{3, 12}},
+		{helper.FamilySUSE, "Retrieving: bash-4.4-150400.27.3.2.x86_64.rpm (1/9)\n( 2/9) Installing: bash-4.4-150400.27.3.2.x86_64 [....done]\n", [2]int{2, 9}},
+	}
+	for _, tt := range tests {
+		var got [2]int
+		counter := &packageCounter{family: tt.family, report: func(done, total int) { got = [2]int{done, total} }}
+		// The output comes in chunks that don't end on a line
+		for chunk := range slices.Chunk([]byte(tt.output), 7) {
+			if _, err := counter.Write(chunk); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.family, got, tt.want)
+		}
+	}
+}
@@ -2,9 +2,13 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"os"
 	"strings"
+	"time"
 
 	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper/logger"
 	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper/progress"
@@ -20,20 +24,19 @@ import (
 	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper"
 )
 
-func compatibilityCheck(puppetService *puppet.Service) error {
+func compatibilityCheck(puppetService *puppet.Service, osRelease helper.OSRelease) error {
 	// Sanity check
-	helper.LoadOSReleaseEnv()
 	helper.RequireRootUser()
 
-	// Check required envs and OS
-	helper.RequireOSNameEnv()
-	helper.RequireOSVersionEnv()
-	if _, err := helper.IsSupportedOS(); err != nil {
+	slog.Info("installing on", slog.String("os", osRelease.String()), slog.Any("environment", helper.DetectEnvironment()))
+
+	// Check the OS
+	if _, err := helper.IsSupportedOS(osRelease); err != nil {
 		slog.Error("OS not supported", slog.String("err", err.Error()))
 		return err
 	}
 
-	if err := disk.CheckDiskSize(); err != nil {
+	if err := disk.CheckInstallSize(); err != nil {
 		prettyfmt.PrettyPrintln(prettyfmt.FontRed("check disk size failed: ", err.Error()))
 		return err
 	}
@@ -52,86 +55,257 @@ func compatibilityCheck(puppetService *puppet.Service) error {
 	return nil
 }
 
-func Install() {
+// installResult is what linuxaid-install did, printed as JSON with --json
+type installResult struct {
+	Status       string              `json:"status"`
+	Certname     string              `json:"certname"`
+	PuppetServer string              `json:"puppet_server"`
+	Version      string              `json:"version"`
+	RunID        string              `json:"run_id"`
+	Error        string              `json:"error,omitempty"`
+	Steps        []installStepResult `json:"steps"`
+}
+
+type installStepResult struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	Duration float64 `json:"duration_seconds"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// Install statuses, aborted means nothing was changed on purpose
+const (
+	installStatusSuccess = "success"
+	installStatusFailed  = "failed"
+	installStatusAborted = "aborted"
+	installStatusSkipped = "skipped"
+)
+
+var errAgentDisabled = errors.New("openvox agent is disabled")
+
+// skipStep records a step an earlier install already did
+func (r *installResult) skipStep(name string) {
+	prettyfmt.PrettyPrintf("%s %s %s\n", prettyfmt.FontGreen(prettyfmt.IconCheckPass), prettyfmt.FontWhite(name), prettyfmt.FontYellow("(already done)"))
+	r.Steps = append(r.Steps, installStepResult{Name: name, Status: installStatusSkipped})
+}
+
+// runStep runs one step of the install behind the progress bar and records its outcome
+func (r *installResult) runStep(name string, step func() error) error {
+	start := time.Now()
+	err := progress.NonDeterministicFunc(name, step)
+
+	stepResult := installStepResult{Name: name, Status: installStatusSuccess, Duration: time.Since(start).Seconds()}
+	if err != nil {
+		stepResult.Status = installStatusFailed
+		stepResult.Error = err.Error()
+		r.Status = installStatusFailed
+		r.Error = fmt.Sprintf("%s: %s", name, err)
+	}
+	r.Steps = append(r.Steps, stepResult)
+
+	return err
+}
+
+// print prints the result as JSON on stdout with --json and exits non-zero when the install failed
+func (r *installResult) print() {
+	if jsonFlag {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(r); err != nil {
+			slog.Error("failed to encode the install result", slog.Any("error", err))
+		}
+	}
+
+	if r.Status == installStatusFailed {
+		os.Exit(1)
+	}
+}
+
+// confirmInstall asks before touching the system, unless running unattended
+func confirmInstall() bool {
+	if yesFlag || nonInteractiveFlag {
+		return true
+	}
+
+	if !helper.IsTerminal(os.Stdin) {
+		slog.Info("stdin is not a terminal, continuing without confirmation")
+		return true
+	}
+
+	prettyfmt.PrettyPrintf(" %s  Running this tool will install and configure %s in your system.\n %s Please confirm to continue (Yes/No)? ", prettyfmt.IconGear, prettyfmt.FontYellow("Openvox agent"), prettyfmt.IconQuestion)
+
+	// Accept user input for confirmation
+	reader := bufio.NewReader(os.Stdin)
+	input, _ := reader.ReadString('\n')
+	input = strings.ToLower(strings.TrimSpace(input))
+
+	return input == "y" || input == "yes"
+}
+
+func Install(version string) *installResult {
+	certname := helper.GetCertname()
+	puppetServer := helper.GetPuppetServer()
+	result := &installResult{
+		Status:       installStatusSuccess,
+		Certname:     certname,
+		PuppetServer: puppetServer,
+		Version:      version,
+		RunID:        logger.RunID(),
+		Steps:        []installStepResult{},
+	}
+
 	// Re-initialise the logger with progressbar writer to not disturb the
 	// progressbar if we print any logs. Everything is handled by progressbar's
 	// Bprintf method under the hood.
 	pbWriter := progress.InitProgressBar()
-	logger.InitLogger(pbWriter, config.IsDebug())
+	logOptions := logger.ConfigOptions()
+	logOptions.Writer = pbWriter
+	if err := logger.Init(logOptions); err != nil {
+		slog.Warn("unable to log through the progressbar", slog.Any("error", err))
+	}
+
+	osRelease, err := helper.ReadOSRelease()
+	if err != nil {
+		slog.Error("unable to determine the distribution", slog.Any("error", err))
+		result.Status = installStatusFailed
+		result.Error = err.Error()
+		return result
+	}
 
-	certname := helper.GetCertname()
-	puppetServer := config.GetPupeptServer()
 	obmondoAPIURL := api.GetObmondoURL()
 	obmondoAPI := api.NewObmondoClient(obmondoAPIURL, true)
 	webtee := webtee.NewWebtee(obmondoAPI)
 	puppetService := puppet.NewService(obmondoAPI, webtee)
-	provisioner := provisioner.NewService(obmondoAPI, puppetService, webtee)
+	provisioner := provisioner.NewService(obmondoAPI, puppetService, webtee, osRelease)
 
-	webtee.RemoteLogObmondo([]string{"echo Starting Linuxaid Install Setup "}, certname)
+	webtee.Send([]string{"Starting Linuxaid Install Setup"}, certname)
 	prettyfmt.PrettyPrintf(" %s  %s %s %s %s\n", prettyfmt.IconGear, prettyfmt.FontWhite("Configuring Linuxaid on"), prettyfmt.FontYellow(certname), prettyfmt.FontWhite("with puppetserver"), prettyfmt.FontYellow(puppetServer))
-	prettyfmt.PrettyPrintf(" %s  Running this tool will install and configure %s in your system.\n %s Please confirm to continue (Yes/No)? ", prettyfmt.IconGear, prettyfmt.FontYellow("Openvox agent"), prettyfmt.IconQuestion)
 
-	// Accept user input for confirmation
-	reader := bufio.NewReader(os.Stdin)
-	input, _ := reader.ReadString('\n')
-	input = strings.TrimSpace(input)
-
-	if input != "y" && input != "yes" {
+	if !confirmInstall() {
 		prettyfmt.PrettyPrintf("\n Exiting the setup...\n")
-		return
+		result.Status = installStatusAborted
+		result.Error = "not confirmed"
+		return result
 	}
 
 	// Dummy new line for better clarity of things
 	prettyfmt.PrettyPrintln("")
 
-	if err := progress.NonDeterministicFunc("Verifying Token", func() error {
-		input := &api.InstallScriptInput{
-			Certname: certname,
-			Token:    os.Getenv(constant.InstallTokenEnv),
-		}
+	state, err := loadInstallState(constant.InstallStateFile, certname, provisioner.WantedVersion())
+	if err == nil && fromStepFlag != "" {
+		err = state.forgetFrom(fromStepFlag)
+	}
+	if err != nil {
+		slog.Error("unable to resume the install", slog.Any("error", err))
+		result.Status = installStatusFailed
+		result.Error = err.Error()
+		return result
+	}
+	if state.resumed() {
+		slog.Info("resuming an earlier install", slog.String("state", constant.InstallStateFile))
+	}
 
-		return obmondoAPI.VerifyInstallToken(input)
-	}); err != nil {
-		os.Exit(1)
+	steps := []installStep{
+		{
+			name:        stepVerifyToken,
+			description: "Verifying Token",
+			run: func() error {
+				input := &api.InstallScriptInput{
+					Certname: certname,
+					Token:    config.Getenv(constant.InstallTokenEnv),
+				}
+
+				return obmondoAPI.VerifyInstallToken(input)
+			},
+		},
+		{
+			name:        stepCompatibility,
+			description: "Checking Compatibility",
+			run: func() error {
+				if err := compatibilityCheck(puppetService, osRelease); err != nil {
+					return err
+				}
+				if err := provisioner.CheckSupported(); err != nil {
+					return err
+				}
+
+				// An agent disabled by hand stops a new install, not one we're resuming
+				if agentStatus, err := puppetService.Status(); err == nil && agentStatus.Disabled && !state.resumed() {
+					prettyfmt.PrettyPrintln(prettyfmt.FontRed("Openvox has been disabled from the existing setup, can't proceed\npuppet agent --enable will enable the puppet agent\n"))
+					webtee.Send([]string{"Exiting, openvox-agent is already installed and set to disabled"}, certname)
+					return errAgentDisabled
+				}
+				return nil
+			},
+			// The checks are cheap and set up PATH for the other steps
+			done: func(bool) bool { return false },
+		},
+		{
+			name:        stepDownload,
+			description: provisioner.DownloadDescription(),
+			run:         provisioner.DownloadAgent,
+			done: func(completed bool) bool {
+				return provisioner.HasAgentVersion() || (completed && provisioner.IsDownloaded())
+			},
+		},
+		{
+			name:        stepInstallPackage,
+			description: "Installing Openvox",
+			run:         provisioner.InstallAgent,
+			done: func(bool) bool {
+				return provisioner.HasAgentVersion()
+			},
+		},
+		{
+			name:        stepConfigure,
+			description: "Configuring Openvox",
+			run: func() error {
+				if err := puppetService.DisableAgentService(); err != nil {
+					// nolint:errcheck
+					obmondoAPI.NotifyInstallScriptFailure(&api.InstallScriptInput{
+						Certname: certname,
+					})
+					return err
+				}
+				return puppetService.ConfigureAgent()
+			},
+		},
+		{
+			name:        stepFacter,
+			description: "Setting up Facter",
+			run:         puppetService.FacterNewSetup,
+		},
+		{
+			name:        stepFirstRun,
+			description: "Running Openvox",
+			run: func() error {
+				puppetService.WaitForAgent(constant.PuppetWaitForCertTimeOut)
+				exitCode := puppetService.RunAgent(&puppet.RunOptions{Mode: puppet.RunModeNoop, RemoteLog: true})
+				if !puppet.IsSuccessExitCode(exitCode) {
+					slog.Error("openvox agent run failed", slog.Int("exit_code", exitCode), slog.String("status", puppet.DescribeExitCode(exitCode)))
+					// nolint:errcheck
+					obmondoAPI.NotifyInstallScriptFailure(&api.InstallScriptInput{
+						Certname: certname,
+					})
+					return fmt.Errorf("openvox agent run failed with exit code %d (%s)", exitCode, puppet.DescribeExitCode(exitCode))
+				}
+				// nolint:errcheck
+				puppetService.UpdateLastRunReport()
+				return nil
+			},
+		},
 	}
 
-	if err := progress.NonDeterministicFunc("Checking Compatibility", func() error {
-		return compatibilityCheck(puppetService)
-	}); err != nil {
-		os.Exit(1)
+	if err := result.runSteps(state, constant.InstallStateFile, steps); err != nil {
+		if errors.Is(err, errAgentDisabled) {
+			result.Status = installStatusAborted
+		}
+		return result
 	}
 
-	// check if agent disable file exists
-	if _, err := os.Stat(constant.AgentDisabledLockFile); err == nil {
-		prettyfmt.PrettyPrintln(prettyfmt.FontRed("Openvox has been disabled from the existing setup, can't proceed\npuppet agent --enable will enable the puppet agent\n"))
-		webtee.RemoteLogObmondo([]string{"echo Exiting, openvox-agent is already installed and set to disabled"}, helper.GetCertname())
-		os.Exit(0)
-	}
-
-	// nolint: errcheck
-	progress.NonDeterministicFunc("Installing Openvox", func() error {
-		provisioner.ProvisionPuppet()
-		return nil
-	})
-
-	// nolint: errcheck
-	progress.NonDeterministicFunc("Configuring Openvox", func() error {
-		puppetService.DisableAgentService()
-		puppetService.ConfigureAgent()
-		puppetService.FacterNewSetup()
-		return nil
-	})
-
-	// nolint: errcheck
-	progress.NonDeterministicFunc("Running Openvox", func() error {
-		puppetService.WaitForAgent(constant.PuppetWaitForCertTimeOut)
-		puppetService.RunAgent(true, "noop")
-		// nolint:errcheck
-		obmondoAPI.UpdatePuppetLastRunReport()
-		return nil
-	})
-
-	webtee.RemoteLogObmondo([]string{"echo Finished Obmondo Setup "}, certname)
+	webtee.Send([]string{"Finished Obmondo Setup"}, certname)
 	prettyfmt.PrettyPrintln("\n ", prettyfmt.IconSuccess, prettyfmt.FontGreen("Success!"))
 	prettyfmt.PrettyPrintf("\n %s %s %s\n", prettyfmt.FontWhite("Head to"), prettyfmt.FontBlue("https://obmondo.com/user/servers"), prettyfmt.FontWhite("to add role and subscription."))
+
+	return result
 }
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"errors"
 	"log/slog"
 	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
 
@@ -10,6 +12,7 @@ import (
 	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
 	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper"
 	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper/logger"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper/progress"
 	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/prettyfmt"
 )
 
@@ -19,6 +22,17 @@ var (
 	debugFlag        bool
 	certNameFlag     string
 	puppetServerFlag string
+	logFormatFlag    string
+	logOutputFlag    string
+	logFileFlag      string
+
+	yesFlag            bool
+	nonInteractiveFlag bool
+	plainFlag          bool
+	jsonFlag           bool
+	fromStepFlag       string
+	agentVersionFlag   string
+	directDownloadFlag bool
 )
 
 var rootCmd = &cobra.Command{
@@ -27,62 +41,125 @@ var rootCmd = &cobra.Command{
 	Example: `
 	$ TOKEN='your-token'
 	$ linuxaid-install --certname web01.example --puppet-server your.openvoxserver.com
+	$ linuxaid-install --certname web01.example --non-interactive --json
+	$ linuxaid-install --certname web01.example --from-step configure
 	`,
 	Version: Version,
 	CompletionOptions: cobra.CompletionOptions{
 		HiddenDefaultCmd: true,
 	},
 	PreRunE: func(cmd *cobra.Command, _ []string) error {
-		logger.InitLogger(nil, config.IsDebug())
+		setupOutput()
+
+		// Config files are read first, they can turn on debug logging
+		configErr := config.Load()
+		if err := logger.Init(logger.ConfigOptions()); err != nil {
+			return err
+		}
+		if configErr != nil {
+			preflightFailed(cmd, configErr)
+		}
 
 		// Print version first
 		prettyfmt.PrettyPrintf("\n %s  %s version %s\n", prettyfmt.IconGear, prettyfmt.FontWhite(cmd.Root().Name()), prettyfmt.FontYellow(cmd.Root().Version))
 
 		// Get certname from viper (cert, flag, or env)
-		certName := helper.GetCertname()
-		if certName == "" {
+		resolution, err := helper.ResolveCertname()
+		if errors.Is(err, helper.ErrNoCertname) {
 			errMsg := "Uh ho. I couldn't figure out the certname, please provide one as an ENV"
 			prettyfmt.PrettyPrintf("\n %s %s %s\n", prettyfmt.IconCheckFail, prettyfmt.FontWhite(errMsg), prettyfmt.FontYellow("CERTNAME"))
 
 			slog.Debug("certname is required. Provide via --certname flag or CERTNAME environment variable")
-			os.Exit(1)
+			preflightFailed(cmd, err)
 		}
+		if err != nil {
+			prettyfmt.PrettyPrintf("\n %s %s %s\n", prettyfmt.IconCheckFail, prettyfmt.FontWhite("Uh ho. The certname is not usable:"), prettyfmt.FontYellow(err.Error()))
+			preflightFailed(cmd, err)
+		}
+		helper.SetResolvedCertname(resolution)
 
-		if _, isSet := os.LookupEnv(constant.InstallTokenEnv); !isSet {
+		if _, isSet := config.LookupEnv(constant.InstallTokenEnv); !isSet {
 			errMsg := "Uh ho. I couldn't figure out the token, please provide one as an ENV"
 			prettyfmt.PrettyPrintf("\n %s %s %s\n", prettyfmt.IconCheckFail, prettyfmt.FontWhite(errMsg), prettyfmt.FontYellow(constant.InstallTokenEnv))
 
 			slog.Debug("install token is required. Provide via INSTALL_TOKEN environment variable")
-			os.Exit(1)
+			preflightFailed(cmd, errors.New("no install token given in "+constant.InstallTokenEnv))
 		}
 
 		return nil
 	},
-	Run: func(*cobra.Command, []string) {
-		Install()
+	Run: func(cmd *cobra.Command, _ []string) {
+		Install(cmd.Root().Version).print()
 	},
 }
 
-func init() {
-	defaultServer := constant.DefaultPuppetServerCustomerID + constant.DefaultPuppetServerDomainSuffix
+// setupOutput drops the progress bar and emoji when nobody is watching a terminal,
+// with --json stdout is kept for the result alone
+func setupOutput() {
+	if plainFlag || nonInteractiveFlag || jsonFlag || !helper.IsTerminal(os.Stdout) {
+		prettyfmt.SetPlain()
+		progress.SetPlain()
+	}
+	if jsonFlag {
+		prettyfmt.SetOutput(os.Stderr)
+	}
+}
+
+// preflightFailed reports a failure before the install started and exits
+func preflightFailed(cmd *cobra.Command, err error) {
+	result := &installResult{
+		Status:       installStatusFailed,
+		Certname:     helper.GetCertname(),
+		PuppetServer: helper.GetPuppetServer(),
+		Version:      cmd.Root().Version,
+		RunID:        logger.RunID(),
+		Error:        err.Error(),
+		Steps:        []installStepResult{},
+	}
+	result.print()
+}
 
+func init() {
 	rootCmd.Flags().BoolVar(&debugFlag, "debug", false, "Enable debug logs")
 	rootCmd.Flags().StringVar(&certNameFlag, constant.CobraFlagCertname, "", "Certificate name (required)")
-	rootCmd.Flags().StringVar(&puppetServerFlag, constant.CobraFlagPuppetServer, defaultServer, "Puppet server hostname")
+	rootCmd.Flags().StringVar(&puppetServerFlag, constant.CobraFlagPuppetServer, "", "Puppet server hostname, defaults to the server in puppet.conf or <customer id>.puppet.obmondo.com")
+
+	rootCmd.Flags().BoolVarP(&yesFlag, constant.CobraFlagYes, "y", false, "Don't ask for confirmation")
+	rootCmd.Flags().BoolVar(&nonInteractiveFlag, constant.CobraFlagNonInteractive, false, "Don't ask for confirmation and print plain output, implied when stdin is not a terminal")
+	rootCmd.Flags().BoolVar(&plainFlag, constant.CobraFlagPlain, false, "Print plain output without progress bar, colors and emoji")
+	rootCmd.Flags().BoolVar(&jsonFlag, constant.CobraFlagJSON, false, "Print the result as JSON on stdout, everything else goes to stderr")
+	rootCmd.Flags().StringVar(&fromStepFlag, constant.CobraFlagFromStep, "", "Run this step and the ones after it again: "+strings.Join(installStepNames, ", "))
+	rootCmd.Flags().StringVar(&agentVersionFlag, constant.CobraFlagAgentVersion, "", "Install this openvox-agent version instead of the latest one")
+	rootCmd.Flags().BoolVar(&directDownloadFlag, constant.CobraFlagDirectDownload, false, "Install a downloaded openvox-agent package instead of adding the Obmondo repository")
+	rootCmd.Flags().StringVar(&logFormatFlag, constant.CobraFlagLogFormat, logger.FormatLogfmt, "Log format: text, json or logfmt")
+	rootCmd.Flags().StringVar(&logOutputFlag, constant.CobraFlagLogOutput, logger.OutputStderr, "Log output: stderr, file or journald")
+	rootCmd.Flags().StringVar(&logFileFlag, constant.CobraFlagLogFile, constant.DefaultInstallLogFile, "Log file for --log-output file, rotated at 10MiB")
 
 	// Bind flags to viper
 	v := config.GetViperInstance()
-	v.BindPFlag(constant.CobraFlagDebug, rootCmd.Flags().Lookup(constant.CobraFlagDebug))
-	v.BindPFlag(constant.CobraFlagCertname, rootCmd.Flags().Lookup(constant.CobraFlagCertname))
-	v.BindPFlag(constant.CobraFlagPuppetServer, rootCmd.Flags().Lookup(constant.CobraFlagPuppetServer))
+	config.BindPFlag(constant.CobraFlagDebug, rootCmd.Flags().Lookup(constant.CobraFlagDebug))
+	config.BindPFlag(constant.CobraFlagCertname, rootCmd.Flags().Lookup(constant.CobraFlagCertname))
+	config.BindPFlag(constant.CobraFlagPuppetServer, rootCmd.Flags().Lookup(constant.CobraFlagPuppetServer))
+	config.BindPFlag(constant.CobraFlagAgentVersion, rootCmd.Flags().Lookup(constant.CobraFlagAgentVersion))
+	config.BindPFlag(constant.CobraFlagDirectDownload, rootCmd.Flags().Lookup(constant.CobraFlagDirectDownload))
+	config.BindPFlag(constant.CobraFlagLogFormat, rootCmd.Flags().Lookup(constant.CobraFlagLogFormat))
+	config.BindPFlag(constant.CobraFlagLogOutput, rootCmd.Flags().Lookup(constant.CobraFlagLogOutput))
+	config.BindPFlag(constant.CobraFlagLogFile, rootCmd.Flags().Lookup(constant.CobraFlagLogFile))
 
 	// Bind environment variables
-	v.BindEnv(constant.CobraFlagDebug)
-	v.BindEnv(constant.CobraFlagCertname)
-	v.BindEnv(constant.CobraFlagPuppetServer, "PUPPET_SERVER")
+	config.BindEnv(constant.CobraFlagDebug)
+	config.BindEnv(constant.CobraFlagCertname)
+	config.BindEnv(constant.CobraFlagPuppetServer, "PUPPET_SERVER")
+	config.BindEnv(constant.CobraFlagAgentVersion, "AGENT_VERSION")
+	config.BindEnv(constant.CobraFlagDirectDownload, "DIRECT_DOWNLOAD")
+	config.BindEnv(constant.CobraFlagLogFormat, "LOG_FORMAT")
+	config.BindEnv(constant.CobraFlagLogOutput, "LOG_OUTPUT")
+	config.BindEnv(constant.CobraFlagLogFile, "LOG_FILE")
 
 	// Set default values
-	v.SetDefault(constant.CobraFlagPuppetServer, defaultServer)
+	v.SetDefault(constant.CobraFlagLogFormat, logger.FormatLogfmt)
+	v.SetDefault(constant.CobraFlagLogOutput, logger.OutputStderr)
+	v.SetDefault(constant.CobraFlagLogFile, constant.DefaultInstallLogFile)
 }
 
 func main() {
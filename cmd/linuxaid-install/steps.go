@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// Install steps, in the order they run
+const (
+	stepVerifyToken    = "verify-token"
+	stepCompatibility  = "compatibility"
+	stepDownload       = "download"
+	stepInstallPackage = "install-package"
+	stepConfigure      = "configure"
+	stepFacter         = "facter"
+	stepFirstRun       = "first-run"
+)
+
+var installStepNames = []string{
+	stepVerifyToken,
+	stepCompatibility,
+	stepDownload,
+	stepInstallPackage,
+	stepConfigure,
+	stepFacter,
+	stepFirstRun,
+}
+
+// installStep is one step of the install, steps completed by an earlier run are skipped
+type installStep struct {
+	name        string
+	description string
+	run         func() error
+	// done overrides the persisted state, it's told whether the step completed before
+	done func(completed bool) bool
+}
+
+// installState is what an earlier linuxaid-install on this node got done
+type installState struct {
+	Certname     string               `json:"certname"`
+	AgentVersion string               `json:"agent_version"`
+	Completed    map[string]time.Time `json:"completed"`
+}
+
+// loadInstallState reads the state of an earlier install, a state for another certname
+// or agent version doesn't count. An empty agentVersion is the latest one from the repository.
+func loadInstallState(path, certname, agentVersion string) (*installState, error) {
+	fresh := &installState{Certname: certname, AgentVersion: agentVersion, Completed: map[string]time.Time{}}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fresh, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the install state: %w", err)
+	}
+
+	var state installState
+	if err := json.Unmarshal(data, &state); err != nil {
+		slog.Warn("ignoring unreadable install state", slog.String("path", path), slog.Any("error", err))
+		return fresh, nil
+	}
+	if state.Certname != certname || state.AgentVersion != agentVersion || state.Completed == nil {
+		slog.Info("ignoring the install state of an earlier install", slog.String("certname", state.Certname), slog.String("agent_version", state.AgentVersion))
+		return fresh, nil
+	}
+
+	return &state, nil
+}
+
+func (s *installState) save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// resumed tells if an earlier install got past its first step
+func (s *installState) resumed() bool {
+	return len(s.Completed) > 0
+}
+
+// forgetFrom drops the completion of step and every step after it, so they run again
+func (s *installState) forgetFrom(step string) error {
+	index := slices.Index(installStepNames, step)
+	if index < 0 {
+		return fmt.Errorf("unknown step %q, valid steps are %v", step, installStepNames)
+	}
+
+	for _, name := range installStepNames[index:] {
+		delete(s.Completed, name)
+	}
+	return nil
+}
+
+// runSteps runs the steps in order and stops at the first failure, completed steps are
+// persisted as they finish so a rerun picks up where this one stopped
+func (r *installResult) runSteps(state *installState, statePath string, steps []installStep) error {
+	for _, step := range steps {
+		_, completed := state.Completed[step.name]
+		done := completed
+		if step.done != nil {
+			done = step.done(completed)
+		}
+
+		if done {
+			r.skipStep(step.description)
+			continue
+		}
+
+		if err := r.runStep(step.description, step.run); err != nil {
+			return err
+		}
+
+		state.Completed[step.name] = time.Now()
+		if err := state.save(statePath); err != nil {
+			slog.Warn("failed to save the install state", slog.String("path", statePath), slog.Any("error", err))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper/progress"
+)
+
+func TestRunStepsResumes(t *testing.T) {
+	progress.SetPlain()
+	statePath := filepath.Join(t.TempDir(), "install-state.json")
+
+	var ran []string
+	failDownload := true
+	steps := func() []installStep {
+		step := func(name string) installStep {
+			return installStep{name: name, description: name, run: func() error {
+				ran = append(ran, name)
+				if name == stepDownload && failDownload {
+					return errors.New("connection reset")
+				}
+				return nil
+			}}
+		}
+		return []installStep{step(stepVerifyToken), step(stepDownload), step(stepConfigure)}
+	}
+
+	state, err := loadInstallState(statePath, "web01.example", "8.23.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := &installResult{}
+	if err := result.runSteps(state, statePath, steps()); err == nil {
+		t.Fatal("expected the download to fail")
+	}
+	if want := []string{stepVerifyToken, stepDownload}; !slices.Equal(ran, want) {
+		t.Errorf("first run ran %v, want %v", ran, want)
+	}
+
+	// The rerun picks up at the failed step
+	ran, failDownload = nil, false
+	state, err = loadInstallState(statePath, "web01.example", "8.23.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result = &installResult{}
+	if err := result.runSteps(state, statePath, steps()); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{stepDownload, stepConfigure}; !slices.Equal(ran, want) {
+		t.Errorf("rerun ran %v, want %v", ran, want)
+	}
+	if result.Steps[0].Status != installStatusSkipped {
+		t.Errorf("expected %s to be skipped, got %s", stepVerifyToken, result.Steps[0].Status)
+	}
+
+	// --from-step runs the step and the ones after it again
+	ran = nil
+	state, err = loadInstallState(statePath, "web01.example", "8.23.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := state.forgetFrom(stepDownload); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&installResult{}).runSteps(state, statePath, steps()); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{stepDownload, stepConfigure}; !slices.Equal(ran, want) {
+		t.Errorf("--from-step ran %v, want %v", ran, want)
+	}
+
+	if err := state.forgetFrom("reboot"); err == nil {
+		t.Error("expected an unknown step to be refused")
+	}
+
+	// The state of another node doesn't count
+	state, err = loadInstallState(statePath, "web02.example", "8.23.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.resumed() {
+		t.Error("expected a fresh state for another certname")
+	}
+
+	// Nor does the state of an install of another agent version
+	for _, version := range []string{"8.24.0", ""} {
+		state, err = loadInstallState(statePath, "web01.example", version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state.resumed() {
+			t.Errorf("expected a fresh state for agent version %q", version)
+		}
+	}
+}
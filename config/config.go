@@ -1,6 +1,9 @@
 package config
 
 import (
+	"strings"
+	"time"
+
 	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
 	"github.com/spf13/viper"
 )
@@ -11,6 +14,16 @@ func initIfNil() {
 	if viperConfig == nil {
 		viperConfig = viper.New()
 		viperConfig.AutomaticEnv()
+
+		// Keys only set from config files, so config show lists them too
+		viperConfig.SetDefault(constant.ConfigKeyAPIURL, "")
+		viperConfig.SetDefault(constant.ConfigKeyExcludes, []string{})
+		viperConfig.SetDefault(constant.ConfigKeyPreUpdateHooks, []string{})
+		viperConfig.SetDefault(constant.ConfigKeyPostUpdateHooks, []string{})
+		viperConfig.SetDefault(constant.ConfigKeyDiskThresholds, map[string]string{})
+		viperConfig.SetDefault(constant.ConfigKeyDiskMinInodes, "5%")
+		viperConfig.SetDefault(constant.ConfigKeyCleanup, false)
+		viperConfig.SetDefault(constant.ConfigKeyKeepKernels, 2)
 	}
 }
 
@@ -39,6 +52,160 @@ func ShouldSkipOpenvox() bool {
 	return viperConfig.GetBool(constant.CobraFlagSkipOpenvox)
 }
 
+func GetOpenvoxMode() string {
+	initIfNil()
+	return viperConfig.GetString(constant.CobraFlagMode)
+}
+
+func GetOpenvoxEnvironment() string {
+	initIfNil()
+	return viperConfig.GetString(constant.CobraFlagEnvironment)
+}
+
+func GetOpenvoxTags() []string {
+	initIfNil()
+	return splitList(viperConfig.GetStringSlice(constant.CobraFlagTags))
+}
+
+func GetOpenvoxSkipTags() []string {
+	initIfNil()
+	return splitList(viperConfig.GetStringSlice(constant.CobraFlagSkipTags))
+}
+
+// splitList splits comma separated entries, env variables come through as a single entry
+func splitList(entries []string) []string {
+	var list []string
+	for _, entry := range entries {
+		for _, item := range strings.Split(entry, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+func ShouldSplay() bool {
+	initIfNil()
+	return viperConfig.GetBool(constant.CobraFlagSplay)
+}
+
+func GetSplayLimit() time.Duration {
+	initIfNil()
+	return viperConfig.GetDuration(constant.CobraFlagSplayLimit)
+}
+
+func GetPuppetInterval() time.Duration {
+	initIfNil()
+	return viperConfig.GetDuration(constant.CobraFlagPuppetInterval)
+}
+
+func GetConnectivityInterval() time.Duration {
+	initIfNil()
+	return viperConfig.GetDuration(constant.CobraFlagConnectivityInterval)
+}
+
+func GetPingInterval() time.Duration {
+	initIfNil()
+	return viperConfig.GetDuration(constant.CobraFlagPingInterval)
+}
+
+func GetServiceWindowInterval() time.Duration {
+	initIfNil()
+	return viperConfig.GetDuration(constant.CobraFlagServiceWindowInterval)
+}
+
+func GetControlSocket() string {
+	initIfNil()
+	return viperConfig.GetString(constant.CobraFlagSocket)
+}
+
+func GetCertExpiryDays() int {
+	initIfNil()
+	return viperConfig.GetInt(constant.CobraFlagCertExpiryDays)
+}
+
+func GetLogFormat() string {
+	initIfNil()
+	return viperConfig.GetString(constant.CobraFlagLogFormat)
+}
+
+func GetLogOutput() string {
+	initIfNil()
+	return viperConfig.GetString(constant.CobraFlagLogOutput)
+}
+
+func GetLogFile() string {
+	initIfNil()
+	return viperConfig.GetString(constant.CobraFlagLogFile)
+}
+
+// ShouldTeeLogs tells if log records are streamed to webtee next to the command output
+func ShouldTeeLogs() bool {
+	initIfNil()
+	return viperConfig.GetBool(constant.CobraFlagLogWebtee)
+}
+
+// GetAgentVersion returns the openvox agent version to install, empty means the latest one
+func GetAgentVersion() string {
+	initIfNil()
+	return viperConfig.GetString(constant.CobraFlagAgentVersion)
+}
+
+// UseDirectDownload tells if the openvox agent is installed from a downloaded package instead of the repository
+func UseDirectDownload() bool {
+	initIfNil()
+	return viperConfig.GetBool(constant.CobraFlagDirectDownload)
+}
+
+// GetAPIURL returns the Obmondo API URL from the config, empty means the default
+func GetAPIURL() string {
+	initIfNil()
+	return viperConfig.GetString(constant.ConfigKeyAPIURL)
+}
+
+// GetExcludes returns the packages system-update must leave alone
+func GetExcludes() []string {
+	initIfNil()
+	return splitList(viperConfig.GetStringSlice(constant.ConfigKeyExcludes))
+}
+
+// GetPreUpdateHooks returns the commands to run before system-update upgrades packages
+func GetPreUpdateHooks() []string {
+	initIfNil()
+	return viperConfig.GetStringSlice(constant.ConfigKeyPreUpdateHooks)
+}
+
+// GetPostUpdateHooks returns the commands to run after system-update upgraded packages
+func GetPostUpdateHooks() []string {
+	initIfNil()
+	return viperConfig.GetStringSlice(constant.ConfigKeyPostUpdateHooks)
+}
+
+// GetDiskThresholds returns the free space per path, e.g. /var: 1GB or /tmp: 10%, on top of the built-in ones
+func GetDiskThresholds() map[string]string {
+	initIfNil()
+	return viperConfig.GetStringMapString(constant.ConfigKeyDiskThresholds)
+}
+
+// GetDiskMinFreeInodes returns the free inodes every filesystem checked needs, e.g. 5%
+func GetDiskMinFreeInodes() string {
+	initIfNil()
+	return viperConfig.GetString(constant.ConfigKeyDiskMinInodes)
+}
+
+// ShouldCleanup tells if system-update removes old kernels and cleans the package cache before upgrading
+func ShouldCleanup() bool {
+	initIfNil()
+	return viperConfig.GetBool(constant.ConfigKeyCleanup)
+}
+
+// GetKeepKernels returns how many of the newest kernels the cleanup keeps, the running one is always kept
+func GetKeepKernels() int {
+	initIfNil()
+	return viperConfig.GetInt(constant.ConfigKeyKeepKernels)
+}
+
 func GetViperInstance() *viper.Viper {
 	initIfNil()
 	return viperConfig
@@ -0,0 +1,199 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
+
+	"github.com/joho/godotenv"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// Where a value came from, the first one that is set wins
+const (
+	OriginFlag    = "flag"
+	OriginEnv     = "env"
+	OriginDefault = "default"
+)
+
+var (
+	boundFlags  = map[string]*pflag.Flag{}
+	boundEnvs   = map[string][]string{}
+	fileOrigins = map[string]string{}
+	loadedFiles []string
+	// envFileValues are the variables of the legacy puppet env file, kept out of the environment
+	envFileValues = map[string]string{}
+)
+
+// Load reads the legacy puppet env file, then /etc/linuxaid/config.yaml followed by the
+// drop-ins in conf.d in lexical order, a later file overrides the keys it sets.
+// Flags and env variables still take precedence over anything read from a file.
+func Load() error {
+	return load(constant.PuppetEnvFile, constant.ConfigFile, constant.ConfigDropInDir)
+}
+
+func load(envFile, configFile, dropInDir string) error {
+	initIfNil()
+
+	if err := loadEnvFile(envFile); err != nil {
+		return err
+	}
+
+	dropIns, err := filepath.Glob(filepath.Join(dropInDir, "*.yaml"))
+	if err != nil {
+		return err
+	}
+	slices.Sort(dropIns)
+
+	viperConfig.SetConfigType("yaml")
+	for _, path := range append([]string{configFile}, dropIns...) {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+
+		var values map[string]any
+		if err := yaml.Unmarshal(data, &values); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		if err := viperConfig.MergeConfig(bytes.NewReader(data)); err != nil {
+			return fmt.Errorf("failed to merge %s: %w", path, err)
+		}
+
+		for _, key := range flattenKeys("", values) {
+			fileOrigins[key] = path
+		}
+		loadedFiles = append(loadedFiles, path)
+	}
+
+	return nil
+}
+
+// loadEnvFile reads the legacy puppet env file without touching the environment. Its variables
+// for config keys become defaults, so env variables and config files win over them.
+func loadEnvFile(path string) error {
+	values, err := godotenv.Read(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %w", path, err)
+	}
+	envFileValues = values
+
+	for key, envs := range boundEnvs {
+		for _, env := range envs {
+			if value, ok := values[env]; ok {
+				viperConfig.SetDefault(key, value)
+				fileOrigins[key] = path
+				break
+			}
+		}
+	}
+	loadedFiles = append(loadedFiles, path)
+
+	return nil
+}
+
+// LookupEnv returns the env variable name, falling back to the legacy puppet env file
+func LookupEnv(name string) (string, bool) {
+	if value, ok := os.LookupEnv(name); ok {
+		return value, true
+	}
+	value, ok := envFileValues[name]
+	return value, ok
+}
+
+// Getenv is LookupEnv for callers that treat an unset variable like an empty one
+func Getenv(name string) string {
+	value, _ := LookupEnv(name)
+	return value
+}
+
+// flattenKeys returns the keys of nested maps the way viper addresses them, e.g. hooks.pre-update
+func flattenKeys(prefix string, values map[string]any) []string {
+	var keys []string
+	for key, value := range values {
+		key = strings.ToLower(prefix + key)
+		if nested, ok := value.(map[string]any); ok {
+			keys = append(keys, flattenKeys(key+".", nested)...)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// BindPFlag binds a flag to a config key, and remembers it so Origin can tell when the flag was set
+func BindPFlag(key string, flag *pflag.Flag) {
+	initIfNil()
+	// nolint: errcheck
+	viperConfig.BindPFlag(key, flag)
+	boundFlags[key] = flag
+}
+
+// BindEnv binds env variables to a config key, without names the upper cased key is used like viper does
+func BindEnv(key string, envs ...string) {
+	initIfNil()
+	// nolint: errcheck
+	viperConfig.BindEnv(append([]string{key}, envs...)...)
+	if len(envs) == 0 {
+		envs = []string{strings.ToUpper(key)}
+	}
+	boundEnvs[key] = envs
+}
+
+// Origin returns where the effective value of key came from: a flag, an env variable, a config file or the default
+func Origin(key string) string {
+	if flag, ok := boundFlags[key]; ok && flag.Changed {
+		return OriginFlag
+	}
+	for _, env := range boundEnvs[key] {
+		if _, ok := os.LookupEnv(env); ok {
+			return OriginEnv + " " + env
+		}
+	}
+	if path, ok := fileOrigins[key]; ok {
+		return path
+	}
+	return OriginDefault
+}
+
+// Keys returns every key that can be configured or was set in a config file, sorted
+func Keys() []string {
+	initIfNil()
+	keys := map[string]bool{}
+	for _, key := range viperConfig.AllKeys() {
+		keys[key] = true
+	}
+	for key := range boundFlags {
+		keys[key] = true
+	}
+	for key := range boundEnvs {
+		keys[key] = true
+	}
+	return slices.Sorted(maps.Keys(keys))
+}
+
+// Get returns the effective value of key
+func Get(key string) any {
+	initIfNil()
+	return viperConfig.Get(key)
+}
+
+// LoadedFiles returns the config files that were read, in the order they were merged
+func LoadedFiles() []string {
+	return loadedFiles
+}
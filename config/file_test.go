@@ -0,0 +1,124 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
+
+	"github.com/spf13/viper"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	viperConfig = viper.New()
+	viperConfig.AutomaticEnv()
+	fileOrigins = map[string]string{}
+	loadedFiles = nil
+	t.Cleanup(func() { viperConfig = nil })
+
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.yaml")
+	dropInDir := filepath.Join(dir, "conf.d")
+	if err := os.Mkdir(dropInDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		configFile: "certname: web01.example\napi-url: https://api.example\nexcludes: [nginx]\n",
+		filepath.Join(dropInDir, "10-excludes.yaml"): "excludes: [nginx, postgresql-16]\nhooks:\n  pre-update: [\"systemctl stop app\"]\n",
+		filepath.Join(dropInDir, "20-api.yaml"):      "api-url: https://api2.example\n",
+		filepath.Join(dropInDir, "ignored.conf"):     "certname: ignored.example\n",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	BindEnv(constant.CobraFlagCertname, "CERTNAME")
+	t.Setenv("CERTNAME", "env.example")
+
+	if err := load(filepath.Join(dir, "run_puppet"), configFile, dropInDir); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	if got := GetCertname(); got != "env.example" {
+		t.Errorf("certname = %q, want the env variable to win", got)
+	}
+	if got := Origin(constant.CobraFlagCertname); got != OriginEnv+" CERTNAME" {
+		t.Errorf("certname origin = %q", got)
+	}
+
+	if got := GetAPIURL(); got != "https://api2.example" {
+		t.Errorf("api-url = %q, want the last drop-in to win", got)
+	}
+	if got := Origin(constant.ConfigKeyAPIURL); got != filepath.Join(dropInDir, "20-api.yaml") {
+		t.Errorf("api-url origin = %q", got)
+	}
+
+	if got := GetExcludes(); len(got) != 2 || got[1] != "postgresql-16" {
+		t.Errorf("excludes = %v", got)
+	}
+	if got := GetPreUpdateHooks(); len(got) != 1 || got[0] != "systemctl stop app" {
+		t.Errorf("pre-update hooks = %v", got)
+	}
+
+	if got := Origin(constant.CobraFlagDebug); got != OriginDefault {
+		t.Errorf("debug origin = %q, want %q", got, OriginDefault)
+	}
+	if got := len(LoadedFiles()); got != 3 {
+		t.Errorf("loaded %d files, want 3", got)
+	}
+}
+
+func TestLoadEnvFileBelowConfigFiles(t *testing.T) {
+	viperConfig = viper.New()
+	viperConfig.AutomaticEnv()
+	fileOrigins = map[string]string{}
+	loadedFiles = nil
+	envFileValues = map[string]string{}
+	t.Cleanup(func() { viperConfig = nil })
+
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, "run_puppet")
+	configFile := filepath.Join(dir, "config.yaml")
+	files := map[string]string{
+		envFile:    "CERTNAME=envfile.example\nPUPPET_SERVER=envfile.puppet.example\nPUPPETCERT=/tmp/cert.pem\n",
+		configFile: "certname: yaml.example\n",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	BindEnv(constant.CobraFlagCertname, "CERTNAME")
+	BindEnv(constant.CobraFlagPuppetServer, "PUPPET_SERVER")
+
+	if err := load(envFile, configFile, filepath.Join(dir, "conf.d")); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	if got := GetCertname(); got != "yaml.example" {
+		t.Errorf("certname = %q, want the config file to win over the env file", got)
+	}
+	if got := Origin(constant.CobraFlagCertname); got != configFile {
+		t.Errorf("certname origin = %q", got)
+	}
+	if got := GetPupeptServer(); got != "envfile.puppet.example" {
+		t.Errorf("puppet-server = %q, want the env file value", got)
+	}
+	if got := Origin(constant.CobraFlagPuppetServer); got != envFile {
+		t.Errorf("puppet-server origin = %q", got)
+	}
+
+	for _, env := range []string{"CERTNAME", "PUPPET_SERVER", "PUPPETCERT"} {
+		if _, ok := os.LookupEnv(env); ok {
+			t.Errorf("%s leaked into the environment", env)
+		}
+	}
+	if got := Getenv("PUPPETCERT"); got != "/tmp/cert.pem" {
+		t.Errorf("PUPPETCERT = %q, want the env file value", got)
+	}
+}
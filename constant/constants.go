@@ -1,10 +1,13 @@
 package constant
 
+import "time"
+
 const (
 	// Obmondo API
 	// Puppet
 	SleepTime          = 5
 	PuppetPackageName  = "puppet-agent"
+	OpenvoxPackageName = "openvox-agent"
 	PuppetPath         = "/sbin:/usr/sbin:/bin:/usr/bin:/opt/puppetlabs/puppet/bin"
 	PuppetConfig       = "/etc/puppetlabs/puppet/puppet.conf"
 	PuppetVersion      = "8.23.1"
@@ -13,11 +16,48 @@ const (
 	PuppetPrivKeyEnv   = "PUPPETPRIVKEY"
 	InstallTokenEnv    = "TOKEN"
 	ExternalFacterFile = "/etc/puppetlabs/facter/facts.d/new_installation.yaml"
-	PuppetPrivKeyPath  = "/etc/puppetlabs/puppet/ssl/private_keys"
+	InstallStateFile   = "/var/lib/linuxaid/install-state.json"
+
+	// os-release(5), the second one is read when there's no first one
+	OSReleaseFile         = "/etc/os-release"
+	OSReleaseFallbackFile = "/usr/lib/os-release"
+
+	// MountsFile is the mount table of the linuxaid process
+	MountsFile = "/proc/self/mounts"
+	// PackageCacheDir is where apt, dnf and zypper download packages to
+	PackageCacheDir = "/var/cache"
+
+	OpenvoxRepoURL    = "https://repos.obmondo.com/openvox"
+	OpenvoxRepoKeyURL = OpenvoxRepoURL + "/GPG-KEY-openvox"
+
+	// Signed checksum manifest published next to every directly downloaded package
+	OpenvoxChecksumManifest  = "SHA256SUMS"
+	OpenvoxChecksumSignature = OpenvoxChecksumManifest + ".asc"
+
+	// Openvox repository definitions and signing keys
+	AptOpenvoxKeyFile     = "/etc/apt/keyrings/obmondo-openvox.asc"
+	AptOpenvoxSourceFile  = "/etc/apt/sources.list.d/obmondo-openvox.list"
+	RPMOpenvoxKeyFile     = "/etc/pki/rpm-gpg/RPM-GPG-KEY-obmondo-openvox"
+	YumOpenvoxRepoFile    = "/etc/yum.repos.d/obmondo-openvox.repo"
+	ZypperOpenvoxRepoFile = "/etc/zypp/repos.d/obmondo-openvox.repo"
+	PuppetSSLDir          = "/etc/puppetlabs/puppet/ssl"
+	PuppetPrivKeyPath     = "/etc/puppetlabs/puppet/ssl/private_keys"
+	PuppetCertsPath       = "/etc/puppetlabs/puppet/ssl/certs"
+	PuppetCACertFile      = PuppetCertsPath + "/ca.pem"
+
+	// Config
+	ConfigFile      = "/etc/linuxaid/config.yaml"
+	ConfigDropInDir = "/etc/linuxaid/conf.d"
+	PuppetEnvFile   = "/etc/default/run_puppet"
 
 	// Lock and Disabled
 	AgentDisabledLockFile           = "/opt/puppetlabs/puppet/cache/state/agent_disabled.lock"
 	AgentRunningLockFile            = "/opt/puppetlabs/puppet/cache/state/agent_catalog_run.lock"
+	PuppetLastRunReportFile         = "/opt/puppetlabs/puppet/cache/state/last_run_report.yaml"
+	PuppetLastRunSummaryFile        = "/opt/puppetlabs/puppet/public/last_run_summary.yaml"
+	SystemUpdateDisableMessage      = "puppet has been disabled by the system-update"
+	NodeExporterTextfileDir         = "/var/lib/node_exporter"
+	SystemdUnitDir                  = "/etc/systemd/system"
 	DefaultPuppetServerCustomerID   = "enableit"
 	DefaultPuppetServerDomainSuffix = ".puppet.obmondo.com"
 
@@ -38,12 +78,69 @@ const (
 	CobraFlagPuppetServer = "puppet-server"
 	CobraFlagNoReboot     = "no-reboot"
 	CobraFlagSkipOpenvox  = "skip-openvox"
+	CobraFlagMode         = "mode"
+	CobraFlagTags         = "tags"
+	CobraFlagSkipTags     = "skip-tags"
+	CobraFlagEnvironment  = "environment"
+	CobraFlagSplay        = "splay"
+	CobraFlagSplayLimit   = "splay-limit"
+
+	CobraFlagPuppetInterval        = "puppet-interval"
+	CobraFlagConnectivityInterval  = "connectivity-interval"
+	CobraFlagPingInterval          = "ping-interval"
+	CobraFlagServiceWindowInterval = "service-window-interval"
+	CobraFlagSocket                = "socket"
+	CobraFlagJSON                  = "json"
+	CobraFlagUpload                = "upload"
+	CobraFlagCertExpiryDays        = "cert-expiry-days"
+	CobraFlagExplain               = "explain"
+	CobraFlagLogFormat             = "log-format"
+	CobraFlagLogOutput             = "log-output"
+	CobraFlagLogFile               = "log-file"
+	CobraFlagLogWebtee             = "log-webtee"
+	CobraFlagYes                   = "yes"
+	CobraFlagNonInteractive        = "non-interactive"
+	CobraFlagPlain                 = "plain"
+	CobraFlagFromStep              = "from-step"
+	CobraFlagKeepCerts             = "keep-certs"
+	CobraFlagDryRun                = "dry-run"
+	CobraFlagForce                 = "force"
+	CobraFlagAgentVersion          = "agent-version"
+	CobraFlagDirectDownload        = "direct-download"
 
 	ObmondoEnv = "OBMONDO_ENV"
+
+	// Config file keys without a flag
+	ConfigKeyAPIURL          = "api-url"
+	ConfigKeyExcludes        = "excludes"
+	ConfigKeyPreUpdateHooks  = "hooks.pre-update"
+	ConfigKeyPostUpdateHooks = "hooks.post-update"
+	ConfigKeyDiskThresholds  = "disk.thresholds"
+	ConfigKeyDiskMinInodes   = "disk.min-free-inodes"
+	ConfigKeyCleanup         = "cleanup.enabled"
+	ConfigKeyKeepKernels     = "cleanup.keep-kernels"
 )
 
 const (
 	PuppetWaitForCertTimeOut = 600
+	DefaultSplayLimit        = 15 * time.Minute
+	RunOpenvoxLockFile       = "/run/linuxaid-cli-run-openvox.lock"
+	DefaultCertExpiryDays    = 30
+
+	// Daemon
+	ControlSocketPath            = "/run/linuxaid-cli.sock"
+	DefaultPuppetInterval        = 30 * time.Minute
+	DefaultConnectivityInterval  = 5 * time.Minute
+	DefaultPingInterval          = 5 * time.Minute
+	DefaultServiceWindowInterval = 5 * time.Minute
+	DaemonShutdownGrace          = 60 * time.Second
+
+	// Logging
+	DefaultCLILogFile     = "/var/log/linuxaid/linuxaid-cli.log"
+	DefaultInstallLogFile = "/var/log/linuxaid/linuxaid-install.log"
+	LogFileMaxSize        = 10 << 20
+	LogFileBackups        = 5
+	JournaldSocket        = "/run/systemd/journal/socket"
 )
 
 var (
@@ -3,81 +3,53 @@ package helper
 import (
 	"crypto/x509"
 	"encoding/pem"
+	"fmt"
 	"log/slog"
 	"os"
-	"strings"
 
 	"gitea.obmondo.com/EnableIT/linuxaid-cli/config"
 	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
 	"github.com/bitfield/script"
 )
 
-func GetCommonNameFromCertFile(certPath string) string {
+// ParseCertFile parses the first PEM certificate in certPath
+func ParseCertFile(certPath string) (*x509.Certificate, error) {
 	hostCert, err := os.ReadFile(certPath)
 	if err != nil {
-		slog.Error("failed to fetch hostcert", slog.String("error", err.Error()))
-		return ""
+		return nil, err
 	}
 
 	block, _ := pem.Decode(hostCert)
 	if block == nil {
-		slog.Error("failed to decode hostcert")
-		return ""
+		return nil, fmt.Errorf("failed to decode %s", certPath)
 	}
 
-	cert, err := x509.ParseCertificate(block.Bytes)
-	if err != nil {
-		slog.Error("failed to parse hostcert", slog.String("error", err.Error()))
-		return ""
-	}
-
-	return cert.Subject.CommonName
+	return x509.ParseCertificate(block.Bytes)
 }
 
-func getCertnameFromPrivateKey() string {
-	items, err := os.ReadDir(constant.PuppetPrivKeyPath)
+func GetCommonNameFromCertFile(certPath string) string {
+	cert, err := ParseCertFile(certPath)
 	if err != nil {
-		slog.Debug("failed to list directory", slog.Any("error", err), slog.String("path", constant.PuppetPrivKeyPath))
+		slog.Error("failed to parse hostcert", slog.String("error", err.Error()))
 		return ""
 	}
 
-	for _, item := range items {
-		if item.IsDir() {
-			continue
-		}
-		certname, ok := strings.CutSuffix(item.Name(), ".pem")
-		if !ok {
-			continue
-		}
-		return certname
-	}
-
-	slog.Debug("no file found in the directory", slog.Any("error", err), slog.String("path", constant.PuppetPrivKeyPath))
-	return ""
+	return cert.Subject.CommonName
 }
 
-func GetCertname() string {
-	puppetCert, puppetCertExists := os.LookupEnv(constant.PuppetCertEnv)
-	if puppetCertExists {
-		return GetCommonNameFromCertFile(puppetCert)
+// PuppetCertPaths returns the host certificate and private key, the puppet env file takes precedence
+func PuppetCertPaths(certname string) (string, string) {
+	certPath, ok := config.LookupEnv(constant.PuppetCertEnv)
+	if !ok {
+		certPath = fmt.Sprintf("%s/%s.pem", constant.PuppetCertsPath, certname)
 	}
 
-	certname := getCertnameFromPrivateKey()
-	if certname != "" {
-		return certname
-	}
-
-	return config.GetCertname()
-}
-
-func GetCustomerID(certname string) string {
-	parts := strings.Split(certname, ".")
-	// nolint: mnd
-	if len(parts) >= 2 {
-		return parts[1]
+	keyPath, ok := config.LookupEnv(constant.PuppetPrivKeyEnv)
+	if !ok {
+		keyPath = fmt.Sprintf("%s/%s.pem", constant.PuppetPrivKeyPath, certname)
 	}
 
-	return ""
+	return certPath, keyPath
 }
 
 // Need this, otherwise remotelog func wont work
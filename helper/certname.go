@@ -0,0 +1,266 @@
+package helper
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/config"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
+)
+
+// Where a certname can come from, in order of precedence
+const (
+	CertnameSourceCertEnv    = "certificate from " + constant.PuppetCertEnv
+	CertnameSourcePrivateKey = "private key in " + constant.PuppetPrivKeyPath
+	CertnameSourceConfig     = "--certname flag or CERTNAME env"
+	CertnameSourcePuppetConf = "certname in " + constant.PuppetConfig
+)
+
+var (
+	ErrNoCertname           = errors.New("no certname found")
+	ErrAmbiguousPrivateKeys = errors.New("more than one private key found")
+	ErrInvalidCertname      = errors.New("invalid certname")
+	ErrInvalidCustomerID    = errors.New("invalid customer ID")
+	certnameLabelRegexp     = regexp.MustCompile(`^[a-z0-9]([a-z0-9_-]*[a-z0-9])?$`)
+	customerIDRegexp        = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+	puppetConfSectionRegexp = regexp.MustCompile(`^\[([a-z]+)\]$`)
+	puppetConfSettingRegexp = regexp.MustCompile(`^([a-z_]+)\s*=\s*(\S+)$`)
+)
+
+// CertnameCandidate is what a single source says the certname is
+type CertnameCandidate struct {
+	Source   string `json:"source"`
+	Certname string `json:"certname,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// CertnameResolution is the certname we settled on, with every source we looked at
+type CertnameResolution struct {
+	Certname   string              `json:"certname"`
+	Source     string              `json:"source"`
+	Candidates []CertnameCandidate `json:"candidates"`
+}
+
+// Conflicts returns the candidates that disagree with the resolved certname
+func (r *CertnameResolution) Conflicts() []CertnameCandidate {
+	var conflicts []CertnameCandidate
+	for _, candidate := range r.Candidates {
+		if candidate.Certname != "" && candidate.Certname != r.Certname {
+			conflicts = append(conflicts, candidate)
+		}
+	}
+	return conflicts
+}
+
+// certnameSources holds the locations we read, so tests can point them elsewhere
+type certnameSources struct {
+	certEnvPath   string
+	privateKeyDir string
+	configured    string
+	puppetConf    string
+}
+
+// ResolveCertname looks at every certname source and returns the one with the highest precedence.
+// It fails when several private keys exist and no other source says which one is ours,
+// or when the resulting certname or its customer ID is invalid.
+func ResolveCertname() (*CertnameResolution, error) {
+	return certnameSources{
+		certEnvPath:   config.Getenv(constant.PuppetCertEnv),
+		privateKeyDir: constant.PuppetPrivKeyPath,
+		configured:    config.GetCertname(),
+		puppetConf:    constant.PuppetConfig,
+	}.resolve()
+}
+
+func (s certnameSources) resolve() (*CertnameResolution, error) {
+	resolution := &CertnameResolution{}
+
+	var certEnv CertnameCandidate
+	if s.certEnvPath != "" {
+		certEnv = CertnameCandidate{Source: CertnameSourceCertEnv}
+		if cert, err := ParseCertFile(s.certEnvPath); err != nil {
+			certEnv.Error = err.Error()
+		} else {
+			certEnv.Certname = cert.Subject.CommonName
+		}
+		resolution.Candidates = append(resolution.Candidates, certEnv)
+	}
+
+	configured := CertnameCandidate{Source: CertnameSourceConfig, Certname: s.configured}
+	puppetConf := CertnameCandidate{Source: CertnameSourcePuppetConf}
+	if certname, err := readPuppetConfCertname(s.puppetConf); err != nil {
+		puppetConf.Error = err.Error()
+	} else {
+		puppetConf.Certname = certname
+	}
+
+	keys, err := listPrivateKeys(s.privateKeyDir)
+	privateKey := CertnameCandidate{Source: CertnameSourcePrivateKey}
+	switch {
+	case err != nil:
+		privateKey.Error = err.Error()
+	case len(keys) == 1:
+		privateKey.Certname = keys[0]
+	case len(keys) > 1:
+		// Another source can tell us which of the keys is ours
+		for _, candidate := range []CertnameCandidate{certEnv, configured, puppetConf} {
+			if slices.Contains(keys, candidate.Certname) {
+				privateKey.Certname = candidate.Certname
+				break
+			}
+		}
+		if privateKey.Certname == "" {
+			privateKey.Error = fmt.Sprintf("%s: %s", ErrAmbiguousPrivateKeys, strings.Join(keys, ", "))
+		}
+	}
+
+	resolution.Candidates = append(resolution.Candidates, privateKey, configured, puppetConf)
+
+	if privateKey.Error != "" && len(keys) > 1 {
+		return resolution, fmt.Errorf("%w in %s (%s), pass --certname to pick one", ErrAmbiguousPrivateKeys, s.privateKeyDir, strings.Join(keys, ", "))
+	}
+
+	for _, candidate := range resolution.Candidates {
+		if candidate.Certname != "" {
+			resolution.Certname = candidate.Certname
+			resolution.Source = candidate.Source
+			break
+		}
+	}
+
+	if resolution.Certname == "" {
+		return resolution, ErrNoCertname
+	}
+
+	if err := ValidateCertname(resolution.Certname); err != nil {
+		return resolution, err
+	}
+
+	return resolution, nil
+}
+
+// listPrivateKeys returns the certnames of the private keys, sorted
+func listPrivateKeys(dir string) ([]string, error) {
+	items, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var certnames []string
+	for _, item := range items {
+		if item.IsDir() {
+			continue
+		}
+		if certname, ok := strings.CutSuffix(item.Name(), ".pem"); ok {
+			certnames = append(certnames, certname)
+		}
+	}
+
+	slices.Sort(certnames)
+	return certnames, nil
+}
+
+// readPuppetConfCertname returns the certname set in puppet.conf, [agent] wins over [main] like in puppet
+func readPuppetConfCertname(path string) (string, error) {
+	return readPuppetConfSetting(path, "certname")
+}
+
+// readPuppetConfSetting returns a setting of puppet.conf, [agent] wins over [main] like in puppet
+func readPuppetConfSetting(path, key string) (string, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	section := "main"
+	values := map[string]string{}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if match := puppetConfSectionRegexp.FindStringSubmatch(line); match != nil {
+			section = match[1]
+			continue
+		}
+		if match := puppetConfSettingRegexp.FindStringSubmatch(line); match != nil && match[1] == key {
+			values[section] = match[2]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	if value, ok := values["agent"]; ok {
+		return value, nil
+	}
+	return values["main"], nil
+}
+
+// ValidateCertname checks the certname is a lowercase dotted name with a valid customer ID as its second label
+func ValidateCertname(certname string) error {
+	labels := strings.Split(certname, ".")
+	// nolint: mnd
+	if len(labels) < 2 {
+		return fmt.Errorf("%w %q, expected <hostname>.<customer id>", ErrInvalidCertname, certname)
+	}
+
+	for _, label := range labels {
+		if !certnameLabelRegexp.MatchString(label) {
+			return fmt.Errorf("%w %q, only lowercase letters, digits, '-' and '_' are allowed", ErrInvalidCertname, certname)
+		}
+	}
+
+	if customerID := GetCustomerID(certname); !customerIDRegexp.MatchString(customerID) {
+		return fmt.Errorf("%w %q in certname %q", ErrInvalidCustomerID, customerID, certname)
+	}
+
+	return nil
+}
+
+// resolvedCertname is the certname the command settled on, the sources are only looked at once
+var resolvedCertname string
+
+// SetResolvedCertname keeps the certname resolved when the command started, GetCertname returns it from then on
+func SetResolvedCertname(resolution *CertnameResolution) {
+	resolvedCertname = resolution.Certname
+}
+
+// GetCertname returns the certname resolved when the command started. Commands that run without
+// one, like doctor, get it resolved on first use, an empty string when that fails.
+func GetCertname() string {
+	if resolvedCertname != "" {
+		return resolvedCertname
+	}
+
+	resolution, err := ResolveCertname()
+	if err != nil {
+		slog.Error("failed to resolve the certname, see `linuxaid-cli certname --explain`", slog.Any("error", err))
+		return ""
+	}
+	resolvedCertname = resolution.Certname
+	return resolvedCertname
+}
+
+func GetCustomerID(certname string) string {
+	parts := strings.Split(certname, ".")
+	// nolint: mnd
+	if len(parts) >= 2 {
+		return parts[1]
+	}
+
+	return ""
+}
@@ -0,0 +1,111 @@
+package helper
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestResolveCertnamePrecedence(t *testing.T) {
+	keyDir := t.TempDir()
+	writeFiles(t, keyDir, "web01.example.pem")
+
+	puppetConf := filepath.Join(t.TempDir(), "puppet.conf")
+	conf := "[main]\ncertname = db01.example\n\n[agent]\ncertname = web02.example\n"
+	if err := os.WriteFile(puppetConf, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	resolution, err := certnameSources{
+		privateKeyDir: keyDir,
+		configured:    "web01.example",
+		puppetConf:    puppetConf,
+	}.resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resolution.Certname != "web01.example" || resolution.Source != CertnameSourcePrivateKey {
+		t.Errorf("expected web01.example from the private key, got %s from %s", resolution.Certname, resolution.Source)
+	}
+
+	// [agent] wins over [main] in puppet.conf, and disagrees with the key
+	conflicts := resolution.Conflicts()
+	if len(conflicts) != 1 || conflicts[0].Certname != "web02.example" {
+		t.Errorf("expected a conflict with web02.example from puppet.conf, got %+v", conflicts)
+	}
+}
+
+func TestResolveCertnameAmbiguousKeys(t *testing.T) {
+	keyDir := t.TempDir()
+	writeFiles(t, keyDir, "web01.example.pem", "old.example.pem")
+
+	_, err := certnameSources{privateKeyDir: keyDir}.resolve()
+	if !errors.Is(err, ErrAmbiguousPrivateKeys) {
+		t.Fatalf("expected ambiguous private keys, got %v", err)
+	}
+
+	// The flag tells us which of the keys is ours
+	resolution, err := certnameSources{privateKeyDir: keyDir, configured: "web01.example"}.resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolution.Certname != "web01.example" || resolution.Source != CertnameSourcePrivateKey {
+		t.Errorf("expected web01.example from the private key, got %s from %s", resolution.Certname, resolution.Source)
+	}
+}
+
+func TestResolveCertnameNotFound(t *testing.T) {
+	_, err := certnameSources{privateKeyDir: filepath.Join(t.TempDir(), "missing")}.resolve()
+	if !errors.Is(err, ErrNoCertname) {
+		t.Fatalf("expected no certname, got %v", err)
+	}
+}
+
+func TestValidateCertname(t *testing.T) {
+	valid := []string{"web01.example", "db-1.enableit.dk", "node_2.customer-a"}
+	for _, certname := range valid {
+		if err := ValidateCertname(certname); err != nil {
+			t.Errorf("expected %q to be valid: %v", certname, err)
+		}
+	}
+
+	invalid := []string{"web01", "Web01.example", "web01..example", "web01.-example", "web01.exa_mple", "web 01.example"}
+	for _, certname := range invalid {
+		if err := ValidateCertname(certname); err == nil {
+			t.Errorf("expected %q to be rejected", certname)
+		}
+	}
+}
+
+func TestResolvePuppetServer(t *testing.T) {
+	puppetConf := filepath.Join(t.TempDir(), "puppet.conf")
+	missing := filepath.Join(t.TempDir(), "puppet.conf")
+	if err := os.WriteFile(puppetConf, []byte("[main]\ncertname = web01.acme\n\n[agent]\nserver = puppet.acme.example\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, explicit, puppetConf, certname, want string
+	}{
+		{"flag", "puppet.example", puppetConf, "web01.acme", "puppet.example"},
+		{"puppet.conf", "", puppetConf, "web01.acme", "puppet.acme.example"},
+		{"customer", "", missing, "web01.acme", "acme.puppet.obmondo.com"},
+		{"no certname", "", missing, "", "enableit.puppet.obmondo.com"},
+	}
+	for _, tt := range tests {
+		if got := resolvePuppetServer(tt.explicit, tt.puppetConf, tt.certname); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
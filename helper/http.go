@@ -9,6 +9,7 @@ import (
 	"os"
 	"time"
 
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/config"
 	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
 
 	"github.com/bitfield/script"
@@ -30,15 +31,17 @@ func ParseResponse(response *http.Response) (int, []byte, error) {
 
 // FetchURL calls an Obmondo API URL
 func FetchURL(url string) (*http.Response, error) {
-	puppetCert := script.IfExists(os.Getenv(constant.PuppetCertEnv))
-	puppetPrivKey := script.IfExists(os.Getenv(constant.PuppetPrivKeyEnv))
+	certPath := config.Getenv(constant.PuppetCertEnv)
+	keyPath := config.Getenv(constant.PuppetPrivKeyEnv)
+	puppetCert := script.IfExists(certPath)
+	puppetPrivKey := script.IfExists(keyPath)
 
 	if puppetCert.ExitStatus() != 0 || puppetPrivKey.ExitStatus() != 0 {
 		slog.Error("puppet host cert or puppet private key is not present on the node")
 		os.Exit(1)
 	}
 
-	cert, err := tls.LoadX509KeyPair(os.Getenv(constant.PuppetCertEnv), os.Getenv(constant.PuppetPrivKeyEnv))
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
 	if err != nil {
 		slog.Error(err.Error())
 		os.Exit(1)
@@ -0,0 +1,45 @@
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// ErrLocked is returned by TryLock when another process holds the lock
+var ErrLocked = errors.New("lock is held by another process")
+
+// FileLock is an exclusive flock, the kernel releases it when the process exits
+type FileLock struct {
+	file *os.File
+}
+
+// TryLock takes an exclusive lock on path without waiting, and records our pid in it
+func TryLock(path string) (*FileLock, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file %s: %w", path, err)
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, ErrLocked
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+
+	if err := file.Truncate(0); err == nil {
+		file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0) //nolint:errcheck
+	}
+
+	return &FileLock{file: file}, nil
+}
+
+// Unlock releases the lock, the file stays so the next run doesn't race on creating it
+func (l *FileLock) Unlock() error {
+	defer l.file.Close()
+	return syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,46 @@
+package helper
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestTryLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run-openvox.lock")
+
+	lock, err := TryLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(data)); got != strconv.Itoa(os.Getpid()) {
+		t.Errorf("lock file holds %q, want our pid %d", got, os.Getpid())
+	}
+
+	// flock locks belong to the open file, so a second open contends like another process would
+	if _, err := TryLock(path); !errors.Is(err, ErrLocked) {
+		t.Errorf("expected ErrLocked while the lock is held, got: %v", err)
+	}
+
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("lock file should stay after unlock: %v", err)
+	}
+
+	lock, err = TryLock(path)
+	if err != nil {
+		t.Fatalf("expected the lock to be free after unlock, got: %v", err)
+	}
+	// nolint: errcheck
+	lock.Unlock()
+}
@@ -0,0 +1,150 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// teeHandler hands every record to all of its handlers
+type teeHandler struct {
+	handlers []slog.Handler
+}
+
+func (h *teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range h.handlers {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *teeHandler) Handle(ctx context.Context, record slog.Record) error {
+	var errs []error
+	for _, handler := range h.handlers {
+		if handler.Enabled(ctx, record.Level) {
+			errs = append(errs, handler.Handle(ctx, record.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (h *teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make([]slog.Handler, 0, len(h.handlers))
+	for _, handler := range h.handlers {
+		handlers = append(handlers, handler.WithAttrs(attrs))
+	}
+	return &teeHandler{handlers: handlers}
+}
+
+func (h *teeHandler) WithGroup(name string) slog.Handler {
+	handlers := make([]slog.Handler, 0, len(h.handlers))
+	for _, handler := range h.handlers {
+		handlers = append(handlers, handler.WithGroup(name))
+	}
+	return &teeHandler{handlers: handlers}
+}
+
+// textHandler writes records for people reading a terminal: time, level and message up front, attributes after
+type textHandler struct {
+	mu     *sync.Mutex
+	writer io.Writer
+	opts   slog.HandlerOptions
+	attrs  []byte
+	group  string
+}
+
+func newTextHandler(writer io.Writer, opts *slog.HandlerOptions) *textHandler {
+	return &textHandler{mu: &sync.Mutex{}, writer: writer, opts: *opts}
+}
+
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	minLevel := slog.LevelInfo
+	if h.opts.Level != nil {
+		minLevel = h.opts.Level.Level()
+	}
+	return level >= minLevel
+}
+
+func (h *textHandler) Handle(_ context.Context, record slog.Record) error {
+	var buf bytes.Buffer
+	if !record.Time.IsZero() {
+		buf.WriteString(record.Time.Format(time.DateTime))
+		buf.WriteByte(' ')
+	}
+	fmt.Fprintf(&buf, "%-5s %s", record.Level.String(), record.Message)
+
+	if h.opts.AddSource && record.PC != 0 {
+		source := recordSource(record)
+		appendAttr(&buf, "", slog.String(slog.SourceKey, source.File+":"+strconv.Itoa(source.Line)))
+	}
+
+	buf.Write(h.attrs)
+	record.Attrs(func(attr slog.Attr) bool {
+		appendAttr(&buf, h.group, attr)
+		return true
+	})
+	buf.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.writer.Write(buf.Bytes())
+	return err
+}
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	buf := bytes.NewBuffer(bytes.Clone(h.attrs))
+	for _, attr := range attrs {
+		appendAttr(buf, h.group, attr)
+	}
+	clone.attrs = buf.Bytes()
+	return &clone
+}
+
+func (h *textHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	clone := *h
+	clone.group = h.group + name + "."
+	return &clone
+}
+
+// recordSource returns where the record was logged
+func recordSource(record slog.Record) runtime.Frame {
+	frame, _ := runtime.CallersFrames([]uintptr{record.PC}).Next()
+	return frame
+}
+
+func appendAttr(buf *bytes.Buffer, prefix string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+
+	if attr.Value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "."
+		}
+		for _, nested := range attr.Value.Group() {
+			appendAttr(buf, prefix, nested)
+		}
+		return
+	}
+
+	value := attr.Value.String()
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		value = strconv.Quote(value)
+	}
+	fmt.Fprintf(buf, " %s%s=%s", prefix, attr.Key, value)
+}
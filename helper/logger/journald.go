@@ -0,0 +1,156 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// journaldHandler sends records to journald with the native protocol, every attribute
+// becomes a journal field so `journalctl RUN_ID=...` finds all records of a run
+type journaldHandler struct {
+	mu         *sync.Mutex
+	conn       *net.UnixConn
+	identifier string
+	opts       slog.HandlerOptions
+	fields     []byte
+	group      string
+}
+
+func newJournaldHandler(socket, identifier string, opts *slog.HandlerOptions) (*journaldHandler, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+
+	return &journaldHandler{mu: &sync.Mutex{}, conn: conn, identifier: identifier, opts: *opts}, nil
+}
+
+func (h *journaldHandler) Close() error {
+	return h.conn.Close()
+}
+
+func (h *journaldHandler) Enabled(_ context.Context, level slog.Level) bool {
+	minLevel := slog.LevelInfo
+	if h.opts.Level != nil {
+		minLevel = h.opts.Level.Level()
+	}
+	return level >= minLevel
+}
+
+func (h *journaldHandler) Handle(_ context.Context, record slog.Record) error {
+	var buf bytes.Buffer
+	appendJournalField(&buf, "MESSAGE", record.Message)
+	appendJournalField(&buf, "PRIORITY", journalPriority(record.Level))
+	appendJournalField(&buf, "SYSLOG_IDENTIFIER", h.identifier)
+
+	if h.opts.AddSource && record.PC != 0 {
+		source := recordSource(record)
+		appendJournalField(&buf, "CODE_FILE", source.File)
+		appendJournalField(&buf, "CODE_LINE", strconv.Itoa(source.Line))
+		appendJournalField(&buf, "CODE_FUNC", source.Function)
+	}
+
+	buf.Write(h.fields)
+	record.Attrs(func(attr slog.Attr) bool {
+		appendJournalAttr(&buf, h.group, attr)
+		return true
+	})
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.conn.Write(buf.Bytes())
+	return err
+}
+
+func (h *journaldHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	buf := bytes.NewBuffer(bytes.Clone(h.fields))
+	for _, attr := range attrs {
+		appendJournalAttr(buf, h.group, attr)
+	}
+	clone.fields = buf.Bytes()
+	return &clone
+}
+
+func (h *journaldHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	clone := *h
+	clone.group = h.group + name + "_"
+	return &clone
+}
+
+func appendJournalAttr(buf *bytes.Buffer, prefix string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+
+	if attr.Value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "_"
+		}
+		for _, nested := range attr.Value.Group() {
+			appendJournalAttr(buf, prefix, nested)
+		}
+		return
+	}
+
+	appendJournalField(buf, journalFieldName(prefix+attr.Key), attr.Value.String())
+}
+
+// appendJournalField encodes a field, values with a newline need the length prefixed form
+func appendJournalField(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+		return
+	}
+
+	buf.WriteByte('\n')
+	// nolint: errcheck
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+// journalFieldName turns an attribute key into a valid journal field name: upper case
+// letters, digits and underscores, not starting with an underscore or a digit
+func journalFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, key)
+
+	name = strings.TrimLeft(name, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "X_" + name
+	}
+	return name
+}
+
+func journalPriority(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return "3"
+	case level >= slog.LevelWarn:
+		return "4"
+	case level >= slog.LevelInfo:
+		return "6"
+	}
+	return "7"
+}
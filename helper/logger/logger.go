@@ -1,32 +1,168 @@
 package logger
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"os"
+	"path/filepath"
+
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/config"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
+)
+
+// Log formats
+const (
+	FormatText   = "text"
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+)
+
+// Log outputs
+const (
+	OutputStderr   = "stderr"
+	OutputFile     = "file"
+	OutputJournald = "journald"
+)
+
+// Options decide how and where records are written
+type Options struct {
+	Format string
+	Output string
+	// File is the log file for OutputFile, it's rotated once it grows past constant.LogFileMaxSize
+	File  string
+	Debug bool
+	// Writer replaces stderr for OutputStderr, e.g. to not disturb a progress bar
+	Writer io.Writer
+	// Tee gets every record as well, formatted as logfmt
+	Tee io.Writer
+}
+
+var (
+	runID  = newRunID()
+	output io.Closer
 )
 
-func InitLogger(writer io.Writer, debug bool) {
+// RunID returns the ID attached to every record logged by this process
+func RunID() string {
+	return runID
+}
+
+// ConfigOptions returns the logging options from the flags, env variables and config files
+func ConfigOptions() Options {
+	return Options{
+		Format: config.GetLogFormat(),
+		Output: config.GetLogOutput(),
+		File:   config.GetLogFile(),
+		Debug:  config.IsDebug(),
+	}
+}
+
+// Init sets the default logger, the output of an earlier Init is closed.
+// On error the default logger is left as it was.
+func Init(opts Options) error {
 	handlerOptions := &slog.HandlerOptions{
-		AddSource: debug,
+		AddSource: opts.Debug,
 	}
 
-	if debug {
+	if opts.Debug {
 		handlerOptions.Level = slog.LevelDebug
 	}
 
-	logger := slog.New(customLogHandler(writer, debug, handlerOptions))
-	slog.SetDefault(logger)
+	handler, closer, err := outputHandler(opts, handlerOptions)
+	if err != nil {
+		return err
+	}
+
+	if opts.Tee != nil {
+		handler = &teeHandler{handlers: []slog.Handler{handler, slog.NewTextHandler(opts.Tee, handlerOptions)}}
+	}
+
+	slog.SetDefault(slog.New(handler).With(slog.String("run_id", runID)))
+
+	if output != nil {
+		// nolint: errcheck
+		output.Close()
+	}
+	output = closer
+
+	return nil
+}
+
+// OpenFile opens a log file rotated like the one of OutputFile, for output that isn't log records,
+// e.g. of the commands a run starts
+func OpenFile(path string) (io.WriteCloser, error) {
+	return openRotatingFile(path, constant.LogFileMaxSize, constant.LogFileBackups)
+}
+
+// Close closes the log file or journald socket, records logged afterwards are lost
+func Close() {
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+	if output != nil {
+		// nolint: errcheck
+		output.Close()
+		output = nil
+	}
 }
 
-func customLogHandler(writer io.Writer, debug bool, handlerOptions *slog.HandlerOptions) slog.Handler {
-	if writer == nil {
-		writer = os.Stderr
+func outputHandler(opts Options, handlerOptions *slog.HandlerOptions) (slog.Handler, io.Closer, error) {
+	switch opts.Output {
+	case OutputStderr, "":
+		writer := opts.Writer
+		if writer == nil {
+			writer = os.Stderr
+		}
+		handler, err := formatHandler(opts.Format, writer, handlerOptions)
+		return handler, nil, err
+
+	case OutputFile:
+		if opts.File == "" {
+			return nil, nil, errors.New("no log file given")
+		}
+		file, err := openRotatingFile(opts.File, constant.LogFileMaxSize, constant.LogFileBackups)
+		if err != nil {
+			return nil, nil, err
+		}
+		handler, err := formatHandler(opts.Format, file, handlerOptions)
+		if err != nil {
+			// nolint: errcheck
+			file.Close()
+			return nil, nil, err
+		}
+		return handler, file, nil
+
+	case OutputJournald:
+		// journald keeps the fields itself, there's no format to pick
+		handler, err := newJournaldHandler(constant.JournaldSocket, filepath.Base(os.Args[0]), handlerOptions)
+		if err != nil {
+			return nil, nil, err
+		}
+		return handler, handler, nil
 	}
 
-	if debug {
-		return slog.NewJSONHandler(writer, handlerOptions)
+	return nil, nil, fmt.Errorf("unknown log output %q, use %s, %s or %s", opts.Output, OutputStderr, OutputFile, OutputJournald)
+}
+
+func formatHandler(format string, writer io.Writer, handlerOptions *slog.HandlerOptions) (slog.Handler, error) {
+	switch format {
+	case FormatLogfmt, "":
+		return slog.NewTextHandler(writer, handlerOptions), nil
+	case FormatJSON:
+		return slog.NewJSONHandler(writer, handlerOptions), nil
+	case FormatText:
+		return newTextHandler(writer, handlerOptions), nil
 	}
 
-	return slog.NewTextHandler(writer, handlerOptions)
+	return nil, fmt.Errorf("unknown log format %q, use %s, %s or %s", format, FormatText, FormatJSON, FormatLogfmt)
+}
+
+func newRunID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(id)
 }
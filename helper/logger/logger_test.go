@@ -0,0 +1,81 @@
+package logger
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "linuxaid.log")
+	file, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := file.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{path: "fourth\n", path + ".1": "third\n", path + ".2": "second\n"}
+	for name, content := range want {
+		got, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Errorf("%s = %q, want %q", filepath.Base(name), got, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected only 2 backups to be kept")
+	}
+}
+
+func TestTeeHandler(t *testing.T) {
+	var primary, tee bytes.Buffer
+	opts := &slog.HandlerOptions{}
+	logger := slog.New(&teeHandler{handlers: []slog.Handler{newTextHandler(&primary, opts), slog.NewTextHandler(&tee, opts)}})
+	logger.With(slog.String("run_id", "abc")).WithGroup("update").Info("upgrading packages", slog.Int("count", 3))
+
+	if got := primary.String(); !strings.Contains(got, "INFO  upgrading packages run_id=abc update.count=3") {
+		t.Errorf("text output = %q", got)
+	}
+	if got := tee.String(); !strings.Contains(got, `msg="upgrading packages" run_id=abc update.count=3`) {
+		t.Errorf("tee output = %q", got)
+	}
+}
+
+func TestJournalFieldName(t *testing.T) {
+	tests := map[string]string{
+		"run_id":          "RUN_ID",
+		"update.count":    "UPDATE_COUNT",
+		"_private":        "PRIVATE",
+		"2fa":             "X_2FA",
+		"exit-code":       "EXIT_CODE",
+		"connectivity ok": "CONNECTIVITY_OK",
+	}
+	for key, want := range tests {
+		if got := journalFieldName(key); got != want {
+			t.Errorf("journalFieldName(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestAppendJournalField(t *testing.T) {
+	var buf bytes.Buffer
+	appendJournalField(&buf, "MESSAGE", "two\nlines")
+
+	want := "MESSAGE\n\x09\x00\x00\x00\x00\x00\x00\x00two\nlines\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
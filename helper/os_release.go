@@ -1,24 +1,32 @@
 package helper
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"os/exec"
+	"regexp"
 	"strings"
 
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
+
 	"github.com/bitfield/script"
 )
 
+// Distribution families, the distributions in a family are updated and provisioned the same way
 const (
-	constDistributionNameUbuntu = "ubuntu"
-	constDistributionNameDebian = "debian"
-	constDistributionNameSLES   = "sles"
-	constDistributionNameCentOS = "centos"
-	constDistributionNameRHEL   = "rhel"
+	FamilyDebian = "debian"
+	FamilyRedHat = "rhel"
+	FamilySUSE   = "suse"
+)
 
+const (
 	constDistributionDebianUpdateRepoListCmd = "apt update"
 	constDistributionSLESUpdateRepoListCmd   = "zypper refresh"
-	constDistributionRHELUpdateRepoListCmd   = "yum repolist"
+	constDistributionRHELUpdateRepoListCmd   = "%s repolist"
 
 	constDistributionDebianCheckCACertificatesCmd = "dpkg-query -W ca-certificates openssl"
 	constDistributionSLESCheckCACertificatesCmd   = "rpm -q ca-certificates openssl ca-certificates-cacert ca-certificates-mozilla"
@@ -26,23 +34,181 @@ const (
 
 	constDistributionDebianInstallCACertificatesCmd = "apt install -y ca-certificates"
 	constDistributionSLESInstallCACertificatesCmd   = "zypper install -y ca-certificates openssl ca-certificates-cacert ca-certificates-mozilla"
-	constDistributionRHELInstallCACertificatesCmd   = "yum install -y ca-certificates openssl"
+	constDistributionRHELInstallCACertificatesCmd   = "%s install -y ca-certificates openssl"
 )
 
+// os-release keys are shell variable names
+var osReleaseKeyRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// The family of the os-release IDs linuxaid knows, ID_LIKE lists them as well
+var familyIDs = map[string]string{
+	"debian":        FamilyDebian,
+	"ubuntu":        FamilyDebian,
+	"rhel":          FamilyRedHat,
+	"centos":        FamilyRedHat,
+	"fedora":        FamilyRedHat,
+	"rocky":         FamilyRedHat,
+	"almalinux":     FamilyRedHat,
+	"ol":            FamilyRedHat,
+	"sles":          FamilySUSE,
+	"sles_sap":      FamilySUSE,
+	"suse":          FamilySUSE,
+	"opensuse":      FamilySUSE,
+	"opensuse-leap": FamilySUSE,
+}
+
+// OSRelease is what os-release(5) says about the distribution
+type OSRelease struct {
+	Name            string
+	PrettyName      string
+	ID              string
+	IDLike          []string
+	VersionID       string
+	VersionCodename string
+	// UbuntuCodename is the Ubuntu release an Ubuntu derivative, e.g. Linux Mint, is built on
+	UbuntuCodename string
+	PlatformID     string
+}
+
+// ReadOSRelease reads /etc/os-release, or /usr/lib/os-release when there's none
+func ReadOSRelease() (OSRelease, error) {
+	return readOSRelease(constant.OSReleaseFile, constant.OSReleaseFallbackFile)
+}
+
+func readOSRelease(paths ...string) (OSRelease, error) {
+	var errs []error
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		defer f.Close()
+
+		return parseOSRelease(f)
+	}
+	return OSRelease{}, fmt.Errorf("unable to read os-release: %w", errors.Join(errs...))
+}
+
+// parseOSRelease parses os-release as os-release(5) describes it: KEY=value lines with shell-like
+// quoting, comments and lines that aren't assignments are skipped
+func parseOSRelease(r io.Reader) (OSRelease, error) {
+	values := make(map[string]string)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || !osReleaseKeyRegexp.MatchString(key) {
+			continue
+		}
+		values[key] = unquoteOSReleaseValue(value)
+	}
+	if err := scanner.Err(); err != nil {
+		return OSRelease{}, fmt.Errorf("unable to read os-release: %w", err)
+	}
+
+	osRelease := OSRelease{
+		Name:            values["NAME"],
+		PrettyName:      values["PRETTY_NAME"],
+		ID:              values["ID"],
+		IDLike:          strings.Fields(values["ID_LIKE"]),
+		VersionID:       values["VERSION_ID"],
+		VersionCodename: values["VERSION_CODENAME"],
+		UbuntuCodename:  values["UBUNTU_CODENAME"],
+		PlatformID:      values["PLATFORM_ID"],
+	}
+
+	// The defaults os-release(5) gives for a missing ID and NAME
+	if osRelease.ID == "" {
+		osRelease.ID = "linux"
+	}
+	if osRelease.Name == "" {
+		osRelease.Name = "Linux"
+	}
+	return osRelease, nil
+}
+
+// unquoteOSReleaseValue removes the single or double quotes around value, in double quotes and
+// unquoted values a backslash escapes $, ", \ and `
+func unquoteOSReleaseValue(value string) string {
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		return value[1 : len(value)-1]
+	}
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+
+	var unquoted strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+1 < len(value) && strings.ContainsRune("$\"\\`", rune(value[i+1])) {
+			i++
+		}
+		unquoted.WriteByte(value[i])
+	}
+	return unquoted.String()
+}
+
+// String returns the name of the distribution release, e.g. Debian GNU/Linux 12 (bookworm)
+func (r OSRelease) String() string {
+	if r.PrettyName != "" {
+		return r.PrettyName
+	}
+	return strings.TrimSpace(r.Name + " " + r.VersionID)
+}
+
+// Family returns the distribution family from ID, ID_LIKE or PLATFORM_ID, empty when it's none linuxaid knows
+func (r OSRelease) Family() string {
+	for _, id := range r.ids() {
+		if family, ok := familyIDs[id]; ok {
+			return family
+		}
+	}
+
+	// RHEL rebuilds without ID_LIKE still say which EL release they are, e.g. platform:el9
+	if strings.HasPrefix(r.PlatformID, "platform:el") {
+		return FamilyRedHat
+	}
+	return ""
+}
+
+// ids returns ID followed by ID_LIKE, the closest match first
+func (r OSRelease) ids() []string {
+	return append([]string{r.ID}, r.IDLike...)
+}
+
+// RedHatPackageManager returns dnf where it's installed and yum on releases without it
+func RedHatPackageManager() string {
+	if _, err := exec.LookPath("dnf"); err == nil {
+		return "dnf"
+	}
+	return "yum"
+}
+
+// CertificateManagerCommands updates the repository list and installs the CA certificates with the package manager of a family
 type CertificateManagerCommands struct {
 	updateRepoListCmd        string
 	checkCACertificatesCmd   string
 	installCACertificatesCmd string
 }
 
-func GetMajorRelease() string {
-	osVersion, _, _ := strings.Cut(os.Getenv("VERSION_ID"), ".")
-	return osVersion
-}
+// IsSupportedOS checks the node runs a release of the support matrix and returns the commands
+// to install the CA certificates on it
+func IsSupportedOS(osRelease OSRelease) (CertificateManagerCommands, error) {
+	release, err := LookupSupport(osRelease)
+	if err != nil {
+		return CertificateManagerCommands{}, err
+	}
+	if release.EndOfLife() {
+		slog.Warn("the distribution release is past its end of life", slog.String("distribution", release.Name),
+			slog.String("release", release.Release.String()), slog.Time("eol", release.EOL))
+	}
 
-// List of Supported OS
-func IsSupportedOS() (CertificateManagerCommands, error) {
-	commands, err := getCommandsForInstallingCACertificates()
+	commands, err := getCommandsForInstallingCACertificates(release.Family)
 	if err != nil {
 		return commands, fmt.Errorf("failed determining the os distribution: %w", err)
 	}
@@ -50,29 +216,48 @@ func IsSupportedOS() (CertificateManagerCommands, error) {
 	return commands, nil
 }
 
-// getCommandsForInstallingCACertificates returns the following for any distribution
+// IsUpdatableOS is IsSupportedOS for nodes that are already set up: a release missing from the support
+// matrix only gets a warning and is updated with the package manager of its family
+func IsUpdatableOS(osRelease OSRelease) (CertificateManagerCommands, error) {
+	commands, err := IsSupportedOS(osRelease)
+	if err == nil {
+		return commands, nil
+	}
+
+	family := osRelease.Family()
+	if family == "" {
+		return commands, err
+	}
+	slog.Warn("the distribution release is not in the support matrix, updating it as a "+family+" distribution",
+		slog.String("os", osRelease.String()), slog.String("reason", err.Error()))
+
+	return getCommandsForInstallingCACertificates(family)
+}
+
+// getCommandsForInstallingCACertificates returns the following for any distribution family
 // 1. command to update repository list
 // 2. command to check if CA certificates are installed
 // 3. command to install CA certificates
-func getCommandsForInstallingCACertificates() (CertificateManagerCommands, error) {
-	switch os.Getenv("ID") {
-	case constDistributionNameUbuntu, constDistributionNameDebian:
+func getCommandsForInstallingCACertificates(family string) (CertificateManagerCommands, error) {
+	switch family {
+	case FamilyDebian:
 		return CertificateManagerCommands{
 			updateRepoListCmd:        constDistributionDebianUpdateRepoListCmd,
 			checkCACertificatesCmd:   constDistributionDebianCheckCACertificatesCmd,
 			installCACertificatesCmd: constDistributionDebianInstallCACertificatesCmd,
 		}, nil
-	case constDistributionNameSLES:
+	case FamilySUSE:
 		return CertificateManagerCommands{
 			updateRepoListCmd:        constDistributionSLESUpdateRepoListCmd,
 			checkCACertificatesCmd:   constDistributionSLESCheckCACertificatesCmd,
 			installCACertificatesCmd: constDistributionSLESInstallCACertificatesCmd,
 		}, nil
-	case constDistributionNameCentOS, constDistributionNameRHEL:
+	case FamilyRedHat:
+		packageManager := RedHatPackageManager()
 		return CertificateManagerCommands{
-			updateRepoListCmd:        constDistributionRHELUpdateRepoListCmd,
+			updateRepoListCmd:        fmt.Sprintf(constDistributionRHELUpdateRepoListCmd, packageManager),
 			checkCACertificatesCmd:   constDistributionRHELCheckCACertificatesCmd,
-			installCACertificatesCmd: constDistributionRHELInstallCACertificatesCmd,
+			installCACertificatesCmd: fmt.Sprintf(constDistributionRHELInstallCACertificatesCmd, packageManager),
 		}, nil
 	}
 	return CertificateManagerCommands{}, errors.New("unknown distribution")
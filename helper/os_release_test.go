@@ -0,0 +1,64 @@
+package helper
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseOSRelease(t *testing.T) {
+	osRelease, err := parseOSRelease(strings.NewReader(`# Linux Mint
+NAME="Linux Mint"
+PRETTY_NAME='Linux Mint 21.3 "Virginia"'
+ID=linuxmint
+ID_LIKE="ubuntu debian"
+VERSION_ID="21.3"
+VERSION_CODENAME=virginia
+UBUNTU_CODENAME=jammy
+HOME_URL="https://www.linuxmint.com/\$home"
+not an assignment
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := OSRelease{
+		Name:            "Linux Mint",
+		PrettyName:      `Linux Mint 21.3 "Virginia"`,
+		ID:              "linuxmint",
+		VersionID:       "21.3",
+		VersionCodename: "virginia",
+		UbuntuCodename:  "jammy",
+		IDLike:          []string{"ubuntu", "debian"},
+	}
+	if !reflect.DeepEqual(osRelease, want) {
+		t.Errorf("got %+v, want %+v", osRelease, want)
+	}
+
+	if got := unquoteOSReleaseValue(`"a \"quoted\" \$value\\"`); got != `a "quoted" $value\` {
+		t.Errorf("unquote = %q", got)
+	}
+}
+
+func TestReadOSReleaseFallback(t *testing.T) {
+	dir := t.TempDir()
+	fallback := filepath.Join(dir, "usr-lib-os-release")
+	if err := os.WriteFile(fallback, []byte("VERSION_ID=12\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	osRelease, err := readOSRelease(filepath.Join(dir, "missing"), fallback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// os-release(5) defaults
+	if osRelease.ID != "linux" || osRelease.Name != "Linux" || osRelease.VersionID != "12" {
+		t.Errorf("got %+v", osRelease)
+	}
+
+	if _, err := readOSRelease(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected an error without any os-release")
+	}
+}
@@ -9,7 +9,10 @@ import (
 	"github.com/schollz/progressbar/v3"
 )
 
-var bar *progressbar.ProgressBar
+var (
+	bar   *progressbar.ProgressBar
+	plain bool
+)
 
 type (
 	indentWriter struct {
@@ -32,8 +35,17 @@ func (pbw *progressBarWriter) Write(p []byte) (n int, err error) {
 	return progressbar.Bprintf(pbw.bar, "%s", string(p))
 }
 
-// Create progress bar once
-func InitProgressBar() *progressBarWriter {
+// SetPlain replaces the spinner with a line per step, for output that isn't a terminal
+func SetPlain() {
+	plain = true
+}
+
+// Create progress bar once, in plain mode there's no bar and stderr is returned
+func InitProgressBar() io.Writer {
+	if plain {
+		return os.Stderr
+	}
+
 	if bar == nil {
 		// Create indented writer
 		writer := &indentWriter{
@@ -55,6 +67,11 @@ func InitProgressBar() *progressBarWriter {
 }
 
 func NonDeterministicFunc(description string, function func() error) error {
+	if plain {
+		prettyfmt.PrettyPrintf("%s %s...\n", prettyfmt.IconGear, description)
+		return printResult(description, function())
+	}
+
 	bar.Reset()
 	bar.Describe(description)
 	bar.RenderBlank() // nolint: errcheck
@@ -63,6 +80,18 @@ func NonDeterministicFunc(description string, function func() error) error {
 	bar.RenderBlank() // nolint: errcheck
 	bar.Finish()      // nolint: errcheck
 
+	return printResult(description, err)
+}
+
+// Describe changes the description of the running step, e.g. to count what it got done
+func Describe(description string) {
+	if plain || bar == nil {
+		return
+	}
+	bar.Describe(description)
+}
+
+func printResult(description string, err error) error {
 	if err != nil {
 		prettyfmt.PrettyPrintf("%s %s\n", prettyfmt.FontRed(prettyfmt.IconCheckFail), prettyfmt.FontWhite(description))
 		return err
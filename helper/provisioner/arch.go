@@ -0,0 +1,67 @@
+package provisioner
+
+import (
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper"
+)
+
+// architecture is what the package formats call a CPU architecture
+type architecture struct {
+	deb string
+	rpm string
+}
+
+// Package architecture names, by the Go name of the architecture
+var architectures = map[string]architecture{
+	"amd64":   {deb: "amd64", rpm: "x86_64"},
+	"arm64":   {deb: "arm64", rpm: "aarch64"},
+	"ppc64le": {deb: "ppc64el", rpm: "ppc64le"},
+	"s390x":   {deb: "s390x", rpm: "s390x"},
+}
+
+// What dpkg and uname -m call the architectures, where it's not the Go name
+var architectureAliases = map[string]string{
+	"x86_64":  "amd64",
+	"aarch64": "arm64",
+	"ppc64el": "ppc64le",
+}
+
+// detectArchitecture returns the architecture the package manager installs packages for,
+// which can differ from the kernel's, e.g. a 32-bit userland on a 64-bit kernel
+func detectArchitecture() string {
+	for _, command := range [][]string{{"dpkg", "--print-architecture"}, {"uname", "-m"}} {
+		out, err := exec.Command(command[0], command[1:]...).Output()
+		if arch := strings.TrimSpace(string(out)); err == nil && arch != "" {
+			return normalizeArchitecture(arch)
+		}
+	}
+	return runtime.GOARCH
+}
+
+func normalizeArchitecture(arch string) string {
+	if name, ok := architectureAliases[arch]; ok {
+		return name
+	}
+	return arch
+}
+
+// packageArchitecture returns what the package format of release calls the architecture of this node,
+// or an error when there are no openvox agent packages for it
+func (s *Provisioner) packageArchitecture(release *helper.SupportedRelease) (string, error) {
+	if s.arch == "" {
+		s.arch = detectArchitecture()
+	}
+
+	if err := release.CheckArchitecture(s.arch); err != nil {
+		return "", err
+	}
+
+	arch := architectures[s.arch]
+	if release.Format == "rpm" {
+		return arch.rpm, nil
+	}
+	return arch.deb, nil
+}
@@ -0,0 +1,163 @@
+package provisioner
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper"
+)
+
+const repoName = "obmondo-openvox"
+
+// Agent versions end up in shell commands, so only what package versions look like is accepted
+var versionRegexp = regexp.MustCompile(`^[0-9][0-9A-Za-z.+~]*$`)
+
+// rpmRepo is the repository definition dnf, yum and zypper share, pinned to the Obmondo signing key
+const rpmRepo = `[%s]
+name=Obmondo %s
+baseurl=%s
+enabled=1
+gpgcheck=1
+gpgkey=file://%s
+`
+
+// debianAgentPackage installs puppet-agent on Ubuntu/Debian systems
+func (s *Provisioner) debianAgentPackage(release *helper.SupportedRelease) (*agentPackage, error) {
+	arch, err := s.packageArchitecture(release)
+	if err != nil {
+		return nil, err
+	}
+
+	prepare := []string{"apt update", "apt install -y iptables"}
+
+	if !s.directDownload {
+		pkg := constant.OpenvoxPackageName
+		if s.version != "" {
+			pkg = fmt.Sprintf("%s=%s-*", pkg, s.version)
+		}
+
+		return &agentPackage{
+			prepare: prepare,
+			repo: &agentRepo{
+				file: constant.AptOpenvoxSourceFile,
+				content: fmt.Sprintf("deb [arch=%s signed-by=%s] %s/%s %s %s\n", arch, constant.AptOpenvoxKeyFile,
+					constant.OpenvoxRepoURL, release.Expand(release.Repo, arch), release.Codename, constant.PuppetMajorVersion),
+				keyFile: constant.AptOpenvoxKeyFile,
+			},
+			install: fmt.Sprintf("apt-get update && apt-get install -y '%s'", pkg),
+		}, nil
+	}
+
+	fullPuppetVersion := fmt.Sprintf("%s-1+%s", s.WantedVersion(), release.Expand(release.PackageRelease, arch))
+	packageName := fmt.Sprintf("openvox-agent_%s_%s.deb", fullPuppetVersion, arch)
+	downloadPath := filepath.Join(s.downloadDir, packageName)
+
+	return &agentPackage{
+		prepare:         prepare,
+		url:             fmt.Sprintf("%s/%s/%s", constant.OpenvoxRepoURL, release.Expand(release.Packages, arch), packageName),
+		path:            downloadPath,
+		verifySignature: verifyDebSignature,
+		install:         fmt.Sprintf("apt install -y %s", downloadPath),
+	}, nil
+}
+
+// redHatAgentPackage installs puppet-agent on RHEL and its derivatives
+func (s *Provisioner) redHatAgentPackage(release *helper.SupportedRelease) (*agentPackage, error) {
+	arch, err := s.packageArchitecture(release)
+	if err != nil {
+		return nil, err
+	}
+
+	packageManager := helper.RedHatPackageManager()
+	prepare := []string{packageManager + " install -y iptables"}
+
+	if !s.directDownload {
+		pkg := constant.OpenvoxPackageName
+		if s.version != "" {
+			pkg = fmt.Sprintf("%s-%s", pkg, s.version)
+		}
+
+		baseURL := fmt.Sprintf("%s/%s", constant.OpenvoxRepoURL, release.Expand(release.Repo, arch))
+		return &agentPackage{
+			prepare: prepare,
+			repo: &agentRepo{
+				file:      constant.YumOpenvoxRepoFile,
+				content:   fmt.Sprintf(rpmRepo, repoName, constant.PuppetMajorVersion, baseURL, constant.RPMOpenvoxKeyFile),
+				keyFile:   constant.RPMOpenvoxKeyFile,
+				importKey: []string{"rpm", "--import", constant.RPMOpenvoxKeyFile},
+			},
+			install: fmt.Sprintf("%s install -y '%s'", packageManager, pkg),
+		}, nil
+	}
+
+	packageName := s.rpmPackageName(release, arch)
+	downloadPath := filepath.Join(s.downloadDir, packageName)
+
+	return &agentPackage{
+		prepare:         prepare,
+		url:             fmt.Sprintf("%s/%s/%s", constant.OpenvoxRepoURL, release.Expand(release.Packages, arch), packageName),
+		path:            downloadPath,
+		verifySignature: verifyRPMSignature,
+		install:         fmt.Sprintf("%s install -y %s", packageManager, downloadPath),
+	}, nil
+}
+
+// suseAgentPackage installs puppet-agent on SUSE systems
+func (s *Provisioner) suseAgentPackage(release *helper.SupportedRelease) (*agentPackage, error) {
+	arch, err := s.packageArchitecture(release)
+	if err != nil {
+		return nil, err
+	}
+
+	prepare := []string{"zypper install -y iptables"}
+
+	if !s.directDownload {
+		pkg := constant.OpenvoxPackageName
+		if s.version != "" {
+			pkg = fmt.Sprintf("%s=%s", pkg, s.version)
+		}
+
+		baseURL := fmt.Sprintf("%s/%s", constant.OpenvoxRepoURL, release.Expand(release.Repo, arch))
+		return &agentPackage{
+			prepare: prepare,
+			repo: &agentRepo{
+				file:      constant.ZypperOpenvoxRepoFile,
+				content:   fmt.Sprintf(rpmRepo, repoName, constant.PuppetMajorVersion, baseURL, constant.RPMOpenvoxKeyFile),
+				keyFile:   constant.RPMOpenvoxKeyFile,
+				importKey: []string{"rpm", "--import", constant.RPMOpenvoxKeyFile},
+			},
+			install: fmt.Sprintf("zypper --non-interactive refresh %s && zypper --non-interactive install '%s'", repoName, pkg),
+		}, nil
+	}
+
+	packageName := s.rpmPackageName(release, arch)
+	downloadPath := filepath.Join(s.downloadDir, packageName)
+
+	return &agentPackage{
+		prepare:         prepare,
+		url:             fmt.Sprintf("%s/%s/%s", constant.OpenvoxRepoURL, release.Expand(release.Packages, arch), packageName),
+		path:            downloadPath,
+		verifySignature: verifyRPMSignature,
+		install:         fmt.Sprintf("rpm -Uvh %s", downloadPath),
+	}, nil
+}
+
+// rpmPackageName is the file name of the agent package, e.g. openvox-agent-8.23.1-1.el9.x86_64.rpm
+func (s *Provisioner) rpmPackageName(release *helper.SupportedRelease, arch string) string {
+	return fmt.Sprintf("openvox-agent-%s-1.%s.%s.rpm", s.WantedVersion(), release.Expand(release.PackageRelease, arch), arch)
+}
+
+// turrisAgentPackage installs puppet via gem on TurrisOS, there's no openvox package for it
+func (s *Provisioner) turrisAgentPackage() *agentPackage {
+	version := s.version
+	if version == "" {
+		version = constant.PuppetVersion
+	}
+
+	return &agentPackage{
+		prepare: []string{"opkg update", "opkg install ruby ruby-full ruby-gems"},
+		install: fmt.Sprintf("gem install puppet -v %s --no-document", version),
+	}
+}
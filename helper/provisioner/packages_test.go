@@ -0,0 +1,142 @@
+package provisioner
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper"
+)
+
+func lookupRelease(t *testing.T, id, versionID, codename string) *helper.SupportedRelease {
+	t.Helper()
+
+	release, err := helper.LookupSupport(helper.OSRelease{ID: id, VersionID: versionID, VersionCodename: codename})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return release
+}
+
+func TestRepositoryPackages(t *testing.T) {
+	pinned := &Provisioner{version: "8.23.1", arch: "arm64"}
+
+	debian, err := pinned.debianAgentPackage(lookupRelease(t, "debian", "12", "bookworm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "deb [arch=arm64 signed-by=/etc/apt/keyrings/obmondo-openvox.asc] https://repos.obmondo.com/openvox/apt bookworm openvox8\n"; debian.repo.content != want {
+		t.Errorf("apt source = %q, want %q", debian.repo.content, want)
+	}
+	if !strings.HasSuffix(debian.install, "apt-get install -y 'openvox-agent=8.23.1-*'") {
+		t.Errorf("apt install = %q", debian.install)
+	}
+
+	redHat, err := (&Provisioner{arch: "amd64"}).redHatAgentPackage(lookupRelease(t, "rocky", "9.4", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(redHat.repo.content, "baseurl=https://repos.obmondo.com/openvox/yum/openvox8/el/9/x86_64\n") ||
+		!strings.Contains(redHat.repo.content, "gpgcheck=1\n") {
+		t.Errorf("yum repo = %q", redHat.repo.content)
+	}
+	if !strings.HasSuffix(redHat.install, " install -y 'openvox-agent'") {
+		t.Errorf("yum install = %q", redHat.install)
+	}
+}
+
+func TestRepositoryKeyMismatch(t *testing.T) {
+	const pinned = "D6811ED3ADEEB8441AF5AA8F4528B6CD9E61EF26"
+	colons := []byte(`pub:-:4096:1:1A2B3C4D5E6F7081:1554759562:1744129562::-:::scESC::::::23::0:
+fpr:::::::::0123456789ABCDEF0123456789ABCDEF01234567:
+`)
+	if err := matchFingerprint(keyFingerprints(colons), pinned); err == nil {
+		t.Error("a key with another fingerprint was accepted")
+	}
+
+	// The pinned key next to another one would trust the other one as well
+	colons = append(colons, []byte(`pub:-:4096:1:4528B6CD9E61EF26:1554759562:1744129562::-:::scESC::::::23::0:
+fpr:::::::::D6811ED3ADEEB8441AF5AA8F4528B6CD9E61EF26:
+`)...)
+	if err := matchFingerprint(keyFingerprints(colons), pinned); err == nil {
+		t.Error("a key file with an extra key was accepted")
+	}
+
+	// Without a pinned fingerprint nothing is downloaded, let alone trusted
+	keyFile := filepath.Join(t.TempDir(), "keyrings", "obmondo-openvox.asc")
+	err := (&Provisioner{}).addRepository(&agentRepo{keyFile: keyFile, importKey: []string{"false"}})
+	if err == nil || !strings.Contains(err.Error(), "no openvox signing key fingerprint") {
+		t.Errorf("expected the missing fingerprint to stop the repository, got: %v", err)
+	}
+	if _, err := os.Stat(keyFile); !os.IsNotExist(err) {
+		t.Errorf("the signing key was written without being checked: %v", err)
+	}
+}
+
+func TestDirectDownloadPackages(t *testing.T) {
+	direct := &Provisioner{directDownload: true, arch: "arm64", downloadDir: "/tmp/linuxaid"}
+
+	suse, err := direct.suseAgentPackage(lookupRelease(t, "sles", "15.6", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "https://repos.obmondo.com/openvox/sles/openvox8/15/aarch64/openvox-agent-8.23.1-1.sles15.aarch64.rpm"; suse.url != want {
+		t.Errorf("url = %q, want %q", suse.url, want)
+	}
+
+	debian, err := direct.debianAgentPackage(lookupRelease(t, "debian", "13", "trixie"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "https://repos.obmondo.com/openvox/apt/pool/openvox8/o/openvox-agent/openvox-agent_8.23.1-1+debian13_arm64.deb"; debian.url != want {
+		t.Errorf("url = %q, want %q", debian.url, want)
+	}
+}
+
+func TestUnsupportedArchitecture(t *testing.T) {
+	if _, err := (&Provisioner{arch: "ppc64le"}).debianAgentPackage(lookupRelease(t, "ubuntu", "24.04", "noble")); err == nil ||
+		!strings.Contains(err.Error(), "published for amd64, arm64, not ppc64le") {
+		t.Errorf("ppc64le on Ubuntu: got error %v", err)
+	}
+	if _, err := (&Provisioner{arch: "riscv64"}).redHatAgentPackage(lookupRelease(t, "rhel", "9.4", "")); err == nil ||
+		!strings.Contains(err.Error(), "not riscv64") {
+		t.Errorf("riscv64 on RHEL: got error %v", err)
+	}
+}
+
+func TestAgentVersionValidation(t *testing.T) {
+	debian := helper.OSRelease{ID: "debian", VersionID: "12", VersionCodename: "bookworm"}
+
+	for version, valid := range map[string]bool{"8.23.1": true, "8": true, "8.23.1; rm -rf /": false, "'8'": false, "latest": false} {
+		_, err := (&Provisioner{version: version, arch: "amd64", osRelease: debian}).agentPackage()
+		if (err == nil) != valid {
+			t.Errorf("version %q: got error %v, want valid=%t", version, err, valid)
+		}
+	}
+}
+
+func TestHasAgentVersion(t *testing.T) {
+	installed := func(version string, err error) func() (string, error) {
+		return func() (string, error) { return version, err }
+	}
+
+	tests := []struct {
+		name        string
+		provisioner *Provisioner
+		want        bool
+	}{
+		// An old puppet-agent answers puppet --version, but openvox-agent still has to be installed
+		{"other vendor", &Provisioner{packageVersion: installed("", errors.New("package openvox-agent is not installed"))}, false},
+		{"repository", &Provisioner{packageVersion: installed("8.23.1-1+ubuntu24.04", nil)}, true},
+		{"pinned", &Provisioner{version: "8.23.1", packageVersion: installed("8.23.1-1+ubuntu24.04", nil)}, true},
+		{"pinned rpm", &Provisioner{version: "8.23", packageVersion: installed("8.23.1", nil)}, true},
+		{"other version", &Provisioner{version: "8.24.0", packageVersion: installed("8.23.1", nil)}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.provisioner.HasAgentVersion(); got != tt.want {
+			t.Errorf("%s: got %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
@@ -1,11 +1,15 @@
 package provisioner
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/config"
 	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
 	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper"
 	api "gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/obmondo"
@@ -13,129 +17,357 @@ import (
 	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/webtee"
 )
 
-const tmpDir = "/tmp"
-
 type Provisioner struct {
 	webtee    *webtee.Webtee
 	apiClient api.ObmondoClient
 	puppet    *puppet.Service
 	certName  string
+	osRelease helper.OSRelease
+	// version pins the openvox agent version, empty installs the latest one from the repository
+	version string
+	// directDownload installs a single package downloaded from the repository server, without adding the repository
+	directDownload bool
+	// downloadDir is the private directory the package is downloaded and verified in, created by DownloadAgent
+	downloadDir string
+	// arch is the architecture of the node by its Go name, detected when empty
+	arch string
+	// packageVersion returns the installed version of the openvox-agent package, queried from dpkg or rpm when nil
+	packageVersion func() (string, error)
 }
 
 // NewService creates a new Puppet installer service.
-func NewService(apiClient api.ObmondoClient, puppet *puppet.Service, webtee *webtee.Webtee) *Provisioner {
+func NewService(apiClient api.ObmondoClient, puppet *puppet.Service, webtee *webtee.Webtee, osRelease helper.OSRelease) *Provisioner {
 	return &Provisioner{
-		apiClient: apiClient,
-		puppet:    puppet,
-		certName:  helper.GetCertname(),
-		webtee:    webtee,
+		osRelease:      osRelease,
+		apiClient:      apiClient,
+		puppet:         puppet,
+		certName:       helper.GetCertname(),
+		webtee:         webtee,
+		version:        config.GetAgentVersion(),
+		directDownload: config.UseDirectDownload(),
 	}
 }
 
-func (s *Provisioner) ProvisionPuppet() {
-	switch os.Getenv("ID") {
-	case "ubuntu", "debian":
-		if err := s.provisionForDebian(); err != nil {
-			slog.Error("failed to install puppet", slog.Any("error", err))
-			os.Exit(1)
-		}
-	case "sles":
-		if err := s.provisionForSuse(); err != nil {
-			slog.Error("failed to install puppet", slog.Any("error", err))
-			os.Exit(1)
-		}
-	case "centos", "rhel":
-		if err := s.provisionForRedHat(); err != nil {
-			slog.Error("failed to install puppet", slog.Any("error", err))
-			os.Exit(1)
+// agentPackage is how the openvox agent gets installed on this distribution
+type agentPackage struct {
+	// prepare runs before the package is installed
+	prepare []string
+	// repo is added before the package is installed, nil when the package is downloaded directly
+	repo *agentRepo
+	// url is downloaded to path, empty when the package manager fetches it itself
+	url  string
+	path string
+	// verifySignature checks the signature of the downloaded package with the repository key
+	verifySignature func(path, keyFile string) error
+	// install installs the agent
+	install string
+}
+
+// agentRepo is the Obmondo openvox package repository
+type agentRepo struct {
+	// file is the repository definition with its content
+	file    string
+	content string
+	// keyFile is where the repository signing key is stored, importKey runs after it's written
+	keyFile   string
+	importKey []string
+}
+
+// DownloadAgent adds the openvox repository, or downloads and verifies the agent package when installing it directly
+func (s *Provisioner) DownloadAgent() error {
+	if s.directDownload && s.downloadDir == "" {
+		dir, err := helper.TempDir("linuxaid-openvox-")
+		if err != nil {
+			return err
 		}
-	case "turrisos":
-		s.provisionForTurris()
-	default:
-		slog.Error("unknown distribution, exiting")
-		os.Exit(1)
-	}
-}
-
-// provisionForDebian installs puppet-agent on Ubuntu/Debian systems
-func (s *Provisioner) provisionForDebian() error {
-	helper.RequireUbuntuCodeNameEnv()
-
-	codeName := os.Getenv("UBUNTU_CODENAME")
-	s.webtee.RemoteLogObmondo([]string{"apt update"}, s.certName)
-	s.webtee.RemoteLogObmondo([]string{"apt install -y iptables"}, s.certName)
-	var ubuntuVersion string
-	switch codeName {
-	case "jammy":
-		ubuntuVersion = "ubuntu22.04"
-	case "noble":
-		ubuntuVersion = "ubuntu24.04"
-	}
-
-	fullPuppetVersion := fmt.Sprintf("%s-1+%s", constant.PuppetVersion, ubuntuVersion)
-	packageName := fmt.Sprintf("openvox-agent_%s_amd64.deb", fullPuppetVersion)
-	downloadPath := filepath.Join(tmpDir, packageName)
-	url := fmt.Sprintf("https://repos.obmondo.com/openvox/apt/pool/%s/o/openvox-agent/%s",
-		constant.PuppetMajorVersion, packageName)
-	if err := s.puppet.DownloadAgent(downloadPath, url); err != nil {
+		s.downloadDir = dir
+	}
+
+	pkg, err := s.agentPackage()
+	if err != nil {
 		return err
 	}
 
-	installCmd := []string{fmt.Sprintf("apt install -y %s", downloadPath)}
-	s.webtee.RemoteLogObmondo(installCmd, s.certName)
+	if pkg.repo != nil {
+		return s.addRepository(pkg.repo)
+	}
+	if pkg.url == "" {
+		return nil
+	}
+
+	if err := s.puppet.DownloadAgent(pkg.path, pkg.url); err != nil {
+		slog.Error("failed to download puppet", slog.Any("error", err))
+		return err
+	}
 
+	// Nothing that fails verification is left around to be installed by a later run
+	if err := s.verifyPackage(pkg); err != nil {
+		slog.Error("openvox agent package verification failed", slog.String("url", pkg.url), slog.Any("error", err))
+		if err := os.Remove(pkg.path); err != nil {
+			slog.Warn("failed to remove the unverified package", slog.String("path", pkg.path), slog.Any("error", err))
+		}
+		return fmt.Errorf("refusing to install %s: %w", filepath.Base(pkg.path), err)
+	}
 	return nil
 }
 
-// provisionForRedHat installs puppet-agent on RHEL/CentOS systems
-func (s *Provisioner) provisionForRedHat() error {
-	s.webtee.RemoteLogObmondo([]string{"yum install -y iptables"}, s.certName)
+// DownloadDescription says what DownloadAgent does, for the install progress
+func (s *Provisioner) DownloadDescription() string {
+	if s.directDownload {
+		return "Downloading Openvox"
+	}
+	return "Adding Openvox Repository"
+}
+
+// IsDownloaded tells if the openvox repository was added or the package downloaded, or if neither is needed
+func (s *Provisioner) IsDownloaded() bool {
+	// A package downloaded by an earlier run was removed with its download directory
+	if s.directDownload && s.downloadDir == "" {
+		return false
+	}
+
+	pkg, err := s.agentPackage()
+	if err != nil {
+		return false
+	}
 
-	majRelease := helper.GetMajorRelease()
+	path := pkg.path
+	if pkg.repo != nil {
+		path = pkg.repo.file
+	}
+	if path == "" {
+		return true
+	}
 
-	fullPuppetVersion := fmt.Sprintf("%s-1.el%s", constant.PuppetVersion, majRelease)
-	packageName := fmt.Sprintf("openvox-agent-%s.x86_64", fullPuppetVersion)
-	downloadPath := filepath.Join(tmpDir, packageName+".rpm")
-	url := fmt.Sprintf("https://repos.obmondo.com/openvox/yum/%s/el/%s/x86_64/%s.rpm",
-		constant.PuppetMajorVersion, majRelease, packageName)
+	_, err = os.Stat(path)
+	return err == nil
+}
 
-	if err := s.puppet.DownloadAgent(downloadPath, url); err != nil {
+// addRepository imports the signing key and adds the repository pinned to it. The key is only
+// trusted once it's checked against the fingerprint built into the binary.
+func (s *Provisioner) addRepository(repo *agentRepo) error {
+	key, err := s.downloadSigningKey()
+	if err != nil {
 		return err
 	}
 
-	installCmd := []string{fmt.Sprintf("yum install %s -y", downloadPath)}
-	s.webtee.RemoteLogObmondo(installCmd, s.certName)
+	if err := os.MkdirAll(filepath.Dir(repo.keyFile), 0o755); err != nil {
+		return fmt.Errorf("failed to create the keyring directory: %w", err)
+	}
+	if err := os.WriteFile(repo.keyFile, key, 0o644); err != nil {
+		return fmt.Errorf("failed to write the repository signing key: %w", err)
+	}
+
+	if len(repo.importKey) > 0 {
+		if out, err := exec.Command(repo.importKey[0], repo.importKey[1:]...).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to import the repository signing key: %w: %s", err, strings.TrimSpace(string(out)))
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(repo.file), 0o755); err != nil {
+		return fmt.Errorf("failed to create the repository directory: %w", err)
+	}
+	if err := os.WriteFile(repo.file, []byte(repo.content), 0o644); err != nil {
+		return fmt.Errorf("failed to add the openvox repository: %w", err)
+	}
 
+	slog.Info("added the openvox repository", slog.String("file", repo.file))
 	return nil
 }
 
-// provisionForSuse installs puppet-agent on SUSE systems
-func (s *Provisioner) provisionForSuse() error {
-	s.webtee.RemoteLogObmondo([]string{"zypper install -y iptables"}, s.certName)
+// CheckSupported fails when there's no openvox agent package for this distribution and architecture
+func (s *Provisioner) CheckSupported() error {
+	if _, err := s.agentPackage(); err != nil {
+		return err
+	}
+
+	slog.Info("openvox agent package is available", slog.String("distribution", s.osRelease.ID), slog.String("architecture", s.arch))
+	return nil
+}
 
-	majRelease := helper.GetMajorRelease()
+// ProvisionPuppet downloads and installs the openvox agent the way the distribution does it
+func (s *Provisioner) ProvisionPuppet() error {
+	if err := s.DownloadAgent(); err != nil {
+		return err
+	}
+	return s.InstallAgent()
+}
 
-	fullPuppetVersion := fmt.Sprintf("%s-1.sles%s", constant.PuppetVersion, majRelease)
-	packageName := fmt.Sprintf("openvox-agent-%s.x86_64", fullPuppetVersion)
-	downloadPath := filepath.Join(tmpDir, packageName+".rpm")
-	url := fmt.Sprintf("https://repos.obmondo.com/openvox/sles/%s/%s/x86_64/%s.rpm",
-		constant.PuppetMajorVersion, majRelease, packageName)
+// InstallAgent installs the openvox agent from the repository or the downloaded package
+func (s *Provisioner) InstallAgent() error {
+	// The package is only downloaded and verified by DownloadAgent of the same run
+	if s.directDownload && s.downloadDir == "" {
+		return errors.New("the openvox agent package wasn't downloaded by this run, run the download step again")
+	}
 
-	if err := s.puppet.DownloadAgent(downloadPath, url); err != nil {
+	pkg, err := s.agentPackage()
+	if err != nil {
 		return err
 	}
 
-	installCmd := []string{fmt.Sprintf("rpm -ivh %s", downloadPath)}
-	s.webtee.RemoteLogObmondo(installCmd, s.certName)
+	for _, command := range pkg.prepare {
+		if err := s.runCommand(command); err != nil {
+			return err
+		}
+	}
+	if err := s.runCommand(pkg.install); err != nil {
+		return err
+	}
 
+	if s.downloadDir != "" {
+		if err := os.RemoveAll(s.downloadDir); err != nil {
+			slog.Warn("failed to remove the download directory", slog.String("path", s.downloadDir), slog.Any("error", err))
+		}
+		s.downloadDir = ""
+	}
+
+	version, err := InstalledAgentVersion()
+	if err != nil {
+		slog.Error("failed to install puppet", slog.Any("error", err))
+		return fmt.Errorf("openvox agent is not installed after %q: %w", pkg.install, err)
+	}
+	slog.Info("openvox agent installed", slog.String("version", version))
+
+	return nil
+}
+
+// downloadSigningKey downloads the repository signing key into a private directory and returns
+// it, when it's the key with the pinned fingerprint and nothing else
+func (s *Provisioner) downloadSigningKey() ([]byte, error) {
+	fingerprint, err := pinnedFingerprint()
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := helper.TempDir("linuxaid-openvox-key-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			slog.Warn("failed to remove the key download directory", slog.String("path", dir), slog.Any("error", err))
+		}
+	}()
+
+	keyFile := filepath.Join(dir, "GPG-KEY-openvox")
+	if err := s.puppet.DownloadAgent(keyFile, constant.OpenvoxRepoKeyURL); err != nil {
+		return nil, fmt.Errorf("failed to download the repository signing key: %w", err)
+	}
+
+	home := filepath.Join(dir, "gnupg")
+	if err := os.Mkdir(home, 0o700); err != nil {
+		return nil, err
+	}
+	if err := checkSigningKey(home, keyFile, fingerprint); err != nil {
+		return nil, fmt.Errorf("refusing to add the openvox repository: %w", err)
+	}
+
+	return os.ReadFile(keyFile)
+}
+
+// runCommand runs an install command with its output sent to webtee, and tells Obmondo when it fails
+func (s *Provisioner) runCommand(command string) error {
+	exitCode, err := s.webtee.Stream([]string{command}, s.certName, nil)
+	if err == nil && exitCode != 0 {
+		err = fmt.Errorf("exit code %d", exitCode)
+	}
+	if err != nil {
+		// nolint: errcheck
+		s.apiClient.NotifyInstallScriptFailure(&api.InstallScriptInput{
+			Certname: s.certName,
+		})
+		return fmt.Errorf("%q failed: %w", command, err)
+	}
 	return nil
 }
 
-// provisionForTurris installs puppet via gem on TurrisOS
-func (s *Provisioner) provisionForTurris() {
-	s.webtee.RemoteLogObmondo([]string{"opkg update"}, s.certName)
-	s.webtee.RemoteLogObmondo([]string{"opkg install ruby ruby-full ruby-gems"}, s.certName)
+// InstalledAgentVersion returns the version of the installed openvox agent, e.g. 8.23.1
+func InstalledAgentVersion() (string, error) {
+	out, err := exec.Command("puppet", "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("unable to get the openvox agent version: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// HasAgentVersion tells if the openvox-agent package is installed in the wanted version, without a
+// pinned version any version from the repository will do. A puppet-agent of another vendor doesn't count.
+func (s *Provisioner) HasAgentVersion() bool {
+	packageVersion := s.packageVersion
+	if packageVersion == nil {
+		packageVersion = s.installedPackageVersion
+	}
+
+	version, err := packageVersion()
+	if err != nil {
+		slog.Debug("openvox agent package is not installed", slog.Any("error", err))
+		return false
+	}
+
+	want := s.WantedVersion()
+	return want == "" || version == want || strings.HasPrefix(version, want+".") || strings.HasPrefix(version, want+"-")
+}
+
+// installedPackageVersion returns the version of the installed openvox-agent package, e.g. 8.23.1-1+ubuntu24.04 or 8.23.1
+func (s *Provisioner) installedPackageVersion() (string, error) {
+	// TurrisOS gets puppet as a gem, there's no package to ask about
+	if s.osRelease.ID == "turrisos" {
+		return InstalledAgentVersion()
+	}
+
+	switch s.osRelease.Family() {
+	case helper.FamilyDebian:
+		out, err := exec.Command("dpkg-query", "-W", "-f=${db:Status-Abbrev} ${Version}", constant.OpenvoxPackageName).Output()
+		if err != nil {
+			return "", fmt.Errorf("%s is not installed: %w", constant.OpenvoxPackageName, err)
+		}
+		// dpkg keeps the config files of removed packages around, only ii is installed
+		fields := strings.Fields(string(out))
+		if len(fields) != 2 || !strings.HasPrefix(fields[0], "ii") {
+			return "", fmt.Errorf("%s is not installed: %s", constant.OpenvoxPackageName, strings.TrimSpace(string(out)))
+		}
+		return fields[1], nil
+	case helper.FamilyRedHat, helper.FamilySUSE:
+		out, err := exec.Command("rpm", "-q", "--qf", "%{VERSION}", constant.OpenvoxPackageName).Output()
+		if err != nil {
+			return "", fmt.Errorf("%s is not installed: %w", constant.OpenvoxPackageName, err)
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+	return "", fmt.Errorf("unknown distribution family of %s", s.osRelease.ID)
+}
+
+// WantedVersion returns the openvox agent version to install, empty for the latest one from the repository
+func (s *Provisioner) WantedVersion() string {
+	if s.version == "" && s.directDownload {
+		return constant.PuppetVersion
+	}
+	return s.version
+}
+
+func (s *Provisioner) agentPackage() (*agentPackage, error) {
+	if s.version != "" && !versionRegexp.MatchString(s.version) {
+		return nil, fmt.Errorf("invalid openvox agent version %q", s.version)
+	}
+
+	// There's no openvox package for TurrisOS, so it isn't in the support matrix
+	if s.osRelease.ID == "turrisos" {
+		return s.turrisAgentPackage(), nil
+	}
+
+	release, err := helper.LookupSupport(s.osRelease)
+	if err != nil {
+		return nil, err
+	}
+
+	switch release.Family {
+	case helper.FamilyDebian:
+		return s.debianAgentPackage(release)
+	case helper.FamilySUSE:
+		return s.suseAgentPackage(release)
+	case helper.FamilyRedHat:
+		return s.redHatAgentPackage(release)
+	}
 
-	installCmd := []string{fmt.Sprintf("gem install puppet -v %s --no-document", constant.PuppetVersion)}
-	s.webtee.RemoteLogObmondo(installCmd, s.certName)
+	return nil, fmt.Errorf("unknown distribution family %q for %s", release.Family, release.Name)
 }
@@ -0,0 +1,254 @@
+package provisioner
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
+)
+
+// openvoxKeyFingerprint is the fingerprint of the key the openvox packages are signed with. The key is
+// downloaded from the repository server, so only the fingerprint built into the binary tells it's the real one.
+// It's set at build time with -ldflags "-X .../helper/provisioner.openvoxKeyFingerprint=...".
+var openvoxKeyFingerprint string
+
+// verifyPackage checks the downloaded package against the signed checksum manifest
+// published next to it, then checks the signature of the package itself
+func (s *Provisioner) verifyPackage(pkg *agentPackage) error {
+	fingerprint, err := pinnedFingerprint()
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(pkg.path)
+	baseURL := path.Dir(pkg.url)
+
+	keyFile := filepath.Join(dir, "GPG-KEY-openvox")
+	manifest := filepath.Join(dir, constant.OpenvoxChecksumManifest)
+	signature := filepath.Join(dir, constant.OpenvoxChecksumSignature)
+
+	downloads := []struct{ path, url string }{
+		{keyFile, constant.OpenvoxRepoKeyURL},
+		{manifest, baseURL + "/" + constant.OpenvoxChecksumManifest},
+		{signature, baseURL + "/" + constant.OpenvoxChecksumSignature},
+	}
+	for _, download := range downloads {
+		if err := s.puppet.DownloadAgent(download.path, download.url); err != nil {
+			return fmt.Errorf("failed to download %s: %w", download.url, err)
+		}
+	}
+
+	if err := verifyManifestSignature(dir, keyFile, manifest, signature, fingerprint); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(manifest)
+	if err != nil {
+		return err
+	}
+	if err := verifyChecksum(pkg.path, parseChecksums(data)); err != nil {
+		return err
+	}
+
+	if pkg.verifySignature != nil {
+		if err := pkg.verifySignature(pkg.path, keyFile); err != nil {
+			return err
+		}
+	}
+
+	slog.Info("verified the openvox agent package", slog.String("package", filepath.Base(pkg.path)))
+	return nil
+}
+
+// verifyManifestSignature checks the manifest was signed with the key with the pinned fingerprint,
+// using a keyring of its own in dir so the keys of the node aren't touched
+func verifyManifestSignature(dir, keyFile, manifest, signature, fingerprint string) error {
+	home := filepath.Join(dir, "gnupg")
+	if err := os.Mkdir(home, 0o700); err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	if err := checkSigningKey(home, keyFile, fingerprint); err != nil {
+		return err
+	}
+
+	if err := run("gpg", "--homedir", home, "--batch", "--quiet", "--import", keyFile); err != nil {
+		return fmt.Errorf("failed to import the repository signing key: %w", err)
+	}
+
+	out, err := exec.Command("gpg", "--homedir", home, "--batch", "--status-fd", "1", "--verify", signature, manifest).Output()
+	if err != nil || validSignatureFingerprint(out) != fingerprint {
+		return fmt.Errorf("the signature of %s doesn't match the repository signing key %s", constant.OpenvoxChecksumManifest, fingerprint)
+	}
+	return nil
+}
+
+// pinnedFingerprint returns the fingerprint built into the binary, a build without one can't trust any key
+func pinnedFingerprint() (string, error) {
+	fingerprint := normalizeFingerprint(openvoxKeyFingerprint)
+	if fingerprint == "" {
+		return "", errors.New("this build has no openvox signing key fingerprint to verify the repository key against")
+	}
+	return fingerprint, nil
+}
+
+// checkSigningKey fails unless keyFile holds the key with the pinned fingerprint and nothing else.
+// gpg only shows the keys, with a keyring of its own in home, nothing is imported.
+func checkSigningKey(home, keyFile, fingerprint string) error {
+	out, err := exec.Command("gpg", "--homedir", home, "--batch", "--with-colons", "--import-options", "show-only", "--import", keyFile).Output()
+	if err != nil {
+		return fmt.Errorf("failed to read the repository signing key: %w", err)
+	}
+	return matchFingerprint(keyFingerprints(out), fingerprint)
+}
+
+// matchFingerprint fails unless keys is the pinned key alone
+func matchFingerprint(keys []string, fingerprint string) error {
+	if len(keys) != 1 || keys[0] != fingerprint {
+		return fmt.Errorf("the repository signing key has fingerprint %s, want %s", strings.Join(keys, ", "), fingerprint)
+	}
+	return nil
+}
+
+// keyFingerprints returns the fingerprints of the primary keys in gpg --with-colons output
+func keyFingerprints(colons []byte) []string {
+	var fingerprints []string
+	primary := false
+	for _, line := range strings.Split(string(colons), "\n") {
+		fields := strings.Split(line, ":")
+		switch fields[0] {
+		case "pub":
+			primary = true
+		case "sub":
+			primary = false
+		case "fpr":
+			// The fingerprint is the 10th field
+			if primary && len(fields) > 9 {
+				fingerprints = append(fingerprints, normalizeFingerprint(fields[9]))
+				primary = false
+			}
+		}
+	}
+	return fingerprints
+}
+
+// validSignatureFingerprint returns the fingerprint of the primary key of a good signature in gpg --status-fd output,
+// empty when there's none
+func validSignatureFingerprint(status []byte) string {
+	for _, line := range strings.Split(string(status), "\n") {
+		// [GNUPG:] VALIDSIG <fingerprint> <date> <timestamp> <expiry> <version> <reserved> <pk algo> <hash algo> <class> <primary fingerprint>
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[0] != "[GNUPG:]" || fields[1] != "VALIDSIG" {
+			continue
+		}
+		if len(fields) >= 12 {
+			return normalizeFingerprint(fields[11])
+		}
+		return normalizeFingerprint(fields[2])
+	}
+	return ""
+}
+
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToUpper(strings.ReplaceAll(fingerprint, " ", ""))
+}
+
+// parseChecksums reads a sha256sum manifest into file name -> checksum
+func parseChecksums(data []byte) map[string]string {
+	sums := make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		// A leading * marks a file checksummed in binary mode
+		sums[strings.TrimPrefix(fields[1], "*")] = strings.ToLower(fields[0])
+	}
+
+	return sums
+}
+
+// verifyChecksum checks the SHA-256 of the file at path is the one in sums
+func verifyChecksum(path string, sums map[string]string) error {
+	name := filepath.Base(path)
+	want, ok := sums[name]
+	if !ok {
+		return fmt.Errorf("%s is not listed in %s", name, constant.OpenvoxChecksumManifest)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return fmt.Errorf("failed to read %s: %w", name, err)
+	}
+
+	if got := hex.EncodeToString(hash.Sum(nil)); got != want {
+		return fmt.Errorf("checksum mismatch for %s: got sha256 %s, want %s", name, got, want)
+	}
+	return nil
+}
+
+// verifyDebSignature checks the signature of a .deb with dpkg-sig or debsig-verify. When neither is
+// installed the checksum manifest is all there is to go by, verifyPackage only gets here once the
+// manifest signature checked out against the pinned key and the package against the manifest
+func verifyDebSignature(path, keyFile string) error {
+	if _, err := exec.LookPath("dpkg-sig"); err == nil {
+		cmd := exec.Command("dpkg-sig", "--verify", path)
+		cmd.Env = append(os.Environ(), "GNUPGHOME="+filepath.Join(filepath.Dir(keyFile), "gnupg"))
+		if out, err := cmd.CombinedOutput(); err != nil || !strings.Contains(string(out), "GOODSIG") {
+			return fmt.Errorf("the signature of %s is not valid: %s", filepath.Base(path), strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+
+	if _, err := exec.LookPath("debsig-verify"); err == nil {
+		if err := run("debsig-verify", path); err != nil {
+			return fmt.Errorf("the signature of %s is not valid: %w", filepath.Base(path), err)
+		}
+		return nil
+	}
+
+	slog.Warn("neither dpkg-sig nor debsig-verify is installed, relying on the checksum manifest signed with the pinned key", slog.String("package", filepath.Base(path)))
+	return nil
+}
+
+// verifyRPMSignature imports the repository key into the rpm database and checks the package was signed with it
+func verifyRPMSignature(path, keyFile string) error {
+	if err := run("rpm", "--import", keyFile); err != nil {
+		return fmt.Errorf("failed to import the repository signing key: %w", err)
+	}
+
+	out, err := exec.Command("rpm", "-K", path).CombinedOutput()
+	result := strings.TrimSpace(string(out))
+	// An unsigned package only gets its digests checked, which rpm reports as OK as well
+	signed := strings.Contains(result, "signatures OK") || strings.Contains(result, "pgp") || strings.Contains(result, "gpg")
+	if err != nil || strings.Contains(result, "NOT OK") || !signed {
+		return fmt.Errorf("the signature of %s is not valid: %s", filepath.Base(path), result)
+	}
+	return nil
+}
+
+func run(name string, args ...string) error {
+	if out, err := exec.Command(name, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %w: %s", name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package provisioner
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyChecksum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "openvox-agent_8.23.1-1+ubuntu24.04_amd64.deb")
+	if err := os.WriteFile(path, []byte("openvox"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	sums := parseChecksums([]byte(`0b3c1d7e0a1b0e8e6a7fd3e0e8c61d0f9a0c1b1cbd6d0e3a4b0c2e7f6a1d2c3e  openvox-agent_8.23.1-1+ubuntu22.04_amd64.deb
+A901AF3422BC99D2037AEE2555DB820BD04983BD56D653DDA00A77D4613EA9C8 *openvox-agent_8.23.1-1+ubuntu24.04_amd64.deb
+`))
+	if len(sums) != 2 {
+		t.Fatalf("parsed %d checksums, want 2", len(sums))
+	}
+
+	if err := verifyChecksum(path, sums); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("tampered"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyChecksum(path, sums); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("tampered package: got error %v, want a checksum mismatch", err)
+	}
+
+	if err := verifyChecksum(path, map[string]string{}); err == nil || !strings.Contains(err.Error(), "not listed") {
+		t.Errorf("unlisted package: got error %v", err)
+	}
+}
+
+func TestSigningKeyFingerprints(t *testing.T) {
+	colons := []byte(`pub:-:4096:1:4528B6CD9E61EF26:1554759562:1744129562::-:::scESC::::::23::0:
+fpr:::::::::D6811ED3ADEEB8441AF5AA8F4528B6CD9E61EF26:
+uid:-::::1554759562::E2E8F5A2A5D0B6A0D4B1C0B0E5D4F6A7B8C9D0E1::Puppet, Inc. Release Key (Puppet, Inc. Release Key) <release@puppet.com>::::::::::0:
+sub:-:4096:1:7F438280EF8D349F:1554759562:1744129562:::::e::::::23:
+fpr:::::::::12D7C1E4B0E1E2B0C2E3D4F5A6B7C8D9E0F1A2B3:
+`)
+	keys := keyFingerprints(colons)
+	if len(keys) != 1 || keys[0] != "D6811ED3ADEEB8441AF5AA8F4528B6CD9E61EF26" {
+		t.Errorf("got %v, want only the primary key", keys)
+	}
+
+	status := []byte(`[GNUPG:] NEWSIG
+[GNUPG:] GOODSIG 7F438280EF8D349F Puppet, Inc. Release Key <release@puppet.com>
+[GNUPG:] VALIDSIG 12D7C1E4B0E1E2B0C2E3D4F5A6B7C8D9E0F1A2B3 2025-03-01 1740787200 0 4 0 1 10 00 D6811ED3ADEEB8441AF5AA8F4528B6CD9E61EF26
+`)
+	if got := validSignatureFingerprint(status); got != "D6811ED3ADEEB8441AF5AA8F4528B6CD9E61EF26" {
+		t.Errorf("got %q, want the primary key of the signing subkey", got)
+	}
+	if got := validSignatureFingerprint([]byte("[GNUPG:] BADSIG 7F438280EF8D349F Puppet\n")); got != "" {
+		t.Errorf("bad signature: got %q, want none", got)
+	}
+}
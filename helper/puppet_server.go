@@ -0,0 +1,33 @@
+package helper
+
+import (
+	"log/slog"
+
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/config"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
+)
+
+// GetPuppetServer returns the openvox server of the node: the one given with --puppet-server or
+// PUPPET_SERVER, else the server in puppet.conf, else the server of the customer in the certname
+func GetPuppetServer() string {
+	return resolvePuppetServer(config.GetPupeptServer(), constant.PuppetConfig, GetCertname())
+}
+
+func resolvePuppetServer(explicit, puppetConf, certname string) string {
+	if explicit != "" {
+		return explicit
+	}
+
+	server, err := readPuppetConfSetting(puppetConf, "server")
+	if err != nil {
+		slog.Debug("unable to read the server from puppet.conf", slog.String("path", puppetConf), slog.Any("error", err))
+	}
+	if server != "" {
+		return server
+	}
+
+	if customerID := GetCustomerID(certname); customerID != "" {
+		return customerID + constant.DefaultPuppetServerDomainSuffix
+	}
+	return constant.DefaultPuppetServerCustomerID + constant.DefaultPuppetServerDomainSuffix
+}
@@ -0,0 +1,19 @@
+package helper
+
+import (
+	"hash/fnv"
+	"time"
+)
+
+// Splay returns a delay between 0 and limit derived from the certname, so every node
+// keeps the same offset between runs while the fleet is spread over the whole limit
+func Splay(certname string, limit time.Duration) time.Duration {
+	if limit <= 0 {
+		return 0
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(certname)) //nolint:errcheck
+
+	return time.Duration(h.Sum64() % uint64(limit))
+}
@@ -0,0 +1,38 @@
+package helper
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSplay(t *testing.T) {
+	if got := Splay("web01.example", 0); got != 0 {
+		t.Errorf("Splay without a limit = %s, want 0", got)
+	}
+	if got := Splay("web01.example", -time.Minute); got != 0 {
+		t.Errorf("Splay with a negative limit = %s, want 0", got)
+	}
+
+	limit := 30 * time.Minute
+	if first, second := Splay("web01.example", limit), Splay("web01.example", limit); first != second {
+		t.Errorf("Splay isn't stable for a certname: %s and %s", first, second)
+	}
+
+	// Within the limit and spread over it, not all in one corner
+	var firstHalf, secondHalf int
+	for i := range 1000 {
+		splay := Splay(fmt.Sprintf("web%02d.example", i), limit)
+		if splay < 0 || splay >= limit {
+			t.Fatalf("Splay = %s, want within [0, %s)", splay, limit)
+		}
+		if splay < limit/2 {
+			firstHalf++
+		} else {
+			secondHalf++
+		}
+	}
+	if firstHalf < 400 || secondHalf < 400 {
+		t.Errorf("Splay isn't spread over the limit: %d in the first half, %d in the second", firstHalf, secondHalf)
+	}
+}
@@ -0,0 +1,159 @@
+package helper
+
+import (
+	_ "embed"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed support.yaml
+var supportMatrixYAML []byte
+
+// Distribution is a distribution in the support matrix, see support.yaml
+type Distribution struct {
+	ID             string    `yaml:"id"`
+	Name           string    `yaml:"name"`
+	Aliases        []string  `yaml:"aliases"`
+	Format         string    `yaml:"format"`
+	Family         string    `yaml:"family"`
+	Repo           string    `yaml:"repo"`
+	Packages       string    `yaml:"packages"`
+	PackageRelease string    `yaml:"package_release"`
+	Architectures  []string  `yaml:"architectures"`
+	Releases       []Release `yaml:"releases"`
+}
+
+type Release struct {
+	Version  string    `yaml:"version"`
+	Codename string    `yaml:"codename"`
+	EOL      time.Time `yaml:"eol"`
+}
+
+// SupportedRelease is the distribution release the node runs
+type SupportedRelease struct {
+	Distribution
+	Release
+}
+
+// SupportMatrix returns the distributions linuxaid supports
+func SupportMatrix() []Distribution {
+	var matrix []Distribution
+	if err := yaml.Unmarshal(supportMatrixYAML, &matrix); err != nil {
+		// The matrix is embedded, a broken one doesn't get past the tests
+		panic(fmt.Sprintf("invalid support matrix: %v", err))
+	}
+	return matrix
+}
+
+// LookupSupport finds the release of the distribution in the support matrix, a distribution that isn't
+// in it by ID is looked up by ID_LIKE and PLATFORM_ID. The error says what is supported instead.
+func LookupSupport(osRelease OSRelease) (*SupportedRelease, error) {
+	matrix := SupportMatrix()
+
+	distribution, derivative := findDistribution(matrix, osRelease)
+	if distribution == nil {
+		ids := make([]string, 0, len(matrix))
+		for _, distribution := range matrix {
+			ids = append(ids, distribution.ID)
+		}
+		slices.Sort(ids)
+		return nil, fmt.Errorf("distribution %q is not supported, supported distributions are %s", osRelease.ID, strings.Join(ids, ", "))
+	}
+
+	versionID, codenames := osRelease.VersionID, []string{osRelease.VersionCodename}
+	if derivative {
+		codenames = append(codenames, osRelease.UbuntuCodename)
+		// The EL release of a rebuild is in its PLATFORM_ID, its VERSION_ID is its own
+		if version, ok := strings.CutPrefix(osRelease.PlatformID, "platform:el"); ok {
+			versionID = version
+		}
+	}
+
+	for _, release := range distribution.Releases {
+		if slices.ContainsFunc(codenames, func(codename string) bool { return release.matches(versionID, codename) }) {
+			return &SupportedRelease{Distribution: *distribution, Release: release}, nil
+		}
+	}
+
+	releases := make([]string, 0, len(distribution.Releases))
+	for _, release := range distribution.Releases {
+		releases = append(releases, release.String())
+	}
+	name := distribution.Name
+	if derivative {
+		name = fmt.Sprintf("%s (based on %s)", osRelease.ID, distribution.Name)
+	}
+	current := Release{Version: osRelease.VersionID, Codename: osRelease.VersionCodename}
+	return nil, fmt.Errorf("%s %s is not supported, supported %s releases are %s",
+		name, current.String(), distribution.Name, strings.Join(releases, ", "))
+}
+
+// findDistribution returns the distribution of osRelease in the matrix, and whether it's a
+// derivative of it, matched by ID_LIKE or PLATFORM_ID
+func findDistribution(matrix []Distribution, osRelease OSRelease) (*Distribution, bool) {
+	for i, id := range osRelease.ids() {
+		for j, distribution := range matrix {
+			if distribution.ID == id || slices.Contains(distribution.Aliases, id) {
+				return &matrix[j], i > 0
+			}
+		}
+	}
+
+	if strings.HasPrefix(osRelease.PlatformID, "platform:el") {
+		for j, distribution := range matrix {
+			if distribution.ID == "rhel" {
+				return &matrix[j], true
+			}
+		}
+	}
+	return nil, false
+}
+
+func (r Release) matches(versionID, codename string) bool {
+	if versionID != "" && (versionID == r.Version || strings.HasPrefix(versionID, r.Version+".")) {
+		return true
+	}
+	return codename != "" && codename == r.Codename
+}
+
+func (r Release) String() string {
+	if r.Codename == "" {
+		return r.Version
+	}
+	if r.Version == "" {
+		return r.Codename
+	}
+	return fmt.Sprintf("%s (%s)", r.Version, r.Codename)
+}
+
+// EndOfLife tells if the release no longer gets security updates
+func (r Release) EndOfLife() bool {
+	return !r.EOL.IsZero() && time.Now().After(r.EOL)
+}
+
+// CheckArchitecture fails when no openvox agent packages are published for arch, by its Go name
+func (s *SupportedRelease) CheckArchitecture(arch string) error {
+	if !slices.Contains(s.Architectures, arch) {
+		return fmt.Errorf("openvox agent packages for %s %s are published for %s, not %s",
+			s.Name, s.Release.String(), strings.Join(s.Architectures, ", "), arch)
+	}
+	return nil
+}
+
+// Expand fills in a repo, packages or package_release template, arch as the package format calls it
+func (s *SupportedRelease) Expand(template, arch string) string {
+	major, _, _ := strings.Cut(s.Version, ".")
+	return strings.NewReplacer(
+		"{version}", s.Version,
+		"{major}", major,
+		"{codename}", s.Codename,
+		"{arch}", arch,
+		"{openvox}", constant.PuppetMajorVersion,
+	).Replace(template)
+}
@@ -0,0 +1,112 @@
+package helper
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSupportMatrix(t *testing.T) {
+	for _, distribution := range SupportMatrix() {
+		if distribution.Name == "" || distribution.Repo == "" || distribution.Packages == "" || distribution.PackageRelease == "" ||
+			len(distribution.Architectures) == 0 || len(distribution.Releases) == 0 {
+			t.Errorf("%s: incomplete support matrix entry", distribution.ID)
+		}
+		switch distribution.Format + "/" + distribution.Family {
+		case "deb/" + FamilyDebian, "rpm/" + FamilyRedHat, "rpm/" + FamilySUSE:
+		default:
+			t.Errorf("%s: unknown format %q with family %q", distribution.ID, distribution.Format, distribution.Family)
+		}
+		for _, release := range distribution.Releases {
+			if release.Version == "" || release.EOL.IsZero() {
+				t.Errorf("%s: release %v needs a version and an EOL date", distribution.ID, release)
+			}
+		}
+	}
+}
+
+func TestLookupSupport(t *testing.T) {
+	tests := []struct {
+		name      string
+		osRelease OSRelease
+		want      string
+		err       string
+	}{
+		{name: "ubuntu", osRelease: OSRelease{ID: "ubuntu", VersionID: "24.04", VersionCodename: "noble"}, want: "Ubuntu 24.04"},
+		{name: "almalinux", osRelease: OSRelease{ID: "almalinux", VersionID: "9.5"}, want: "AlmaLinux 9"},
+		{name: "oracle", osRelease: OSRelease{ID: "ol", VersionID: "8.10"}, want: "Oracle Linux 8"},
+		{name: "debian testing", osRelease: OSRelease{ID: "debian", VersionCodename: "trixie"}, want: "Debian 13"},
+		{name: "leap", osRelease: OSRelease{ID: "opensuse-leap", VersionID: "15.6"}, want: "openSUSE Leap 15"},
+		{name: "sles for sap", osRelease: OSRelease{ID: "sles_sap", IDLike: []string{"suse"}, VersionID: "15.5"}, want: "SUSE Linux Enterprise Server 15"},
+		{
+			name:      "linux mint",
+			osRelease: OSRelease{ID: "linuxmint", IDLike: []string{"ubuntu", "debian"}, VersionID: "21.3", VersionCodename: "virginia", UbuntuCodename: "jammy"},
+			want:      "Ubuntu 22.04",
+		},
+		{
+			name:      "el rebuild",
+			osRelease: OSRelease{ID: "eurolinux", IDLike: []string{"fedora"}, VersionID: "9.2", PlatformID: "platform:el9"},
+			want:      "Red Hat Enterprise Linux 9",
+		},
+		{name: "ubuntu 26.04", osRelease: OSRelease{ID: "ubuntu", VersionID: "26.04", VersionCodename: "resolute"}, want: "Ubuntu 26.04"},
+		{name: "centos 7", osRelease: OSRelease{ID: "centos", IDLike: []string{"rhel", "fedora"}, VersionID: "7"}, want: "CentOS 7"},
+		{
+			name:      "debian 9",
+			osRelease: OSRelease{ID: "debian", VersionID: "9", VersionCodename: "stretch"},
+			err:       "Debian 9 (stretch) is not supported, supported Debian releases are 10 (buster), 11 (bullseye), 12 (bookworm), 13 (trixie)",
+		},
+		{name: "ubuntu 16.04", osRelease: OSRelease{ID: "ubuntu", VersionID: "16.04", VersionCodename: "xenial"}, err: "Ubuntu 16.04 (xenial) is not supported"},
+		{name: "kali", osRelease: OSRelease{ID: "kali", IDLike: []string{"debian"}, VersionID: "2024.4"}, err: "kali (based on Debian) 2024.4 is not supported"},
+		{name: "arch", osRelease: OSRelease{ID: "arch"}, err: `distribution "arch" is not supported`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release, err := LookupSupport(tt.osRelease)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := release.Name + " " + release.Version; got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFamily(t *testing.T) {
+	for osRelease, want := range map[*OSRelease]string{
+		{ID: "linuxmint", IDLike: []string{"ubuntu", "debian"}}:     FamilyDebian,
+		{ID: "rocky", IDLike: []string{"rhel", "centos", "fedora"}}: FamilyRedHat,
+		{ID: "eurolinux", PlatformID: "platform:el9"}:               FamilyRedHat,
+		{ID: "sles_sap", IDLike: []string{"suse"}}:                  FamilySUSE,
+		{ID: "arch"}: "",
+	} {
+		if got := osRelease.Family(); got != want {
+			t.Errorf("%s: got family %q, want %q", osRelease.ID, got, want)
+		}
+	}
+}
+
+func TestIsUpdatableOS(t *testing.T) {
+	// Unlisted releases of a known family are updated, install refuses them
+	unlisted := OSRelease{ID: "ubuntu", IDLike: []string{"debian"}, VersionID: "16.04", VersionCodename: "xenial"}
+	if _, err := IsSupportedOS(unlisted); err == nil {
+		t.Errorf("IsSupportedOS(%s) succeeded, want an error", unlisted)
+	}
+	commands, err := IsUpdatableOS(unlisted)
+	if err != nil {
+		t.Fatalf("IsUpdatableOS(%s): %v", unlisted, err)
+	}
+	if commands.updateRepoListCmd != constDistributionDebianUpdateRepoListCmd {
+		t.Errorf("got update command %q, want the apt one", commands.updateRepoListCmd)
+	}
+
+	if _, err := IsUpdatableOS(OSRelease{ID: "arch"}); err == nil {
+		t.Error("IsUpdatableOS(arch) succeeded, want an error for an unknown family")
+	}
+}
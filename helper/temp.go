@@ -1,16 +1,16 @@
 package helper
 
 import (
-	"log/slog"
+	"fmt"
 	"os"
 )
 
-func TempDir() string {
-	dir, err := os.MkdirTemp("", "")
+// TempDir creates a private temporary directory, readable by the owner only, named after prefix
+func TempDir(prefix string) (string, error) {
+	dir, err := os.MkdirTemp("", prefix)
 	if err != nil {
-		slog.Error(err.Error())
-		os.Exit(1)
+		return "", fmt.Errorf("failed to create a temporary directory: %w", err)
 	}
 
-	return dir
+	return dir, nil
 }
@@ -0,0 +1,9 @@
+package helper
+
+import "os"
+
+// IsTerminal tells if file is a terminal, stdin isn't one under cloud-init, Ansible or a pipe
+func IsTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
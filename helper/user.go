@@ -1,6 +1,7 @@
 package helper
 
 import (
+	"fmt"
 	"log/slog"
 	"os"
 	"os/user"
@@ -9,14 +10,20 @@ import (
 // Check if the current user is root or not
 // fail if user is not root
 func RequireRootUser() {
+	if err := CheckRootUser(); err != nil {
+		slog.Error("exiting, script needs to be run as root user", slog.Any("error", err))
+		os.Exit(1)
+	}
+}
+
+// CheckRootUser is RequireRootUser for callers that must not exit, like the daemon
+func CheckRootUser() error {
 	user, err := user.Current()
 	if err != nil {
-		slog.Error(err.Error())
-		os.Exit(1)
+		return err
 	}
-	if user.Username == "root" {
-		return
+	if user.Username != "root" {
+		return fmt.Errorf("running as %s, not root", user.Username)
 	}
-	slog.Error("exiting, script needs to be run as root user,", slog.String("current_user", user.Username))
-	os.Exit(1)
+	return nil
 }
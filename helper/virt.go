@@ -0,0 +1,116 @@
+package helper
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Environment types
+const (
+	EnvironmentBareMetal = "bare-metal"
+	EnvironmentVM        = "vm"
+	EnvironmentContainer = "container"
+	EnvironmentUnknown   = "unknown"
+)
+
+// cgroup paths container runtimes put the processes of a container in
+var containerCgroups = map[string]string{
+	"/docker/":     "docker",
+	"/lxc/":        "lxc",
+	"/lxc.payload": "lxc",
+	"/kubepods":    "kubernetes",
+	"/libpod-":     "podman",
+}
+
+// Environment is what linuxaid runs on, e.g. a container with technology lxc or a vm with technology kvm
+type Environment struct {
+	Type       string
+	Technology string
+}
+
+// IsContainer tells if linuxaid runs in a container, where the host owns the kernel and reboots
+func (e Environment) IsContainer() bool {
+	return e.Type == EnvironmentContainer
+}
+
+// LogValue logs the environment as a group of its type and technology
+func (e Environment) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("type", e.Type), slog.String("technology", e.Technology))
+}
+
+// DetectEnvironment detects whether linuxaid runs in a container, a vm or on bare metal
+func DetectEnvironment() Environment {
+	return environmentProbe{root: "/", detectVirt: systemdDetectVirt}.detect()
+}
+
+type environmentProbe struct {
+	// root is where /proc and the container marker files are looked for
+	root string
+	// detectVirt runs systemd-detect-virt with flag, ok is false when it can't tell
+	detectVirt func(flag string) (technology string, ok bool)
+}
+
+func (p environmentProbe) detect() Environment {
+	if technology, ok := p.detectVirt("--container"); ok && technology != "none" {
+		return Environment{Type: EnvironmentContainer, Technology: technology}
+	}
+	if technology := p.containerTechnology(); technology != "" {
+		return Environment{Type: EnvironmentContainer, Technology: technology}
+	}
+
+	technology, ok := p.detectVirt("--vm")
+	switch {
+	case !ok:
+		return Environment{Type: EnvironmentUnknown}
+	case technology == "none":
+		return Environment{Type: EnvironmentBareMetal}
+	}
+	return Environment{Type: EnvironmentVM, Technology: technology}
+}
+
+// containerTechnology looks for the traces container runtimes leave, for when systemd-detect-virt
+// isn't installed, which is common in containers
+func (p environmentProbe) containerTechnology() string {
+	// systemd and most runtimes set container= in the environment of the init process
+	if environ, err := os.ReadFile(filepath.Join(p.root, "proc/1/environ")); err == nil {
+		for _, variable := range bytes.Split(environ, []byte{0}) {
+			if technology, ok := strings.CutPrefix(string(variable), "container="); ok && technology != "" {
+				return technology
+			}
+		}
+	}
+
+	markers := map[string]string{".dockerenv": "docker", "run/.containerenv": "podman"}
+	for marker, technology := range markers {
+		if _, err := os.Stat(filepath.Join(p.root, marker)); err == nil {
+			return technology
+		}
+	}
+
+	if cgroup, err := os.ReadFile(filepath.Join(p.root, "proc/1/cgroup")); err == nil {
+		for path, technology := range containerCgroups {
+			if bytes.Contains(cgroup, []byte(path)) {
+				return technology
+			}
+		}
+	}
+
+	return ""
+}
+
+// systemdDetectVirt runs systemd-detect-virt, which prints none and exits 1 when there's nothing detected
+func systemdDetectVirt(flag string) (string, bool) {
+	out, err := exec.Command("systemd-detect-virt", flag).Output()
+	technology := strings.TrimSpace(string(out))
+
+	var exitErr *exec.ExitError
+	if err != nil && !(errors.As(err, &exitErr) && technology == "none") {
+		return "", false
+	}
+	return technology, true
+}
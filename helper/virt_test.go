@@ -0,0 +1,87 @@
+package helper
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectEnvironment(t *testing.T) {
+	noDetectVirt := func(string) (string, bool) { return "", false }
+
+	tests := []struct {
+		name       string
+		files      map[string]string
+		detectVirt func(flag string) (string, bool)
+		want       Environment
+	}{
+		{
+			name: "systemd-detect-virt container",
+			detectVirt: func(flag string) (string, bool) {
+				if flag == "--container" {
+					return "lxc", true
+				}
+				return "kvm", true
+			},
+			want: Environment{Type: EnvironmentContainer, Technology: "lxc"},
+		},
+		{
+			name:       "init environment",
+			files:      map[string]string{"proc/1/environ": "PATH=/usr/bin\x00container=lxc-libvirt\x00"},
+			detectVirt: noDetectVirt,
+			want:       Environment{Type: EnvironmentContainer, Technology: "lxc-libvirt"},
+		},
+		{
+			name:       "dockerenv",
+			files:      map[string]string{".dockerenv": ""},
+			detectVirt: noDetectVirt,
+			want:       Environment{Type: EnvironmentContainer, Technology: "docker"},
+		},
+		{
+			name:       "kubernetes cgroup",
+			files:      map[string]string{"proc/1/cgroup": "0::/kubepods/besteffort/pod1234/abcd\n"},
+			detectVirt: noDetectVirt,
+			want:       Environment{Type: EnvironmentContainer, Technology: "kubernetes"},
+		},
+		{
+			name: "vm",
+			detectVirt: func(flag string) (string, bool) {
+				if flag == "--container" {
+					return "none", true
+				}
+				return "kvm", true
+			},
+			want: Environment{Type: EnvironmentVM, Technology: "kvm"},
+		},
+		{
+			name:       "bare metal",
+			detectVirt: func(string) (string, bool) { return "none", true },
+			want:       Environment{Type: EnvironmentBareMetal},
+		},
+		{
+			name:       "unknown",
+			files:      map[string]string{"proc/1/cgroup": "0::/init.scope\n"},
+			detectVirt: noDetectVirt,
+			want:       Environment{Type: EnvironmentUnknown},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			for name, content := range tt.files {
+				path := filepath.Join(root, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if got := (environmentProbe{root: root, detectVirt: tt.detectVirt}).detect(); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -28,7 +28,17 @@ func (*MockObmondoClient) ServerPing() error {
 }
 
 // UpdatePuppetLastRunReport implements api.ObmondoClient.
-func (*MockObmondoClient) UpdatePuppetLastRunReport() error {
+func (*MockObmondoClient) UpdatePuppetLastRunReport(*api.PuppetLastRunReport) error {
+	return nil
+}
+
+// GetPuppetRunMode implements api.ObmondoClient.
+func (*MockObmondoClient) GetPuppetRunMode() (string, error) {
+	return "noop", nil
+}
+
+// NotifyDecommission implements api.ObmondoClient.
+func (*MockObmondoClient) NotifyDecommission() error {
 	return nil
 }
 
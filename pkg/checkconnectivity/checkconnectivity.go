@@ -17,7 +17,7 @@ import (
 const (
 	port        = "443"
 	timeout     = time.Second * 5
-	metricsFile = "/var/lib/node_exporter/obmondo_domains_reachable.prom"
+	metricsFile = constant.NodeExporterTextfileDir + "/obmondo_domains_reachable.prom"
 )
 
 var enableitHosts = []string{
@@ -28,7 +28,8 @@ var enableitHosts = []string{
 var runPuppetMetric *prometheus.GaugeVec
 var registry *prometheus.Registry
 
-func getHostList() ([]string, error) {
+// HostList returns the Obmondo hosts the node has to reach, including its own puppet server
+func HostList() ([]string, error) {
 	customerID := helper.GetCustomerID(helper.GetCertname())
 	if len(customerID) == 0 {
 		return nil, errors.New("customerID not found")
@@ -51,7 +52,16 @@ func init() {
 	registry.MustRegister(runPuppetMetric)
 }
 
-func CheckTCPConnection() bool {
+// HostStatus is the result of a TCP check against one of the Obmondo hosts
+type HostStatus struct {
+	Host      string `json:"host"`
+	Port      string `json:"port"`
+	Reachable bool   `json:"reachable"`
+	Error     string `json:"error,omitempty"`
+}
+
+// CheckHosts does a TCP handshake against every host the node needs to reach
+func CheckHosts() ([]HostStatus, error) {
 	// Initializing the checker
 	// It is expected to be shared among goroutines, only one instance is necessary.
 	c := tcp.NewChecker()
@@ -66,8 +76,26 @@ func CheckTCPConnection() bool {
 
 	<-c.WaitReady()
 
-	hosts, err := getHostList()
+	hosts, err := HostList()
+	if err != nil {
+		return nil, err
+	}
 
+	statuses := make([]HostStatus, 0, len(hosts))
+	for _, host := range hosts {
+		status := HostStatus{Host: host, Port: port, Reachable: true}
+		if err := c.CheckAddr(fmt.Sprintf("%s:%s", host, port), timeout); err != nil {
+			status.Reachable = false
+			status.Error = err.Error()
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+func CheckTCPConnection() bool {
+	statuses, err := CheckHosts()
 	if err != nil {
 		slog.Info("Error resolving ip ", slog.String("error", err.Error()))
 		return false
@@ -75,14 +103,13 @@ func CheckTCPConnection() bool {
 
 	allAPIReachable := true
 
-	for _, host := range hosts {
-		err := c.CheckAddr(fmt.Sprintf("%s:%s", host, port), timeout)
-		if err != nil {
+	for _, status := range statuses {
+		if !status.Reachable {
 			allAPIReachable = false
-			runPuppetMetric.WithLabelValues(host, port).Set(1)
+			runPuppetMetric.WithLabelValues(status.Host, status.Port).Set(1)
 			continue
 		}
-		runPuppetMetric.WithLabelValues(host, port).Set(0)
+		runPuppetMetric.WithLabelValues(status.Host, status.Port).Set(0)
 	}
 
 	if err := prometheus.WriteToTextfile(metricsFile, registry); err != nil {
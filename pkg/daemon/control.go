@@ -0,0 +1,151 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const controlTimeout = 5 * time.Second
+
+type Status struct {
+	StartedAt time.Time   `json:"started_at"`
+	Paused    bool        `json:"paused"`
+	Jobs      []JobStatus `json:"jobs"`
+}
+
+type JobStatus struct {
+	Name      string    `json:"name"`
+	Interval  string    `json:"interval"`
+	Running   bool      `json:"running"`
+	LastRun   time.Time `json:"last_run,omitzero"`
+	LastError string    `json:"last_error,omitempty"`
+	NextRun   time.Time `json:"next_run"`
+}
+
+// controlHandler serves the control API on the unix socket
+//
+//	GET  /status          daemon and job state
+//	POST /jobs/{name}/run queue a job right away
+//	POST /pause           stop running pausable jobs
+//	POST /resume          undo pause
+func (d *Daemon) controlHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(d.Status()); err != nil {
+			slog.Error("failed to encode daemon status", slog.Any("error", err))
+		}
+	})
+
+	mux.HandleFunc("POST /jobs/{name}/run", func(w http.ResponseWriter, r *http.Request) {
+		err := d.Trigger(r.PathValue("name"))
+		switch {
+		case err == nil:
+			w.WriteHeader(http.StatusAccepted)
+		case errors.Is(err, ErrUnknownJob):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, err.Error(), http.StatusConflict)
+		}
+	})
+
+	mux.HandleFunc("POST /pause", func(w http.ResponseWriter, _ *http.Request) {
+		d.SetPaused(true)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("POST /resume", func(w http.ResponseWriter, _ *http.Request) {
+		d.SetPaused(false)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return mux
+}
+
+// Client talks to a running daemon over its control socket
+type Client struct {
+	httpClient *http.Client
+}
+
+// NewClient returns a client for the daemon listening on socketPath
+func NewClient(socketPath string) *Client {
+	return &Client{
+		httpClient: &http.Client{
+			Timeout: controlTimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+	}
+}
+
+// Status fetches the daemon state
+func (c *Client) Status() (*Status, error) {
+	resp, err := c.do(http.MethodGet, "/status")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	status := &Status{}
+	if err := json.NewDecoder(resp.Body).Decode(status); err != nil {
+		return nil, fmt.Errorf("failed to decode daemon status: %w", err)
+	}
+	return status, nil
+}
+
+// Trigger asks the daemon to run a job right away
+func (c *Client) Trigger(name string) error {
+	return c.post("/jobs/" + name + "/run")
+}
+
+// Pause stops the daemon from running pausable jobs until Resume is called
+func (c *Client) Pause() error {
+	return c.post("/pause")
+}
+
+// Resume undoes Pause
+func (c *Client) Resume() error {
+	return c.post("/resume")
+}
+
+func (c *Client) post(path string) error {
+	resp, err := c.do(http.MethodPost, path)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (c *Client) do(method, path string) (*http.Response, error) {
+	// The host is ignored, we always dial the unix socket
+	request, err := http.NewRequest(method, "http://linuxaid"+path, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach the linuxaid daemon: %w", err)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("daemon returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,339 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper/sdnotify"
+)
+
+var (
+	ErrUnknownJob = errors.New("unknown job")
+	ErrPaused     = errors.New("daemon is paused")
+	ErrQueued     = errors.New("job is already queued")
+)
+
+// Job is a task the daemon runs on a fixed interval, or on demand through the control socket
+type Job struct {
+	Name     string
+	Interval time.Duration
+	// InitialDelay is the wait before the first run, used to splay the fleet
+	InitialDelay time.Duration
+	// Pausable jobs don't run while the daemon is paused, not even when triggered
+	Pausable bool
+	Run      func() error
+
+	running   bool
+	lastRun   time.Time
+	lastError string
+	nextRun   time.Time
+}
+
+type Config struct {
+	SocketPath string
+	// ShutdownGrace is how long a running job gets to finish once we're asked to stop
+	ShutdownGrace time.Duration
+	// OnShutdown is called after the jobs have stopped, right before Run returns
+	OnShutdown func()
+}
+
+type Daemon struct {
+	config    Config
+	jobs      []*Job
+	trigger   chan *Job
+	startedAt time.Time
+
+	mu     sync.Mutex
+	paused bool
+}
+
+// New creates a daemon for the given jobs
+func New(config Config, jobs ...*Job) *Daemon {
+	return &Daemon{
+		config:  config,
+		jobs:    jobs,
+		trigger: make(chan *Job, len(jobs)),
+	}
+}
+
+// Run serves the control socket and runs the jobs until ctx is cancelled
+func (d *Daemon) Run(ctx context.Context) error {
+	d.mu.Lock()
+	d.startedAt = time.Now()
+	for _, job := range d.jobs {
+		job.nextRun = d.startedAt.Add(job.InitialDelay)
+	}
+	d.mu.Unlock()
+
+	listener, err := listen(d.config.SocketPath)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Handler:           d.controlHandler(),
+		ReadHeaderTimeout: controlTimeout,
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("control socket stopped", slog.Any("error", err))
+		}
+	}()
+
+	loopDone := make(chan struct{})
+	go func() {
+		defer close(loopDone)
+		d.loop(ctx)
+	}()
+
+	if err := sdnotify.Notify(sdnotify.Ready); err != nil {
+		slog.Warn("failed to notify systemd", slog.Any("error", err))
+	}
+	slog.Info("linuxaid daemon started", slog.String("socket", d.config.SocketPath))
+
+	<-ctx.Done()
+	slog.Info("stopping linuxaid daemon")
+	sdnotify.Notify(sdnotify.Stopping) //nolint:errcheck
+
+	select {
+	case <-loopDone:
+	case <-time.After(d.config.ShutdownGrace):
+		slog.Warn("job is still running, stopping anyway", slog.Duration("grace", d.config.ShutdownGrace))
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), controlTimeout)
+	defer cancel()
+	server.Shutdown(shutdownCtx)   //nolint:errcheck
+	os.Remove(d.config.SocketPath) //nolint:errcheck
+
+	if d.config.OnShutdown != nil {
+		d.config.OnShutdown()
+	}
+
+	return nil
+}
+
+// loop runs one job at a time, jobs like the puppet run and system update must never overlap.
+// It also pings the systemd watchdog, so a stuck loop gets the daemon restarted.
+func (d *Daemon) loop(ctx context.Context) {
+	timer := time.NewTimer(d.untilNextRun())
+	defer timer.Stop()
+
+	heartbeat := newHeartbeat()
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			pingWatchdog()
+			continue
+		case job := <-d.trigger:
+			d.runWatched(job, heartbeat.C)
+		case <-timer.C:
+			for _, job := range d.dueJobs() {
+				if ctx.Err() != nil {
+					return
+				}
+				d.runWatched(job, heartbeat.C)
+			}
+		}
+
+		timer.Reset(d.untilNextRun())
+	}
+}
+
+// runWatched runs the job while the loop keeps pinging the watchdog, a puppet run can take longer than the watchdog timeout
+func (d *Daemon) runWatched(job *Job, heartbeat <-chan time.Time) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.runJob(job)
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-heartbeat:
+			pingWatchdog()
+		}
+	}
+}
+
+func (d *Daemon) dueJobs() []*Job {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	var due []*Job
+	for _, job := range d.jobs {
+		if !job.nextRun.After(now) {
+			due = append(due, job)
+		}
+	}
+	return due
+}
+
+func (d *Daemon) untilNextRun() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var next time.Time
+	for _, job := range d.jobs {
+		if next.IsZero() || job.nextRun.Before(next) {
+			next = job.nextRun
+		}
+	}
+	return max(time.Until(next), 0)
+}
+
+func (d *Daemon) runJob(job *Job) {
+	d.mu.Lock()
+	if job.Pausable && d.paused {
+		slog.Debug("daemon is paused, skipping job", slog.String("job", job.Name))
+		job.nextRun = time.Now().Add(job.Interval)
+		d.mu.Unlock()
+		return
+	}
+	job.running = true
+	d.mu.Unlock()
+
+	slog.Debug("running job", slog.String("job", job.Name))
+	err := runRecovered(job)
+	if err != nil {
+		slog.Error("job failed", slog.String("job", job.Name), slog.Any("error", err))
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	job.running = false
+	job.lastRun = time.Now()
+	job.nextRun = job.lastRun.Add(job.Interval)
+	job.lastError = ""
+	if err != nil {
+		job.lastError = err.Error()
+	}
+}
+
+// runRecovered runs the job, a panicking job fails instead of taking the daemon down with it
+func runRecovered(job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return job.Run()
+}
+
+// Trigger queues a job to run as soon as the current one is done
+func (d *Daemon) Trigger(name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, job := range d.jobs {
+		if job.Name != name {
+			continue
+		}
+		if job.Pausable && d.paused {
+			return ErrPaused
+		}
+		select {
+		case d.trigger <- job:
+			return nil
+		default:
+			return ErrQueued
+		}
+	}
+
+	return ErrUnknownJob
+}
+
+// SetPaused pauses or resumes the pausable jobs
+func (d *Daemon) SetPaused(paused bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.paused = paused
+	slog.Info("daemon pause state changed", slog.Bool("paused", paused))
+}
+
+// Status returns a snapshot of the daemon and its jobs
+func (d *Daemon) Status() *Status {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	status := &Status{
+		StartedAt: d.startedAt,
+		Paused:    d.paused,
+		Jobs:      make([]JobStatus, 0, len(d.jobs)),
+	}
+	for _, job := range d.jobs {
+		status.Jobs = append(status.Jobs, JobStatus{
+			Name:      job.Name,
+			Interval:  job.Interval.String(),
+			Running:   job.running,
+			LastRun:   job.lastRun,
+			LastError: job.lastError,
+			NextRun:   job.nextRun,
+		})
+	}
+	return status
+}
+
+// heartbeat ticks at half the watchdog interval, it never ticks when the watchdog is off
+type heartbeat struct {
+	C      <-chan time.Time
+	ticker *time.Ticker
+}
+
+func newHeartbeat() *heartbeat {
+	interval := sdnotify.WatchdogInterval()
+	if interval == 0 {
+		return &heartbeat{}
+	}
+
+	// nolint: mnd
+	ticker := time.NewTicker(interval / 2)
+	return &heartbeat{C: ticker.C, ticker: ticker}
+}
+
+func (h *heartbeat) Stop() {
+	if h.ticker != nil {
+		h.ticker.Stop()
+	}
+}
+
+func pingWatchdog() {
+	if err := sdnotify.Notify(sdnotify.Watchdog); err != nil {
+		slog.Debug("failed to ping systemd watchdog", slog.Any("error", err))
+	}
+}
+
+// listen creates the control socket, readable and writable by root only
+func listen(path string) (net.Listener, error) {
+	// A socket left behind by a crashed daemon would make listen fail
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
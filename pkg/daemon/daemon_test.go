@@ -0,0 +1,185 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startDaemon runs the daemon until the test is done
+func startDaemon(t *testing.T, jobs ...*Job) *Daemon {
+	t.Helper()
+
+	d := New(Config{SocketPath: filepath.Join(t.TempDir(), "control.sock"), ShutdownGrace: time.Second}, jobs...)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- d.Run(ctx) }()
+
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("daemon failed: %s", err)
+		}
+	})
+	waitFor(t, 5*time.Second, func() bool { return !d.Status().StartedAt.IsZero() })
+	return d
+}
+
+// waitFor polls cond until it's true or the timeout passes
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the daemon")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDaemonSurvivesFailedRuns(t *testing.T) {
+	var runs atomic.Int32
+	job := &Job{
+		Name:     "update",
+		Interval: 10 * time.Millisecond,
+		Run: func() error {
+			switch runs.Add(1) {
+			case 1:
+				return errors.New("update failed")
+			case 2:
+				panic("update panicked")
+			}
+			return nil
+		},
+	}
+	d := startDaemon(t, job)
+
+	waitFor(t, 5*time.Second, func() bool { return runs.Load() >= 2 })
+	waitFor(t, 5*time.Second, func() bool {
+		return runs.Load() >= 3 && d.Status().Jobs[0].LastError == ""
+	})
+}
+
+func TestDaemonSchedule(t *testing.T) {
+	var runs atomic.Int32
+	job := &Job{
+		Name:         "openvox",
+		Interval:     time.Hour,
+		InitialDelay: 100 * time.Millisecond,
+		Run: func() error {
+			runs.Add(1)
+			return nil
+		},
+	}
+	d := startDaemon(t, job)
+
+	status := d.Status().Jobs[0]
+	if runs.Load() != 0 {
+		t.Error("job ran before its initial delay")
+	}
+	if !status.LastRun.IsZero() || status.Interval != "1h0m0s" {
+		t.Errorf("unexpected status before the first run: %+v", status)
+	}
+
+	waitFor(t, 5*time.Second, func() bool { return runs.Load() == 1 })
+	waitFor(t, 5*time.Second, func() bool { return !d.Status().Jobs[0].LastRun.IsZero() })
+
+	status = d.Status().Jobs[0]
+	if next := status.NextRun.Sub(status.LastRun); next != time.Hour {
+		t.Errorf("expected the next run an interval after the last one, got %s", next)
+	}
+
+	// A triggered job runs right away, without waiting for the interval
+	if err := d.Trigger("openvox"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, func() bool { return runs.Load() == 2 })
+
+	if err := d.Trigger("unknown"); !errors.Is(err, ErrUnknownJob) {
+		t.Errorf("expected ErrUnknownJob, got: %v", err)
+	}
+}
+
+func TestDaemonPausedJobs(t *testing.T) {
+	var runs atomic.Int32
+	job := &Job{
+		Name:     "update",
+		Interval: 10 * time.Millisecond,
+		Pausable: true,
+		Run: func() error {
+			runs.Add(1)
+			return nil
+		},
+	}
+	d := New(Config{}, job)
+	d.SetPaused(true)
+
+	if err := d.Trigger("update"); !errors.Is(err, ErrPaused) {
+		t.Errorf("expected ErrPaused, got: %v", err)
+	}
+
+	d.runJob(job)
+	if runs.Load() != 0 {
+		t.Error("paused job ran")
+	}
+	if job.nextRun.IsZero() {
+		t.Error("skipped job wasn't rescheduled")
+	}
+
+	d.SetPaused(false)
+	d.runJob(job)
+	if runs.Load() != 1 {
+		t.Errorf("expected 1 run after resume, got %d", runs.Load())
+	}
+}
+
+func TestDaemonWatchdogDuringJob(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", socket)
+	t.Setenv("WATCHDOG_USEC", "20000")
+	t.Setenv("WATCHDOG_PID", "")
+
+	var pings atomic.Int32
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			if string(buf[:n]) == "WATCHDOG=1" {
+				pings.Add(1)
+			}
+		}
+	}()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	job := &Job{
+		Name:     "openvox",
+		Interval: time.Hour,
+		Run: func() error {
+			close(started)
+			<-release
+			return nil
+		},
+	}
+	startDaemon(t, job)
+	<-started
+
+	// The job runs well past the watchdog interval, the loop has to keep pinging
+	before := pings.Load()
+	waitFor(t, 5*time.Second, func() bool { return pings.Load() >= before+5 })
+}
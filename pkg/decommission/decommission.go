@@ -0,0 +1,159 @@
+package decommission
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/metrics"
+)
+
+// Units installed next to linuxaid that run it on a schedule. They're listed one by one,
+// other units named after obmondo or linuxaid don't belong to us.
+var linuxaidUnits = []string{
+	"run_puppet.service",
+	"run_puppet.timer",
+	"obmondo-system-update.service",
+	"obmondo-system-update.timer",
+	"linuxaid-cli.service",
+}
+
+// Action is one change decommissioning makes to the node
+type Action struct {
+	Description string
+	Run         func() error
+}
+
+type Options struct {
+	OS helper.OSRelease
+	// KeepCerts leaves the puppet SSL directory in place, e.g. to reinstall with the same certname
+	KeepCerts bool
+}
+
+// Plan returns what decommissioning this node does, in order, leaving out what isn't there
+func Plan(opts Options) []Action {
+	var actions []Action
+
+	units := unitFiles(constant.SystemdUnitDir)
+	for _, unit := range units {
+		name := filepath.Base(unit)
+		actions = append(actions, Action{
+			Description: "stop and disable " + name,
+			Run:         func() error { return run("systemctl", "disable", "--now", name) },
+		})
+	}
+
+	if remove := removePackageCommand(opts.OS); remove != nil && isAgentInstalled(opts.OS) {
+		actions = append(actions, Action{
+			Description: "remove the " + constant.OpenvoxPackageName + " package",
+			Run:         func() error { return run(remove[0], remove[1:]...) },
+		})
+	}
+
+	paths := slices.Concat(units, []string{
+		constant.PuppetConfig, constant.ExternalFacterFile, constant.InstallStateFile,
+		constant.AptOpenvoxSourceFile, constant.AptOpenvoxKeyFile,
+		constant.YumOpenvoxRepoFile, constant.ZypperOpenvoxRepoFile, constant.RPMOpenvoxKeyFile,
+	})
+	if !opts.KeepCerts {
+		paths = append(paths, constant.PuppetSSLDir)
+	}
+	for _, file := range []string{metrics.PuppetRunFile, metrics.SystemUpdateFile, metrics.CertificateFile} {
+		paths = append(paths, filepath.Join(constant.NodeExporterTextfileDir, file))
+	}
+
+	for _, path := range existing(paths) {
+		actions = append(actions, Action{
+			Description: "remove " + path,
+			Run:         func() error { return os.RemoveAll(path) },
+		})
+	}
+
+	if len(units) > 0 {
+		actions = append(actions, Action{
+			Description: "reload systemd",
+			Run:         func() error { return run("systemctl", "daemon-reload") },
+		})
+	}
+
+	return actions
+}
+
+// Run runs every action, a failing action doesn't stop the ones after it
+func Run(actions []Action) error {
+	var errs []error
+	for _, action := range actions {
+		slog.Info("decommissioning", slog.String("action", action.Description))
+		if err := action.Run(); err != nil {
+			slog.Error("decommission action failed", slog.String("action", action.Description), slog.Any("error", err))
+			errs = append(errs, fmt.Errorf("%s: %w", action.Description, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// unitFiles returns the linuxaid unit files in dir
+func unitFiles(dir string) []string {
+	var units []string
+	for _, name := range linuxaidUnits {
+		units = append(units, filepath.Join(dir, name))
+	}
+	units = existing(units)
+	slices.Sort(units)
+	return units
+}
+
+func existing(paths []string) []string {
+	var found []string
+	for _, path := range paths {
+		if _, err := os.Lstat(path); err == nil || !errors.Is(err, fs.ErrNotExist) {
+			found = append(found, path)
+		}
+	}
+	return found
+}
+
+func removePackageCommand(osRelease helper.OSRelease) []string {
+	if osRelease.ID == "turrisos" {
+		return []string{"gem", "uninstall", "--executables", "puppet"}
+	}
+
+	switch osRelease.Family() {
+	case helper.FamilyDebian:
+		return []string{"apt-get", "purge", "-y", constant.OpenvoxPackageName}
+	case helper.FamilySUSE:
+		return []string{"zypper", "--non-interactive", "remove", constant.OpenvoxPackageName}
+	case helper.FamilyRedHat:
+		return []string{helper.RedHatPackageManager(), "remove", "-y", constant.OpenvoxPackageName}
+	}
+	return nil
+}
+
+func isAgentInstalled(osRelease helper.OSRelease) bool {
+	if osRelease.ID == "turrisos" {
+		return exec.Command("gem", "list", "--installed", "^puppet$").Run() == nil
+	}
+
+	switch osRelease.Family() {
+	case helper.FamilyDebian:
+		return exec.Command("dpkg-query", "-W", constant.OpenvoxPackageName).Run() == nil
+	case helper.FamilySUSE, helper.FamilyRedHat:
+		return exec.Command("rpm", "-q", constant.OpenvoxPackageName).Run() == nil
+	}
+	return false
+}
+
+func run(name string, args ...string) error {
+	if out, err := exec.Command(name, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s %s failed: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package decommission
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestUnitFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"run_puppet.service", "run_puppet.timer", "linuxaid-cli.service", "obmondo-system-update.timer", "nginx.service", "linuxaid.conf", "obmondo-exporter.service", "linuxaid-backup.timer"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var names []string
+	for _, unit := range unitFiles(dir) {
+		names = append(names, filepath.Base(unit))
+	}
+
+	want := []string{"linuxaid-cli.service", "obmondo-system-update.timer", "run_puppet.service", "run_puppet.timer"}
+	if !slices.Equal(names, want) {
+		t.Errorf("unitFiles = %v, want %v", names, want)
+	}
+}
+
+func TestExisting(t *testing.T) {
+	dir := t.TempDir()
+	present := filepath.Join(dir, "puppet.conf")
+	if err := os.WriteFile(present, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := existing([]string{present, filepath.Join(dir, "missing.yaml")}); !slices.Equal(got, []string{present}) {
+		t.Errorf("existing = %v, want only %s", got, present)
+	}
+}
@@ -1,54 +1,259 @@
 package disk
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/config"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
 
 	gpud "github.com/shirou/gopsutil/disk"
 )
 
-const (
-	boot = 10000000  // 10Mb
-	root = 100000000 // 100Mb
-)
+// Paths checked by default and the free space they need, package caches live in /var/cache
+// and the provisioner downloads to /tmp
+var defaultThresholds = map[string]Threshold{
+	"/":          {Bytes: 100 * mb},
+	"/boot":      {Bytes: 10 * mb},
+	"/var":       {Bytes: 200 * mb},
+	"/var/cache": {Bytes: 200 * mb},
+	"/tmp":       {Bytes: 100 * mb},
+	"/opt":       {Bytes: 100 * mb},
+}
 
-var diskFreeSize = map[string]uint64{
-	"/boot": boot,
-	"/":     root,
+// installThresholds is what linuxaid-install checks, the free space it has always needed
+var installThresholds = map[string]Threshold{
+	"/":     {Bytes: 100 * mb},
+	"/boot": {Bytes: 10 * mb},
 }
 
-func listPartitions() ([]gpud.PartitionStat, error) {
-	// Only returns physical devices only (e.g. hard disks, cd-rom drives, USB keys)
-	allPartitions, err := gpud.Partitions(false)
-	if err != nil {
-		slog.Error("error while getting partitions", slog.String("error", err.Error()))
-		return nil, err
+// defaultMinFreeInodes is the share of inodes that has to be free on every filesystem checked
+var defaultMinFreeInodes = Threshold{Percent: 5}
+
+// Options is what Check checks
+type Options struct {
+	// Thresholds is the free space each path needs, on the filesystem it's on
+	Thresholds map[string]Threshold
+	// MinFreeInodes is the free inodes every filesystem needs
+	MinFreeInodes Threshold
+	// Estimate is the space the pending upgrade needs on top of the thresholds, nil when unknown
+	Estimate *Estimate
+}
+
+// ConfigOptions returns the default thresholds with the ones from the config on top
+func ConfigOptions() (Options, error) {
+	opts := Options{Thresholds: make(map[string]Threshold), MinFreeInodes: defaultMinFreeInodes}
+	for path, threshold := range defaultThresholds {
+		opts.Thresholds[path] = threshold
 	}
 
-	return allPartitions, nil
+	for path, value := range config.GetDiskThresholds() {
+		threshold, err := ParseThreshold(value)
+		if err != nil {
+			return opts, fmt.Errorf("invalid disk threshold for %s: %w", path, err)
+		}
+		opts.Thresholds[path] = threshold
+	}
+
+	if value := config.GetDiskMinFreeInodes(); value != "" {
+		threshold, err := ParseThreshold(value)
+		if err != nil {
+			return opts, fmt.Errorf("invalid minimum of free inodes: %w", err)
+		}
+		opts.MinFreeInodes = threshold
+	}
+
+	return opts, nil
 }
 
-func CheckDiskSize() error {
-	partitions, err := listPartitions()
+// MountCheck is the free space and inodes of the filesystem a path is on, and what it needs
+type MountCheck struct {
+	Path          string  `json:"path"`
+	Mountpoint    string  `json:"mountpoint"`
+	Fstype        string  `json:"fstype"`
+	Total         uint64  `json:"total_bytes"`
+	Free          uint64  `json:"free_bytes"`
+	UsedPercent   float64 `json:"used_percent"`
+	MinFree       uint64  `json:"min_free_bytes"`
+	Required      uint64  `json:"required_bytes,omitempty"`
+	InodesTotal   uint64  `json:"inodes_total"`
+	InodesFree    uint64  `json:"inodes_free"`
+	MinFreeInodes uint64  `json:"min_free_inodes"`
+	// Skipped says why the path wasn't checked
+	Skipped  string   `json:"skipped,omitempty"`
+	Problems []string `json:"problems,omitempty"`
+}
+
+// OK reports whether the filesystem has the free space and inodes the path needs
+func (m *MountCheck) OK() bool {
+	return len(m.Problems) == 0
+}
+
+// Result lists every path checked
+type Result struct {
+	Mounts   []MountCheck `json:"mounts"`
+	Estimate *Estimate    `json:"upgrade_estimate,omitempty"`
+}
+
+// OK reports whether every path checked has enough free space and inodes
+func (r *Result) OK() bool {
+	return !slices.ContainsFunc(r.Mounts, func(m MountCheck) bool { return !m.OK() })
+}
+
+// Err returns the problems of every path that failed its check, nil when none did
+func (r *Result) Err() error {
+	var errs []error
+	for _, mount := range r.Mounts {
+		for _, problem := range mount.Problems {
+			errs = append(errs, fmt.Errorf("%s: %s", mount.Path, problem))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Check checks the free space and inodes of every path in the thresholds, paths that don't exist
+// are left out
+func Check(opts Options) (*Result, error) {
+	mounts, err := os.ReadFile(constant.MountsFile)
 	if err != nil {
-		slog.Error("failed to fetch disk partitions", slog.String("error", err.Error()))
-		return fmt.Errorf("failed to fetch disk partitions: %w", err)
+		return nil, fmt.Errorf("failed to read the mount table: %w", err)
 	}
 
-	for _, p := range partitions {
-		disk, err := gpud.Usage(p.Mountpoint)
+	paths := make([]string, 0, len(opts.Thresholds))
+	for path := range opts.Thresholds {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+
+	result := &Result{Estimate: opts.Estimate}
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+
+		check := MountCheck{Path: path}
+		check.Mountpoint, check.Fstype = mountOf(mounts, path)
+
+		// The free space of an overlay, as / in most containers, belongs to the host
+		if check.Fstype == "overlay" {
+			check.Skipped = "overlay filesystem"
+			result.Mounts = append(result.Mounts, check)
+			continue
+		}
+
+		usage, err := gpud.Usage(path)
 		if err != nil {
-			slog.Error("failed to fetch file system usage", slog.String("error", err.Error()))
-			return fmt.Errorf("failed to fetch file system usage: %w", err)
+			slog.Error("failed to fetch file system usage", slog.String("path", path), slog.String("error", err.Error()))
+			return nil, fmt.Errorf("failed to fetch file system usage of %s: %w", path, err)
 		}
 
-		if p.Mountpoint == "/boot" || p.Mountpoint == "/" {
-			if disk.Free <= diskFreeSize[p.Mountpoint] {
-				err := fmt.Errorf("%s has %v bytes of space left, exiting", p.Mountpoint, disk.Free)
-				slog.Error("disk space is low", slog.String("error", err.Error()))
-				return fmt.Errorf("disk space is low: %w", err)
-			}
+		check.Total = usage.Total
+		check.Free = usage.Free
+		check.UsedPercent = usage.UsedPercent
+		check.InodesTotal = usage.InodesTotal
+		check.InodesFree = usage.InodesFree
+		check.MinFree = opts.Thresholds[path].MinFree(usage.Total)
+		check.Required = opts.Estimate.requiredOn(check.Mountpoint, mounts)
+		check.MinFreeInodes = opts.MinFreeInodes.MinFree(usage.InodesTotal)
+
+		if needed := check.MinFree + check.Required; check.Free < needed {
+			check.Problems = append(check.Problems, fmt.Sprintf("%s free, %s needed", formatSize(check.Free), formatSize(needed)))
+		}
+		// Filesystems without a fixed number of inodes, like btrfs, report none
+		if check.InodesTotal > 0 && check.InodesFree < check.MinFreeInodes {
+			check.Problems = append(check.Problems, fmt.Sprintf("%d inodes free, %d needed", check.InodesFree, check.MinFreeInodes))
 		}
+
+		result.Mounts = append(result.Mounts, check)
+	}
+
+	return result, nil
+}
+
+// mountOf returns the mountpoint and filesystem type path is on, from a mount table
+// like /proc/self/mounts, the last mount on a mountpoint wins
+func mountOf(mounts []byte, path string) (string, string) {
+	var mountpoint, fstype string
+	for _, line := range strings.Split(string(mounts), "\n") {
+		// device mountpoint fstype options dump pass
+		fields := strings.Fields(line)
+		if len(fields) < 3 || !isBelow(path, fields[1]) {
+			continue
+		}
+		if len(fields[1]) >= len(mountpoint) {
+			mountpoint, fstype = fields[1], fields[2]
+		}
+	}
+	return mountpoint, fstype
+}
+
+func isBelow(path, mountpoint string) bool {
+	return mountpoint == "/" || path == mountpoint || strings.HasPrefix(path, mountpoint+"/")
+}
+
+// CheckDiskSize checks the paths from the config have enough free space and inodes
+func CheckDiskSize() error {
+	opts, err := ConfigOptions()
+	if err != nil {
+		return err
+	}
+	return check(opts)
+}
+
+// CheckInstallSize checks / and /boot have the free space linuxaid-install needs, without the
+// thresholds from the config and the inode check, those are for the upgrades of system-update
+func CheckInstallSize() error {
+	return check(Options{Thresholds: installThresholds})
+}
+
+// CheckUpgradeSpace checks the paths from the config have enough free space and inodes for
+// the pending upgrade on top, the upgrade size is estimated with the package manager of family
+func CheckUpgradeSpace(family string) error {
+	estimate, err := EstimateUpgrade(family)
+	if err != nil {
+		// Without an estimate the thresholds still apply
+		slog.Warn("unable to estimate the size of the upgrade", slog.Any("error", err))
+	}
+
+	opts, err := ConfigOptions()
+	if err != nil {
+		return err
+	}
+	opts.Estimate = estimate
+	return check(opts)
+}
+
+// CheckConfigured checks the paths from the config, with the estimate of the pending upgrade when it's not nil
+func CheckConfigured(estimate *Estimate) (*Result, error) {
+	opts, err := ConfigOptions()
+	if err != nil {
+		return nil, err
+	}
+	opts.Estimate = estimate
+
+	return Check(opts)
+}
+
+func check(opts Options) error {
+	result, err := Check(opts)
+	if err != nil {
+		slog.Error("failed to fetch disk usage", slog.String("error", err.Error()))
+		return err
+	}
+
+	for _, mount := range result.Mounts {
+		slog.Debug("checked disk space", slog.String("path", mount.Path), slog.String("mountpoint", mount.Mountpoint),
+			slog.Uint64("free_bytes", mount.Free), slog.Uint64("needed_bytes", mount.MinFree+mount.Required),
+			slog.Uint64("inodes_free", mount.InodesFree), slog.String("skipped", mount.Skipped))
+	}
+
+	if err := result.Err(); err != nil {
+		slog.Error("disk space is low", slog.String("error", err.Error()))
+		return fmt.Errorf("disk space is low: %w", err)
 	}
 
 	return nil
@@ -0,0 +1,104 @@
+package disk
+
+import (
+	"testing"
+
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper"
+)
+
+func TestMountOf(t *testing.T) {
+	mounts := []byte(`/dev/sda1 / ext4 rw,relatime 0 0
+proc /proc proc rw,nosuid,nodev,noexec,relatime 0 0
+/dev/sda2 /var ext4 rw,relatime 0 0
+tmpfs /tmp tmpfs rw 0 0
+overlay / overlay rw,relatime,lowerdir=/var/lib/docker/overlay2/l/ABC 0 0
+`)
+	tests := []struct {
+		path, mountpoint, fstype string
+	}{
+		{"/", "/", "overlay"},
+		{"/var/cache", "/var", "ext4"},
+		{"/variable", "/", "overlay"},
+		{"/tmp", "/tmp", "tmpfs"},
+	}
+	for _, tt := range tests {
+		mountpoint, fstype := mountOf(mounts, tt.path)
+		if mountpoint != tt.mountpoint || fstype != tt.fstype {
+			t.Errorf("mountOf(%q) = %q, %q, want %q, %q", tt.path, mountpoint, fstype, tt.mountpoint, tt.fstype)
+		}
+	}
+}
+
+func TestParseThreshold(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Threshold
+	}{
+		{"500MB", Threshold{Bytes: 500 * mb}},
+		{"2 GiB", Threshold{Bytes: 2 * gib}},
+		{"1,024 kB", Threshold{Bytes: 1024 * kb}},
+		{"10%", Threshold{Percent: 10}},
+	}
+	for _, tt := range tests {
+		got, err := ParseThreshold(tt.value)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseThreshold(%q) = %+v, %v, want %+v", tt.value, got, err, tt.want)
+		}
+	}
+
+	for _, value := range []string{"", "lots", "150%", "10 TB"} {
+		if _, err := ParseThreshold(value); err == nil {
+			t.Errorf("ParseThreshold(%q) succeeded, want an error", value)
+		}
+	}
+
+	if got := (Threshold{Percent: 5}).MinFree(1000); got != 50 {
+		t.Errorf("5%% of 1000 = %d, want 50", got)
+	}
+}
+
+func TestParseEstimate(t *testing.T) {
+	tests := []struct {
+		family string
+		out    string
+		want   Estimate
+	}{
+		{helper.FamilyDebian, `12 upgraded, 1 newly installed, 0 to remove and 0 not upgraded.
+Need to get 45.3 MB of archives.
+After this operation, 1,024 kB of additional disk space will be used.
+Do you want to continue? [Y/n] Abort.`, Estimate{Download: 45300 * kb, Install: 1024 * kb}},
+		{helper.FamilyRedHat, `Transaction Summary
+Upgrade  14 Packages
+
+Total download size: 52 M
+Operation aborted.`, Estimate{Download: 52 * mib}},
+		{helper.FamilyRedHat, `Total size of inbound packages is 20 MiB. Need to download 20 MiB.
+After this operation, 3 MiB extra will be used (install 60 MiB, remove 57 MiB).`, Estimate{Download: 20 * mib, Install: 3 * mib}},
+		{helper.FamilySUSE, `3 packages to upgrade.
+Overall download size: 9.5 MiB. Already cached: 0 B. After the operation, additional 1.2 MiB will be used.`,
+			Estimate{Download: 9961472, Install: 1258291}},
+		{helper.FamilyDebian, "0 upgraded, 0 newly installed, 0 to remove and 0 not upgraded.", Estimate{}},
+	}
+	for _, tt := range tests {
+		got, err := parseEstimate(tt.family, []byte(tt.out))
+		if err != nil || *got != tt.want {
+			t.Errorf("parseEstimate(%s) = %+v, %v, want %+v", tt.family, got, err, tt.want)
+		}
+	}
+}
+
+func TestInstallThresholds(t *testing.T) {
+	// linuxaid-install only needs the free space on / it always did, no inodes and no config
+	result, err := Check(Options{Thresholds: installThresholds})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mount := range result.Mounts {
+		if mount.MinFreeInodes != 0 {
+			t.Errorf("%s needs %d free inodes, want no inode check", mount.Path, mount.MinFreeInodes)
+		}
+		if mount.Path == "/" && mount.Skipped == "" && mount.MinFree != 100*mb {
+			t.Errorf("/ needs %d bytes free, want %d", mount.MinFree, 100*mb)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package disk
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper"
+)
+
+// Estimate is the space the pending upgrade needs: the packages it downloads to the package cache,
+// and what the filesystem with /usr grows by once they're installed
+type Estimate struct {
+	Download uint64 `json:"download_bytes"`
+	Install  uint64 `json:"install_bytes"`
+}
+
+// sizePattern matches a size as the package managers print it, e.g. 12.3 MB or 50 M
+const sizePattern = `([0-9][0-9.,]*\s*[kKMG]?i?B?)`
+
+// How the package managers say what an upgrade downloads and how much more space it uses
+var (
+	downloadPatterns = map[string][]*regexp.Regexp{
+		helper.FamilyDebian: {regexp.MustCompile(`Need to get ` + sizePattern + `(?:/[0-9.,]+\s*[kMG]?B)? of archives`)},
+		helper.FamilyRedHat: {
+			regexp.MustCompile(`Total download size: ` + sizePattern),
+			regexp.MustCompile(`Need to download ` + sizePattern),
+		},
+		helper.FamilySUSE: {regexp.MustCompile(`Overall download size: ` + sizePattern)},
+	}
+	installPatterns = map[string][]*regexp.Regexp{
+		helper.FamilyDebian: {regexp.MustCompile(`After this operation, ` + sizePattern + ` of additional disk space will be used`)},
+		helper.FamilyRedHat: {
+			regexp.MustCompile(`Installed size: ` + sizePattern),
+			regexp.MustCompile(`After this operation, ` + sizePattern + ` extra will be used`),
+		},
+		helper.FamilySUSE: {regexp.MustCompile(`After the operation, additional ` + sizePattern + ` will be used`)},
+	}
+)
+
+// EstimateUpgrade asks the package manager of family what the pending upgrade needs, without upgrading
+func EstimateUpgrade(family string) (*Estimate, error) {
+	var command []string
+	switch family {
+	case helper.FamilyDebian:
+		command = []string{"apt-get", "--assume-no", "--with-new-pkgs", "upgrade"}
+	case helper.FamilyRedHat:
+		command = []string{helper.RedHatPackageManager(), "upgrade", "--assumeno"}
+	case helper.FamilySUSE:
+		command = []string{"zypper", "--non-interactive", "update", "--dry-run"}
+	default:
+		return nil, fmt.Errorf("unknown distribution family %q", family)
+	}
+
+	cmd := exec.Command(command[0], command[1:]...)
+	// The sizes are parsed from the output, which has to be in English
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	out, err := cmd.CombinedOutput()
+
+	// apt and dnf exit non-zero when told not to go ahead with the upgrade
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, fmt.Errorf("%s failed: %w", command[0], err)
+	}
+
+	return parseEstimate(family, out)
+}
+
+func parseEstimate(family string, out []byte) (*Estimate, error) {
+	download, err := matchSize(downloadPatterns[family], out)
+	if err != nil {
+		return nil, err
+	}
+	install, err := matchSize(installPatterns[family], out)
+	if err != nil {
+		return nil, err
+	}
+	return &Estimate{Download: download, Install: install}, nil
+}
+
+// matchSize returns the size the first matching pattern finds, none matching means nothing to download or grow
+func matchSize(patterns []*regexp.Regexp, out []byte) (uint64, error) {
+	for _, pattern := range patterns {
+		if match := pattern.FindSubmatch(out); match != nil {
+			return parseSize(string(match[1]))
+		}
+	}
+	return 0, nil
+}
+
+// requiredOn returns what the upgrade needs on mountpoint, given the mount table
+func (e *Estimate) requiredOn(mountpoint string, mounts []byte) uint64 {
+	if e == nil {
+		return 0
+	}
+
+	var required uint64
+	if cache, _ := mountOf(mounts, constant.PackageCacheDir); cache == mountpoint {
+		required += e.Download
+	}
+	if usr, _ := mountOf(mounts, "/usr"); usr == mountpoint {
+		required += e.Install
+	}
+	return required
+}
@@ -0,0 +1,98 @@
+package disk
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	kb = 1000
+	mb = 1000 * kb
+	gb = 1000 * mb
+
+	kib = 1024
+	mib = 1024 * kib
+	gib = 1024 * mib
+)
+
+// Size units as the config and the package managers write them: apt uses decimal units,
+// dnf and zypper binary ones, dnf without the B
+var sizeUnits = map[string]uint64{
+	"":    1,
+	"b":   1,
+	"kb":  kb,
+	"mb":  mb,
+	"gb":  gb,
+	"k":   kib,
+	"m":   mib,
+	"g":   gib,
+	"kib": kib,
+	"mib": mib,
+	"gib": gib,
+}
+
+// Threshold is the free space, or inodes, a filesystem needs: an absolute amount or a percentage of its total
+type Threshold struct {
+	Bytes   uint64
+	Percent float64
+}
+
+// ParseThreshold parses a threshold like 500MB, 2GiB or 10%
+func ParseThreshold(value string) (Threshold, error) {
+	value = strings.TrimSpace(value)
+
+	if percent, ok := strings.CutSuffix(value, "%"); ok {
+		p, err := strconv.ParseFloat(strings.TrimSpace(percent), 64)
+		if err != nil || p < 0 || p > 100 {
+			return Threshold{}, fmt.Errorf("invalid percentage %q", value)
+		}
+		return Threshold{Percent: p}, nil
+	}
+
+	size, err := parseSize(value)
+	if err != nil {
+		return Threshold{}, err
+	}
+	return Threshold{Bytes: size}, nil
+}
+
+// MinFree returns the threshold for a filesystem of total bytes or inodes
+func (t Threshold) MinFree(total uint64) uint64 {
+	if t.Percent > 0 {
+		return uint64(float64(total) * t.Percent / 100)
+	}
+	return t.Bytes
+}
+
+// parseSize parses a size like 12.3 MB, 50 M or 1,024 kB
+func parseSize(value string) (uint64, error) {
+	value = strings.ReplaceAll(strings.TrimSpace(value), ",", "")
+
+	number := strings.TrimRightFunc(value, func(r rune) bool { return r < '0' || r > '9' })
+	unit := strings.ToLower(strings.TrimSpace(value[len(number):]))
+
+	multiplier, ok := sizeUnits[unit]
+	if !ok || number == "" {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+
+	size, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return uint64(size * float64(multiplier)), nil
+}
+
+// formatSize formats a size in binary units, e.g. 1.5 GiB
+func formatSize(size uint64) string {
+	switch {
+	case size >= gib:
+		return fmt.Sprintf("%.1f GiB", float64(size)/gib)
+	case size >= mib:
+		return fmt.Sprintf("%.1f MiB", float64(size)/mib)
+	case size >= kib:
+		return fmt.Sprintf("%.1f KiB", float64(size)/kib)
+	}
+	return strconv.FormatUint(size, 10) + " B"
+}
@@ -0,0 +1,316 @@
+package doctor
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/checkconnectivity"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/disk"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/puppet"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/webtee"
+)
+
+const (
+	clockSkewWarning = 30 * time.Second
+	// Puppet refuses certificates and reports when the clocks are this far apart
+	clockSkewFailure = 5 * time.Minute
+	networkTimeout   = 10 * time.Second
+)
+
+// packageManagerLocks are held with fcntl locks by dpkg and rpm, zypper only writes its pid
+var packageManagerLocks = []string{
+	"/var/lib/dpkg/lock-frontend",
+	"/var/lib/dpkg/lock",
+	"/var/lib/rpm/.rpm.lock",
+}
+
+const zypperPidFile = "/run/zypp.pid"
+
+func Root() Check {
+	return Check{Name: "root", Run: func() Result {
+		current, err := user.Current()
+		if err != nil {
+			return fail(err.Error(), "")
+		}
+		if current.Uid != "0" {
+			return fail("running as "+current.Username, "run linuxaid-cli as root, most checks need to read puppet's ssl and state directories")
+		}
+		return pass("running as root")
+	}}
+}
+
+func OSRelease() Check {
+	return Check{Name: "os-release", Run: func() Result {
+		osRelease, err := helper.ReadOSRelease()
+		if err != nil {
+			return fail(err.Error(), "the os-release package is missing or the file was removed")
+		}
+		osName := osRelease.String()
+		if _, err := helper.IsSupportedOS(osRelease); err != nil {
+			// system-update still updates it with the package manager of its family
+			if family := osRelease.Family(); family != "" {
+				return warn(osName+" is not in the support matrix, it's updated as a "+family+" distribution", err.Error())
+			}
+			return fail(osName+" is not supported", err.Error())
+		}
+		return pass(osName + " is supported")
+	}}
+}
+
+func Certname() Check {
+	return Check{Name: "certname", Run: func() Result {
+		resolution, err := helper.ResolveCertname()
+		if err != nil {
+			return fail(err.Error(), "see `linuxaid-cli certname --explain` for every source that was looked at")
+		}
+		if conflicts := resolution.Conflicts(); len(conflicts) > 0 {
+			others := make([]string, 0, len(conflicts))
+			for _, conflict := range conflicts {
+				others = append(others, conflict.Certname+" from "+conflict.Source)
+			}
+			return warn(resolution.Certname+" from "+resolution.Source+" conflicts with "+strings.Join(others, ", "),
+				"make the sources agree, see `linuxaid-cli certname --explain`")
+		}
+		return pass(resolution.Certname + " from " + resolution.Source)
+	}}
+}
+
+// PuppetCertificate checks the host certificate exists, parses and is within its validity period,
+// warning when it expires within warnWithin
+func PuppetCertificate(certPath string, warnWithin time.Duration) Check {
+	return Check{Name: "puppet-certificate", Run: func() Result {
+		cert, err := helper.ParseCertFile(certPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			return fail(certPath+" not found", "the node isn't signed yet, run linuxaid-install or `puppet ssl bootstrap`")
+		}
+		if err != nil {
+			return fail("unable to parse "+certPath+": "+err.Error(), "remove the file and run `puppet ssl bootstrap` to fetch it again")
+		}
+
+		now := time.Now()
+		switch {
+		case now.Before(cert.NotBefore):
+			return fail("certificate is not valid before "+cert.NotBefore.Format(time.RFC3339), "check the system clock")
+		case now.After(cert.NotAfter):
+			return fail("certificate expired on "+cert.NotAfter.Format(time.RFC3339), "the certificate can't be renewed with itself anymore, contact Obmondo")
+		case cert.NotAfter.Sub(now) < warnWithin:
+			return warn("certificate expires on "+cert.NotAfter.Format(time.RFC3339), "renew it with `linuxaid-cli openvox renew-cert`")
+		}
+
+		return pass(fmt.Sprintf("%s valid until %s", cert.Subject.CommonName, cert.NotAfter.Format(time.DateOnly)))
+	}}
+}
+
+func CertificateKeyPair(certPath, keyPath string) Check {
+	return Check{Name: "certificate-key-pair", Run: func() Result {
+		if _, err := tls.LoadX509KeyPair(certPath, keyPath); err != nil {
+			return fail(err.Error(), "the private key doesn't belong to the certificate, clean the ssl directory and sign the node again")
+		}
+		return pass("private key matches the certificate")
+	}}
+}
+
+// ClockSkew compares the local clock with the Date header of the API
+func ClockSkew(apiURL string) Check {
+	return Check{Name: "clock-skew", Run: func() Result {
+		client := &http.Client{Timeout: networkTimeout}
+		resp, err := client.Head(apiURL)
+		if err != nil {
+			return warn("unable to reach "+apiURL+": "+err.Error(), "the clock can't be compared, see the dns and tcp checks")
+		}
+		resp.Body.Close()
+
+		serverTime, err := http.ParseTime(resp.Header.Get("Date"))
+		if err != nil {
+			return warn("API response has no usable Date header", "")
+		}
+
+		skew := time.Since(serverTime).Round(time.Second)
+		if skew < 0 {
+			skew = -skew
+		}
+
+		hint := "enable time synchronisation, e.g. `timedatectl set-ntp true`"
+		switch {
+		case skew > clockSkewFailure:
+			return fail("clock is off by "+skew.String(), hint)
+		case skew > clockSkewWarning:
+			return warn("clock is off by "+skew.String(), hint)
+		}
+		return pass("clock is off by " + skew.String())
+	}}
+}
+
+func DNS() Check {
+	return Check{Name: "dns", Run: func() Result {
+		hosts, err := checkconnectivity.HostList()
+		if err != nil {
+			return fail(err.Error(), "see the certname check")
+		}
+
+		var failed []string
+		for _, host := range hosts {
+			if _, err := net.LookupHost(host); err != nil {
+				failed = append(failed, host)
+			}
+		}
+		if len(failed) > 0 {
+			return fail("unable to resolve "+strings.Join(failed, ", "), "check the nameservers in /etc/resolv.conf")
+		}
+		return pass("resolved " + strings.Join(hosts, ", "))
+	}}
+}
+
+func TCP() Check {
+	return Check{Name: "tcp", Run: func() Result {
+		statuses, err := checkconnectivity.CheckHosts()
+		if err != nil {
+			return fail(err.Error(), "see the certname check")
+		}
+
+		var failed, reachable []string
+		for _, status := range statuses {
+			address := status.Host + ":" + status.Port
+			if !status.Reachable {
+				failed = append(failed, address)
+				continue
+			}
+			reachable = append(reachable, address)
+		}
+		if len(failed) > 0 {
+			return fail("unable to connect to "+strings.Join(failed, ", "), "allow outgoing traffic to these hosts on port 443 in the firewall or proxy")
+		}
+		return pass("connected to " + strings.Join(reachable, ", "))
+	}}
+}
+
+func Webtee(w *webtee.Webtee) Check {
+	return Check{Name: "webtee", Run: func() Result {
+		if err := w.CheckConnection(networkTimeout); err != nil {
+			return warn(err.Error(), "run logs won't reach Obmondo, check that gRPC over port 443 isn't blocked by a proxy")
+		}
+		return pass("gRPC handshake succeeded")
+	}}
+}
+
+func PuppetServer(s *puppet.Service) Check {
+	return Check{Name: "puppet-server", Run: func() Result {
+		if err := s.CheckServerStatus(); err != nil {
+			return fail(err.Error(), "check the server in "+constant.PuppetConfig+" and the tcp check")
+		}
+		return pass("status endpoint is healthy")
+	}}
+}
+
+func DiskSpace() Check {
+	return Check{Name: "disk-space", Run: func() Result {
+		result, err := disk.CheckConfigured(nil)
+		if err != nil {
+			return fail(err.Error(), "")
+		}
+
+		var low []string
+		for _, mount := range result.Mounts {
+			if !mount.OK() {
+				low = append(low, fmt.Sprintf("%s (%s)", mount.Path, strings.Join(mount.Problems, ", ")))
+			}
+		}
+		if len(low) > 0 {
+			return fail("low disk space on "+strings.Join(low, "; "), "free up space or adjust disk.thresholds, system updates refuse to run on a full disk")
+		}
+		return pass(strconv.Itoa(len(result.Mounts)) + " paths have enough free space and inodes")
+	}}
+}
+
+// StaleLocks looks for puppet locks left behind by runs that died or were interrupted
+func StaleLocks(s *puppet.Service) Check {
+	return Check{Name: "stale-locks", Run: func() Result {
+		pid, err := s.StaleRunningLock()
+		if err != nil {
+			return fail(err.Error(), "")
+		}
+		if pid != 0 {
+			return warn(fmt.Sprintf("%s points at pid %d which isn't running", constant.AgentRunningLockFile, pid), "remove the lock file if the next openvox run doesn't clean it up")
+		}
+
+		status, err := s.Status()
+		if err != nil {
+			return fail(err.Error(), "")
+		}
+		if status.Disabled && status.DisabledMessage == constant.SystemUpdateDisableMessage {
+			return warn("agent was left disabled by an interrupted system-update", "enable it again with `puppet agent --enable`")
+		}
+		if status.Disabled {
+			return warn("agent is disabled: "+status.DisabledMessage, "enable it with `puppet agent --enable` once the reason no longer applies")
+		}
+		return pass("no stale locks")
+	}}
+}
+
+func PackageManagerLock() Check {
+	return Check{Name: "package-manager-lock", Run: func() Result {
+		for _, path := range packageManagerLocks {
+			pid, err := fcntlLockHolder(path)
+			if err != nil {
+				return fail(err.Error(), "")
+			}
+			if pid != 0 {
+				return warn(fmt.Sprintf("%s is held by pid %d", path, pid), "wait for the package manager to finish, system updates will fail until then")
+			}
+		}
+
+		if pid := zypperPid(); pid != 0 {
+			return warn(fmt.Sprintf("zypper is running as pid %d", pid), "wait for zypper to finish, system updates will fail until then")
+		}
+
+		return pass("no package manager is running")
+	}}
+}
+
+// fcntlLockHolder returns the pid holding a write lock on path, or 0 when the file is missing or unlocked
+func fcntlLockHolder(path string) (int, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	lock := syscall.Flock_t{Type: syscall.F_WRLCK}
+	if err := syscall.FcntlFlock(file.Fd(), syscall.F_GETLK, &lock); err != nil {
+		return 0, fmt.Errorf("failed to check lock on %s: %w", path, err)
+	}
+	if lock.Type == syscall.F_UNLCK {
+		return 0, nil
+	}
+	return int(lock.Pid), nil
+}
+
+func zypperPid() int {
+	data, err := os.ReadFile(zypperPidFile)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0
+	}
+	if err := syscall.Kill(pid, syscall.Signal(0)); err != nil && !errors.Is(err, syscall.EPERM) {
+		return 0
+	}
+	return pid
+}
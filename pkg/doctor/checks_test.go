@@ -0,0 +1,87 @@
+package doctor
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed certificate and its key, returning both paths
+func writeCertificate(t *testing.T, notAfter time.Time) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "web01.example"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certPath, keyPath
+}
+
+func TestPuppetCertificate(t *testing.T) {
+	tests := []struct {
+		name     string
+		notAfter time.Time
+		expected Status
+	}{
+		{"valid", time.Now().Add(365 * 24 * time.Hour), StatusPass},
+		{"expiring", time.Now().Add(7 * 24 * time.Hour), StatusWarn},
+		{"expired", time.Now().Add(-time.Minute), StatusFail},
+	}
+
+	for _, tt := range tests {
+		certPath, _ := writeCertificate(t, tt.notAfter)
+		if result := PuppetCertificate(certPath, 30*24*time.Hour).Run(); result.Status != tt.expected {
+			t.Errorf("%s: expected %s, got %s (%s)", tt.name, tt.expected, result.Status, result.Message)
+		}
+	}
+
+	if result := PuppetCertificate(filepath.Join(t.TempDir(), "missing.pem"), time.Hour).Run(); result.Status != StatusFail {
+		t.Errorf("missing: expected fail, got %s", result.Status)
+	}
+}
+
+func TestCertificateKeyPair(t *testing.T) {
+	certPath, keyPath := writeCertificate(t, time.Now().Add(time.Hour))
+	_, otherKeyPath := writeCertificate(t, time.Now().Add(time.Hour))
+
+	if result := CertificateKeyPair(certPath, keyPath).Run(); result.Status != StatusPass {
+		t.Errorf("matching pair: expected pass, got %s (%s)", result.Status, result.Message)
+	}
+	if result := CertificateKeyPair(certPath, otherKeyPath).Run(); result.Status != StatusFail {
+		t.Errorf("mismatched pair: expected fail, got %s", result.Status)
+	}
+}
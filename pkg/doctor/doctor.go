@@ -0,0 +1,75 @@
+package doctor
+
+import (
+	"log/slog"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusPass Status = "pass"
+	StatusWarn Status = "warn"
+	StatusFail Status = "fail"
+)
+
+// Result is the outcome of a single check, Hint tells the operator how to fix a warning or failure
+type Result struct {
+	Name    string `json:"name"`
+	Status  Status `json:"status"`
+	Message string `json:"message"`
+	Hint    string `json:"hint,omitempty"`
+}
+
+// Check is a named diagnostic, Run fills in everything but the name
+type Check struct {
+	Name string
+	Run  func() Result
+}
+
+type Report struct {
+	Certname  string    `json:"certname"`
+	Timestamp time.Time `json:"timestamp"`
+	Results   []Result  `json:"results"`
+}
+
+// Run runs every check in order, a failing check doesn't stop the ones after it
+func Run(certname string, checks []Check) *Report {
+	report := &Report{
+		Certname:  certname,
+		Timestamp: time.Now().UTC(),
+		Results:   make([]Result, 0, len(checks)),
+	}
+
+	for _, check := range checks {
+		slog.Debug("running check", slog.String("check", check.Name))
+		result := check.Run()
+		result.Name = check.Name
+		report.Results = append(report.Results, result)
+	}
+
+	return report
+}
+
+// Count returns the number of results with the given status
+func (r *Report) Count(status Status) int {
+	count := 0
+	for _, result := range r.Results {
+		if result.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+func pass(message string) Result {
+	return Result{Status: StatusPass, Message: message}
+}
+
+func warn(message, hint string) Result {
+	return Result{Status: StatusWarn, Message: message, Hint: hint}
+}
+
+func fail(message, hint string) Result {
+	return Result{Status: StatusFail, Message: message, Hint: hint}
+}
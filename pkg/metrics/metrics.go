@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Textfiles picked up by the node_exporter textfile collector, one per command so
+// they never overwrite each other's values
+const (
+	PuppetRunFile    = "linuxaid_puppet.prom"
+	SystemUpdateFile = "linuxaid_system_update.prom"
+	CertificateFile  = "linuxaid_certificate.prom"
+
+	lastSuccessfulRunMetric = "linuxaid_puppet_last_successful_run_timestamp_seconds"
+)
+
+// System update outcomes
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailed  = "failed"
+	OutcomeSkipped = "skipped"
+)
+
+var outcomes = []string{OutcomeSuccess, OutcomeFailed, OutcomeSkipped}
+
+type PuppetRun struct {
+	Duration         time.Duration
+	ExitCode         int
+	ResourcesChanged int
+	ResourcesFailed  int
+	AgentDisabled    bool
+}
+
+type SystemUpdate struct {
+	Outcome       string
+	Timestamp     time.Time
+	RebootPending bool
+}
+
+// WritePuppetRun writes the puppet run metrics, the last successful run is carried
+// over from the previous textfile when this run failed
+func WritePuppetRun(run *PuppetRun) error {
+	return writePuppetRun(filepath.Join(constant.NodeExporterTextfileDir, PuppetRunFile), run, time.Now())
+}
+
+func writePuppetRun(path string, run *PuppetRun, now time.Time) error {
+	registry := prometheus.NewRegistry()
+
+	lastSuccess := readGauge(path, lastSuccessfulRunMetric)
+	if slices.Contains(constant.PuppetSuccessExitCodes, run.ExitCode) {
+		lastSuccess = float64(now.Unix())
+	}
+
+	newGauge(registry, "linuxaid_puppet_run_duration_seconds", "Duration of the last puppet run").Set(run.Duration.Seconds())
+	newGauge(registry, "linuxaid_puppet_run_exit_code", "Exit code of the last puppet run with --detailed-exitcodes").Set(float64(run.ExitCode))
+	newGauge(registry, "linuxaid_puppet_resources_changed", "Resources changed by the last puppet run").Set(float64(run.ResourcesChanged))
+	newGauge(registry, "linuxaid_puppet_resources_failed", "Resources failed in the last puppet run").Set(float64(run.ResourcesFailed))
+	newGauge(registry, "linuxaid_puppet_agent_disabled", "Whether the puppet agent is disabled").Set(boolToFloat(run.AgentDisabled))
+	newGauge(registry, "linuxaid_puppet_run_timestamp_seconds", "Time of the last puppet run").Set(float64(now.Unix()))
+
+	if lastSuccess > 0 {
+		newGauge(registry, lastSuccessfulRunMetric, "Time of the last successful puppet run").Set(lastSuccess)
+		newGauge(registry, "linuxaid_puppet_seconds_since_last_successful_run", "Seconds since the last successful puppet run, at the time of writing").
+			Set(float64(now.Unix()) - lastSuccess)
+	}
+
+	return prometheus.WriteToTextfile(path, registry)
+}
+
+// WriteSystemUpdate writes the outcome of the last system-update run
+func WriteSystemUpdate(update *SystemUpdate) error {
+	return writeSystemUpdate(filepath.Join(constant.NodeExporterTextfileDir, SystemUpdateFile), update)
+}
+
+func writeSystemUpdate(path string, update *SystemUpdate) error {
+	registry := prometheus.NewRegistry()
+
+	outcomeGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "linuxaid_system_update_last_run_outcome",
+		Help: "Outcome of the last system-update run, the current outcome is set to 1",
+	}, []string{"outcome"})
+	registry.MustRegister(outcomeGauge)
+	for _, outcome := range outcomes {
+		outcomeGauge.WithLabelValues(outcome).Set(boolToFloat(outcome == update.Outcome))
+	}
+
+	newGauge(registry, "linuxaid_system_update_last_run_timestamp_seconds", "Time of the last system-update run").Set(float64(update.Timestamp.Unix()))
+	newGauge(registry, "linuxaid_system_update_reboot_pending", "Whether a new kernel is installed but the node has not been rebooted").
+		Set(boolToFloat(update.RebootPending))
+
+	return prometheus.WriteToTextfile(path, registry)
+}
+
+// WriteCertificateExpiry exports when the puppet host certificate expires
+func WriteCertificateExpiry(notAfter time.Time) error {
+	return writeCertificateExpiry(filepath.Join(constant.NodeExporterTextfileDir, CertificateFile), notAfter)
+}
+
+func writeCertificateExpiry(path string, notAfter time.Time) error {
+	registry := prometheus.NewRegistry()
+
+	newGauge(registry, "linuxaid_puppet_certificate_expiry_timestamp_seconds", "Time the puppet host certificate expires").Set(float64(notAfter.Unix()))
+
+	return prometheus.WriteToTextfile(path, registry)
+}
+
+// RemoveCertificateExpiry removes the certificate textfile, so a renewed certificate stops alerting
+func RemoveCertificateExpiry() error {
+	err := os.Remove(filepath.Join(constant.NodeExporterTextfileDir, CertificateFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func newGauge(registry *prometheus.Registry, name, help string) prometheus.Gauge {
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: name, Help: help})
+	registry.MustRegister(gauge)
+	return gauge
+}
+
+// readGauge returns the value of a gauge from an existing textfile, or 0 if it isn't there
+func readGauge(path, name string) float64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(f)
+	if err != nil {
+		slog.Debug("failed to parse existing metrics textfile", slog.String("path", path), slog.Any("error", err))
+		return 0
+	}
+
+	family, ok := families[name]
+	if !ok || len(family.GetMetric()) == 0 {
+		return 0
+	}
+
+	return family.GetMetric()[0].GetGauge().GetValue()
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testTime = time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// assertGolden compares the textfile at path with the golden file of the same name in test/metrics
+func assertGolden(t *testing.T, path, golden string) {
+	t.Helper()
+
+	want, err := os.ReadFile(filepath.Join("../../test/metrics", golden))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("%s differs from %s:\n%s", path, golden, got)
+	}
+}
+
+func TestWritePuppetRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), PuppetRunFile)
+
+	run := &PuppetRun{Duration: 95 * time.Second, ExitCode: 2, ResourcesChanged: 3}
+	if err := writePuppetRun(path, run, testTime); err != nil {
+		t.Fatal(err)
+	}
+	assertGolden(t, path, "puppet_success.prom")
+
+	// A failed run keeps the last successful run of the textfile it replaces
+	run = &PuppetRun{Duration: 10 * time.Second, ExitCode: 4, ResourcesFailed: 1, AgentDisabled: true}
+	if err := writePuppetRun(path, run, testTime.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	assertGolden(t, path, "puppet_failed.prom")
+}
+
+func TestWriteSystemUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), SystemUpdateFile)
+
+	if err := writeSystemUpdate(path, &SystemUpdate{Outcome: OutcomeSuccess, Timestamp: testTime, RebootPending: true}); err != nil {
+		t.Fatal(err)
+	}
+	assertGolden(t, path, "system_update.prom")
+}
+
+func TestWriteCertificateExpiry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), CertificateFile)
+
+	if err := writeCertificateExpiry(path, testTime); err != nil {
+		t.Fatal(err)
+	}
+	assertGolden(t, path, "certificate.prom")
+}
+
+func TestWriteIsAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, CertificateFile)
+	if err := os.WriteFile(path, []byte("# previous textfile\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// node_exporter may have the old textfile open while we write
+	previous, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// nolint: errcheck
+	defer previous.Close()
+
+	if err := writeCertificateExpiry(path, testTime); err != nil {
+		t.Fatal(err)
+	}
+
+	// The new textfile is renamed over the old one, a reader never sees it half written
+	data, err := io.ReadAll(previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "# previous textfile\n" {
+		t.Errorf("the open textfile was written in place: %q", data)
+	}
+	assertGolden(t, path, "certificate.prom")
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		t.Errorf("expected only the textfile to be left, got: %v", names)
+	}
+}
@@ -10,10 +10,37 @@ type UpdateScriptFailureInput struct {
 }
 
 type PuppetLastRunReport struct {
-	Time                        string `yaml:"time" json:"time"`
-	Status                      string `yaml:"status" json:"status"`
-	TransactionCompleted        bool   `yaml:"transaction_completed" json:"transaction_completed"`
-	IsLastRunYamlFileNotPresent bool   `yaml:"-" json:"is_last_run_yaml_file_not_present"`
+	Time                        string             `json:"time"`
+	Status                      string             `json:"status"`
+	TransactionCompleted        bool               `json:"transaction_completed"`
+	IsLastRunYamlFileNotPresent bool               `json:"is_last_run_yaml_file_not_present"`
+	ConfigurationVersion        string             `json:"configuration_version,omitempty"`
+	Environment                 string             `json:"environment,omitempty"`
+	PuppetVersion               string             `json:"puppet_version,omitempty"`
+	Noop                        bool               `json:"noop"`
+	Resources                   map[string]int     `json:"resources,omitempty"`
+	Changes                     map[string]int     `json:"changes,omitempty"`
+	Events                      map[string]int     `json:"events,omitempty"`
+	Timing                      map[string]float64 `json:"timing,omitempty"`
+	FailedResources             []PuppetResource   `json:"failed_resources"`
+	ChangedResources            []PuppetResource   `json:"changed_resources"`
+	OutOfSyncResources          []PuppetResource   `json:"out_of_sync_resources"`
+}
+
+type PuppetResource struct {
+	Resource string                `json:"resource"`
+	Type     string                `json:"type"`
+	Title    string                `json:"title"`
+	File     string                `json:"file,omitempty"`
+	Line     int                   `json:"line,omitempty"`
+	Events   []PuppetResourceEvent `json:"events"`
+}
+
+type PuppetResourceEvent struct {
+	Property string `json:"property"`
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Message  string `json:"message"`
 }
 
 type ObmondoAPIResponse[T any] struct {
@@ -25,6 +52,10 @@ type ObmondoAPIResponse[T any] struct {
 	ErrorText  string `json:"error_text"`
 }
 
+type PuppetRunMode struct {
+	Mode string `json:"mode"`
+}
+
 type ServiceWindow struct {
 	IsWindowOpen bool   `json:"is_window_open"`
 	WindowType   string `json:"window_type"`
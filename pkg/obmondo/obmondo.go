@@ -10,13 +10,12 @@ import (
 	"log/slog"
 	"net/http"
 	"net/url"
-	"os"
 	"time"
 
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/config"
 	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
 	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper"
 	"gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/prettyfmt"
-	"gopkg.in/yaml.v3"
 )
 
 const (
@@ -32,7 +31,9 @@ type ObmondoClient interface {
 	VerifyInstallToken(input *InstallScriptInput) error
 	NotifyInstallScriptFailure(input *InstallScriptInput) error
 	ServerPing() error
-	UpdatePuppetLastRunReport() error
+	UpdatePuppetLastRunReport(report *PuppetLastRunReport) error
+	GetPuppetRunMode() (string, error)
+	NotifyDecommission() error
 }
 
 type obmondoClient struct {
@@ -91,10 +92,11 @@ func (c *obmondoClient) VerifyInstallToken(input *InstallScriptInput) error {
 	}
 }
 
-func (c *obmondoClient) UpdatePuppetLastRunReport() error {
+func (c *obmondoClient) UpdatePuppetLastRunReport(report *PuppetLastRunReport) error {
 	url := fmt.Sprintf("%s/servers/puppet_last_run_report", c.apiURL)
-	data, err := c.readPuppetLastRunReport()
+	data, err := json.Marshal(report)
 	if err != nil {
+		slog.Error("failed to marshal last run report into json", slog.Any("error", err))
 		return err
 	}
 
@@ -131,35 +133,34 @@ func (c *obmondoClient) UpdatePuppetLastRunReport() error {
 	return nil
 }
 
-func (*obmondoClient) readPuppetLastRunReport() ([]byte, error) {
-	const lastRunStatus = "/opt/puppetlabs/puppet/cache/state/last_run_report.yaml"
-
-	var puppetLastRunReport PuppetLastRunReport
-	puppetLastRunReport.IsLastRunYamlFileNotPresent = true
+// GetPuppetRunMode asks the API whether this server should run puppet in noop or enforce mode
+func (c *obmondoClient) GetPuppetRunMode() (string, error) {
+	url := fmt.Sprintf("%s/servers/puppet_run_mode", c.apiURL)
 
-	if _, err := os.Stat(lastRunStatus); err == nil {
-		data, err := os.ReadFile(lastRunStatus)
-		if err != nil {
-			slog.Error("failed to read last run report", slog.Any("error", err))
-			return nil, err
-		}
-		if err := yaml.Unmarshal(data, &puppetLastRunReport); err != nil {
-			slog.Error("failed to unmarshal last run report yaml", slog.Any("error", err))
-			return nil, err
-		}
-		puppetLastRunReport.IsLastRunYamlFileNotPresent = false
-	} else if !os.IsNotExist(err) {
-		slog.Error("failed to stat last run report", slog.Any("error", err))
-		return nil, err
+	resp, err := c.apiCallWithTransport(url, nil, http.MethodGet)
+	if err != nil {
+		slog.Error("error occurred while trying to fetch the puppet run mode", slog.Any("error", err), slog.String("url", url))
+		return "", err
 	}
 
-	data, err := json.Marshal(&puppetLastRunReport)
+	statusCode, responseBody, err := helper.ParseResponse(resp)
 	if err != nil {
-		slog.Error("failed to marshal last run report into json", slog.Any("error", err))
-		return nil, err
+		slog.Error("unexpected error reading response body", slog.String("error", err.Error()))
+		return "", err
+	}
+
+	if statusCode != http.StatusOK {
+		slog.Error("unexpected", slog.Int("status_code", statusCode), slog.String("response", string(responseBody)))
+		return "", fmt.Errorf("unexpected non-200 HTTP status code received: %d", statusCode)
 	}
 
-	return data, nil
+	apiResponse := &ObmondoAPIResponse[PuppetRunMode]{}
+	if err := json.Unmarshal(responseBody, apiResponse); err != nil {
+		slog.Error("failed to parse puppet run mode JSON", slog.String("error", err.Error()))
+		return "", err
+	}
+
+	return apiResponse.Data.Mode, nil
 }
 
 func (c *obmondoClient) ServerPing() error {
@@ -183,6 +184,35 @@ func (c *obmondoClient) ServerPing() error {
 	return nil
 }
 
+// NotifyDecommission tells Obmondo the server is being removed, it has to happen while the puppet certificate is still there
+func (c *obmondoClient) NotifyDecommission() error {
+	url := fmt.Sprintf("%s/servers/decommission", c.apiURL)
+
+	resp, err := c.apiCallWithTransport(url, nil, http.MethodPut)
+	defer func() {
+		if resp != nil && resp.Body != nil {
+			if cerr := resp.Body.Close(); cerr != nil {
+				slog.Error("failed to close body", slog.Any("error", cerr))
+			}
+		}
+	}()
+	if err != nil {
+		slog.Error("error occurred while trying to inform obmondo about the decommission",
+			slog.Any("error", err), slog.String("url", url))
+		return err
+	}
+
+	// An API without the endpoint must not look like a server it doesn't know, --force is the way out
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed {
+		return fmt.Errorf("%s is not available on the Obmondo API (status %d)", url, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("decommission notification failed with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
 func (c *obmondoClient) NotifyInstallScriptFailure(input *InstallScriptInput) error {
 	if !c.notifyInstallScriptFailure {
 		return nil
@@ -373,14 +403,18 @@ func NewObmondoClient(obmondoAPIURL string, notifyInstallScriptFailure bool) Obm
 	return &obmondoClient{
 		apiURL:                     obmondoAPIURL,
 		notifyInstallScriptFailure: notifyInstallScriptFailure,
-		certPath:                   fmt.Sprintf("/etc/puppetlabs/puppet/ssl/certs/%s.pem", certname),
+		certPath:                   fmt.Sprintf("%s/%s.pem", constant.PuppetCertsPath, certname),
 		keyPath:                    fmt.Sprintf("%s/%s.pem", constant.PuppetPrivKeyPath, certname),
 	}
 }
 
 func GetObmondoURL() string {
+	if apiURL := config.GetAPIURL(); apiURL != "" {
+		return apiURL
+	}
+
 	obmondoAPIURL := obmondoProdAPIURL
-	if os.Getenv(constant.ObmondoEnv) == "1" {
+	if config.Getenv(constant.ObmondoEnv) == "1" {
 		obmondoAPIURL = obmondoBetaAPIURL
 	}
 
@@ -2,11 +2,14 @@ package prettyfmt
 
 import (
 	"fmt"
+	"io"
+	"os"
 
 	"github.com/fatih/color"
 )
 
-const (
+// nolint: revive
+var (
 	IconGear      = "⚙️"
 	IconCheckFail = "❌"
 	IconCheckPass = "✔"
@@ -17,6 +20,8 @@ const (
 // nolint: revive
 var FontRed, FontGreen, FontBlue, FontWhite, FontYellow func(a ...any) string
 
+var output io.Writer = os.Stdout
+
 func init() {
 	FontGreen = color.New(color.FgGreen).SprintFunc()
 	FontBlue = color.New(color.FgBlue).SprintFunc()
@@ -25,12 +30,27 @@ func init() {
 	FontRed = color.New(color.FgRed).SprintFunc()
 }
 
+// SetPlain drops colors and swaps the emoji for ASCII, for logs and terminals that can't show them
+func SetPlain() {
+	color.NoColor = true
+	IconGear = "*"
+	IconCheckFail = "[FAIL]"
+	IconCheckPass = "[ OK ]"
+	IconSuccess = "*"
+	IconQuestion = "?"
+}
+
+// SetOutput sends the pretty output somewhere else than stdout, e.g. to keep stdout for JSON
+func SetOutput(w io.Writer) {
+	output = w
+}
+
 func PrettyPrintf(format string, a ...any) {
 	//nolint:forbidigo
-	fmt.Printf(format, a...) //nolint:revive
+	fmt.Fprintf(output, format, a...) //nolint:revive,errcheck
 }
 
 func PrettyPrintln(a ...any) {
 	//nolint:forbidigo
-	fmt.Println(a...) //nolint:revive
+	fmt.Fprintln(output, a...) //nolint:revive,errcheck
 }
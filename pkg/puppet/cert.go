@@ -0,0 +1,123 @@
+package puppet
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper"
+)
+
+const (
+	certificateRenewalTimeout = 30 * time.Second
+	// A certificate is a couple of KB, anything bigger isn't what we asked for
+	maxCertificateSize = 1 << 20
+)
+
+// RenewCertificate asks the puppet CA for a new host certificate, authenticating with the current one.
+// The new certificate only replaces the old one after it loads together with the private key.
+func (s *Service) RenewCertificate() (*x509.Certificate, error) {
+	certPath, keyPath := helper.PuppetCertPaths(s.certName)
+
+	current, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the current certificate: %w", err)
+	}
+
+	caCert, err := os.ReadFile(constant.PuppetCACertFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the puppet CA certificate: %w", err)
+	}
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("no certificates found in %s", constant.PuppetCACertFile)
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				Certificates: []tls.Certificate{current},
+				RootCAs:      caPool,
+			},
+		},
+		Timeout: certificateRenewalTimeout,
+	}
+
+	url := fmt.Sprintf("https://%s/puppet-ca/v1/certificate_renewal", s.puppetServer)
+	slog.Info("requesting certificate renewal", slog.String("url", url))
+
+	resp, err := client.Post(url, "text/plain", http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("certificate renewal request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCertificateSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the renewed certificate: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("certificate renewal failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	block, _ := pem.Decode(body)
+	if block == nil {
+		return nil, errors.New("puppet CA did not return a PEM certificate")
+	}
+	renewed, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the renewed certificate: %w", err)
+	}
+	if renewed.Subject.CommonName != s.certName {
+		return nil, fmt.Errorf("renewed certificate is for %s, expected %s", renewed.Subject.CommonName, s.certName)
+	}
+
+	if err := replaceCertificate(certPath, keyPath, body); err != nil {
+		return nil, err
+	}
+
+	return renewed, nil
+}
+
+// replaceCertificate writes the certificate next to the old one and only renames it into place
+// once tls.LoadX509KeyPair accepts it with the private key
+func replaceCertificate(certPath, keyPath string, cert []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(certPath), ".renewed-*.pem")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary certificate: %w", err)
+	}
+	// nolint: errcheck
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(cert); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary certificate: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write temporary certificate: %w", err)
+	}
+	// nolint: mnd
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+
+	if _, err := tls.LoadX509KeyPair(tmp.Name(), keyPath); err != nil {
+		return fmt.Errorf("renewed certificate doesn't match the private key: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), certPath); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", certPath, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package puppet
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newCertificate(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "web01.example"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func TestReplaceCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "web01.example.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+
+	oldCert, key := newCertificate(t)
+	if err := os.WriteFile(certPath, oldCert, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, key, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A certificate for another key must never replace the working one
+	otherCert, _ := newCertificate(t)
+	if err := replaceCertificate(certPath, keyPath, otherCert); err == nil {
+		t.Fatal("expected a certificate for another key to be rejected")
+	}
+	if current, _ := os.ReadFile(certPath); !bytes.Equal(current, oldCert) {
+		t.Fatal("certificate was replaced by one that doesn't match the key")
+	}
+
+	// Re-issuing for the same key is what the CA does on renewal
+	if err := replaceCertificate(certPath, keyPath, oldCert); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("temporary certificate left behind: %v", entries)
+	}
+}
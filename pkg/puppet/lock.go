@@ -0,0 +1,112 @@
+package puppet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
+)
+
+// AgentStatus describes the state of the local puppet agent as recorded in its lock files
+type AgentStatus struct {
+	Running         bool   `json:"running"`
+	PID             int    `json:"pid,omitempty"`
+	Disabled        bool   `json:"disabled"`
+	DisabledMessage string `json:"disabled_message,omitempty"`
+}
+
+// agentDisabledLock is the JSON document puppet writes on `puppet agent --disable`
+type agentDisabledLock struct {
+	DisabledMessage string `json:"disabled_message"`
+}
+
+// Status reads the agent lock files and reports whether the agent is running or disabled
+func (*Service) Status() (*AgentStatus, error) {
+	return readAgentStatus(constant.AgentDisabledLockFile, constant.AgentRunningLockFile)
+}
+
+func readAgentStatus(disabledLockFile, runningLockFile string) (*AgentStatus, error) {
+	status := &AgentStatus{}
+
+	disabled, msg, err := readDisabledLock(disabledLockFile)
+	if err != nil {
+		return nil, err
+	}
+	status.Disabled = disabled
+	status.DisabledMessage = msg
+
+	pid, err := readRunningLock(runningLockFile)
+	if err != nil {
+		return nil, err
+	}
+	if pid > 0 && isProcessAlive(pid) {
+		status.Running = true
+		status.PID = pid
+	}
+
+	return status, nil
+}
+
+// StaleRunningLock returns the pid in a catalog run lock whose process is gone, or 0 if there is no such lock.
+// Puppet cleans these up itself on the next run, but until then IsAgentRunning can't be trusted blindly.
+func (*Service) StaleRunningLock() (int, error) {
+	pid, err := readRunningLock(constant.AgentRunningLockFile)
+	if err != nil || pid == 0 || isProcessAlive(pid) {
+		return 0, err
+	}
+	return pid, nil
+}
+
+// readDisabledLock returns whether the agent is disabled and the reason given when it was disabled
+func readDisabledLock(path string) (bool, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, "", nil
+		}
+		return false, "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	// Puppet only looks at the presence of the file, so an empty or
+	// unparsable lock still means the agent is disabled
+	var lock agentDisabledLock
+	if err := json.Unmarshal(data, &lock); err != nil {
+		slog.Debug("failed to parse agent disabled lock", slog.String("path", path), slog.Any("error", err))
+	}
+
+	return true, lock.DisabledMessage, nil
+}
+
+// readRunningLock returns the PID recorded in the catalog run lock, or 0 if there is none or it holds no valid pid
+func readRunningLock(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	// Puppet may be in the middle of writing the lock, or it got truncated by a crash.
+	// Without a pid there's no process to wait for, so it counts as stale.
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		slog.Debug("ignoring catalog run lock without a valid pid", slog.String("path", path), slog.String("content", string(data)))
+		return 0, nil
+	}
+
+	return pid, nil
+}
+
+// isProcessAlive sends signal 0 to the pid, which only checks for existence and permissions
+func isProcessAlive(pid int) bool {
+	err := syscall.Kill(pid, syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
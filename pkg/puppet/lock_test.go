@@ -0,0 +1,55 @@
+package puppet
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestReadAgentStatus(t *testing.T) {
+	dir := t.TempDir()
+	disabledLock := filepath.Join(dir, "agent_disabled.lock")
+	runningLock := filepath.Join(dir, "agent_catalog_run.lock")
+
+	status, err := readAgentStatus(disabledLock, runningLock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Running || status.Disabled {
+		t.Errorf("expected idle agent without lock files, got: %+v", status)
+	}
+
+	if err := os.WriteFile(disabledLock, []byte(`{"disabled_message":"puppet has been disabled by the system-update"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(runningLock, []byte(strconv.Itoa(os.Getpid())), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err = readAgentStatus(disabledLock, runningLock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Disabled || status.DisabledMessage != "puppet has been disabled by the system-update" {
+		t.Errorf("expected disabled agent with message, got: %+v", status)
+	}
+	if !status.Running || status.PID != os.Getpid() {
+		t.Errorf("expected running agent with pid %d, got: %+v", os.Getpid(), status)
+	}
+}
+
+func TestReadRunningLockWithoutPID(t *testing.T) {
+	runningLock := filepath.Join(t.TempDir(), "agent_catalog_run.lock")
+
+	for _, content := range []string{"", "\n", "12ab", "garbage", "-1"} {
+		if err := os.WriteFile(runningLock, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		pid, err := readRunningLock(runningLock)
+		if err != nil || pid != 0 {
+			t.Errorf("lock %q: expected no pid and no error, got pid %d and error %v", content, pid, err)
+		}
+	}
+}
@@ -2,17 +2,15 @@ package puppet
 
 import (
 	"crypto/tls"
-	"errors"
 	"fmt"
 	"io"
-	"io/fs"
 	"log/slog"
 	"net/http"
 	"os"
-	"slices"
+	"os/exec"
+	"strings"
 	"time"
 
-	"gitea.obmondo.com/EnableIT/linuxaid-cli/config"
 	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
 	"gitea.obmondo.com/EnableIT/linuxaid-cli/helper"
 	api "gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/obmondo"
@@ -34,83 +32,109 @@ func NewService(apiClient api.ObmondoClient, webtee *webtee.Webtee) *Service {
 	return &Service{
 		apiClient:    apiClient,
 		certName:     helper.GetCertname(),
-		puppetServer: config.GetPupeptServer(),
+		puppetServer: helper.GetPuppetServer(),
 		webtee:       webtee,
 	}
 }
 
 // Enable agent
 func (*Service) EnableAgent() error {
-	pipe := script.Exec("puppet agent --enable")
-	if err := pipe.Wait(); err != nil {
-		return fmt.Errorf("failed to enable puppet agent: %w", err)
-	}
-	if pipe.ExitStatus() != 0 {
-		return fmt.Errorf("puppet agent enable exited with non-zero status")
+	// Arguments are passed straight to exec, so nothing goes through a shell
+	if out, err := exec.Command("puppet", "agent", "--enable").CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to enable puppet agent: %w: %s", err, strings.TrimSpace(string(out)))
 	}
 	slog.Info("successfully enabled puppet")
 	return nil
 }
 
 // Disable puppet-agent service (sanity-check)
-func (s *Service) DisableAgentService() {
-	// Disable unattended-upgrades so puppet-agent package does not update
-	s.webtee.RemoteLogObmondo([]string{
+func (s *Service) DisableAgentService() error {
+	commands := []string{
+		// Disable unattended-upgrades so puppet-agent package does not update
 		"puppet resource service unattended-upgrades ensure=stopped enable=false",
-	}, s.certName)
-
-	// Stop puppet agent service, since we manage it via run_puppet service
-	s.webtee.RemoteLogObmondo([]string{
+		// Stop puppet agent service, since we manage it via run_puppet service
 		"puppet resource service puppet ensure=stopped enable=false",
-	}, s.certName)
+	}
+	for _, command := range commands {
+		exitCode, err := s.webtee.Stream([]string{command}, s.certName, nil)
+		if err != nil {
+			return fmt.Errorf("%q failed: %w", command, err)
+		}
+		if exitCode != 0 {
+			return fmt.Errorf("%q failed with exit code %d", command, exitCode)
+		}
+	}
 
 	slog.Debug("puppet agent service disabled")
+	return nil
 }
 
 // Disable agent with message
 func (*Service) DisableAgent(msg string) error {
-	cmd := fmt.Sprintf("puppet agent --disable '%s'", msg)
-	pipe := script.Exec(cmd)
-	if err := pipe.Wait(); err != nil {
-		return fmt.Errorf("failed to disable puppet agent: %w", err)
-	}
-	if pipe.ExitStatus() != 0 {
-		return fmt.Errorf("puppet agent disable exited with non-zero status")
+	// The message is a single argv entry, so quotes in it can't break the command
+	if out, err := exec.Command("puppet", "agent", "--disable", msg).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to disable puppet agent: %w: %s", err, strings.TrimSpace(string(out)))
 	}
 	slog.Info("successfully disabled puppet")
 	return nil
 }
 
-// Run agent
-func (s *Service) RunAgent(remoteLog bool, noopMode string) int {
-	cmd := fmt.Sprintf("puppet agent -t --%s --detailed-exitcodes", noopMode)
-	if remoteLog {
-		s.webtee.RemoteLogObmondo([]string{cmd}, s.certName)
-		return 0
+// Run agent, returns the --detailed-exitcodes exit code of the run
+func (s *Service) RunAgent(opts *RunOptions) int {
+	if err := opts.Validate(); err != nil {
+		slog.Error("invalid puppet agent options", slog.Any("error", err))
+		return ExitCodeFailed
 	}
 
-	slog.Info("running puppet agent", slog.String("mode", noopMode))
-	pipe := script.Exec(cmd)
-	if _, err := pipe.Stdout(); err != nil {
-		if !slices.Contains(constant.PuppetSuccessExitCodes, pipe.ExitStatus()) {
-			slog.Error("stdout error", slog.Any("error", err))
+	args := opts.args()
+	slog.Info("running puppet agent", slog.String("mode", string(opts.Mode)), slog.String("command", strings.Join(args, " ")))
+
+	if opts.RemoteLog {
+		exitCode, err := s.webtee.Stream(args, s.certName, opts.Output)
+		if err != nil {
+			slog.Error("failed to run puppet agent", slog.Any("error", err))
+			return ExitCodeFailed
 		}
+		return exitCode
+	}
+
+	output := opts.Output
+	if output == nil {
+		output = os.Stdout
 	}
 
-	return pipe.ExitStatus()
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	err := cmd.Run()
+	if cmd.ProcessState == nil {
+		slog.Error("failed to run puppet agent", slog.Any("error", err))
+		return ExitCodeFailed
+	}
+
+	exitCode := cmd.ProcessState.ExitCode()
+	if err != nil && !IsSuccessExitCode(exitCode) {
+		slog.Error("puppet agent run failed", slog.Any("error", err))
+	}
+
+	return exitCode
 }
 
-// Check if agent is running
+// Check if agent is running, a lock left behind by a dead process doesn't count
 func (s *Service) IsAgentRunning() bool {
-	_, err := os.Stat(constant.AgentRunningLockFile)
+	pid, err := readRunningLock(constant.AgentRunningLockFile)
 	if err != nil {
-		if errors.Is(err, fs.ErrNotExist) {
-			slog.Debug("puppet lock file not found")
-			s.webtee.RemoteLogObmondo([]string{"echo lock file not found"}, s.certName)
-			return false
-		}
 		slog.Debug("error checking lock file", slog.Any("error", err))
-		s.webtee.RemoteLogObmondo([]string{"echo error checking lock file"}, s.certName)
+		s.webtee.Send([]string{"error checking lock file"}, s.certName)
+		return false
+	}
+	if pid == 0 {
+		slog.Debug("puppet lock file not found")
+		s.webtee.Send([]string{"lock file not found"}, s.certName)
+		return false
+	}
+	if !isProcessAlive(pid) {
+		slog.Debug("puppet lock file is stale", slog.Int("pid", pid))
 		return false
 	}
 	return true
@@ -129,7 +153,7 @@ func (s *Service) WaitForAgent(timeoutSeconds int) {
 }
 
 // Configure agent
-func (s *Service) ConfigureAgent() {
+func (s *Service) ConfigureAgent() error {
 	cfg := `[main]
 server = %s
 certname = %s
@@ -144,9 +168,10 @@ environment = master
 `
 	content := fmt.Sprintf(cfg, s.puppetServer, s.certName)
 	if _, err := script.Echo(content).WriteFile(constant.PuppetConfig); err != nil {
-		s.webtee.RemoteLogObmondo([]string{fmt.Sprintf("echo failed to configure puppet: %s", err)}, s.certName)
-		os.Exit(1)
+		s.webtee.Send([]string{fmt.Sprintf("failed to configure puppet: %s", err)}, s.certName)
+		return fmt.Errorf("failed to write %s: %w", constant.PuppetConfig, err)
 	}
+	return nil
 }
 
 // Check server status
@@ -162,7 +187,7 @@ func (s *Service) CheckServerStatus() error {
 
 	resp, err := client.Get(url)
 	if err != nil {
-		s.webtee.RemoteLogObmondo([]string{fmt.Sprintf("echo failed to reach Puppet server: %s", err)}, s.certName)
+		s.webtee.Send([]string{fmt.Sprintf("failed to reach Puppet server: %s", err)}, s.certName)
 		return err
 	}
 	defer resp.Body.Close()
@@ -182,7 +207,7 @@ func (s *Service) DownloadAgent(downloadPath, url string) error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		s.webtee.RemoteLogObmondo([]string{"echo deb file not present at url"}, url)
+		s.webtee.Send([]string{"agent package not present at " + url}, s.certName)
 		return fmt.Errorf("puppet agent download failed with status %d", resp.StatusCode)
 	}
 
@@ -198,7 +223,7 @@ func (s *Service) DownloadAgent(downloadPath, url string) error {
 	return nil
 }
 
-func (s *Service) FacterNewSetup() {
+func (s *Service) FacterNewSetup() error {
 	// Ensure facts.d directory exists
 	if _, err := script.Exec("mkdir -p /etc/puppetlabs/facter/facts.d").Stdout(); err != nil {
 		slog.Error("failed to create facts directory", slog.Any("error", err))
@@ -218,10 +243,10 @@ func (s *Service) FacterNewSetup() {
 			slog.String("file_path", constant.ExternalFacterFile),
 			slog.Any("error", err),
 		)
-		errMsg := fmt.Sprintf("echo cannot create external facter file: %s", err.Error())
-		s.webtee.RemoteLogObmondo([]string{errMsg}, s.certName)
-		os.Exit(1)
+		s.webtee.Send([]string{fmt.Sprintf("cannot create external facter file: %s", err)}, s.certName)
+		return fmt.Errorf("cannot create external facter file: %w", err)
 	}
 
 	slog.Debug("facter external setup file created", slog.String("path", constant.ExternalFacterFile))
+	return nil
 }
@@ -0,0 +1,255 @@
+package puppet
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sort"
+	"time"
+
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
+	api "gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/obmondo"
+
+	"gopkg.in/yaml.v3"
+)
+
+// summaryLastRunKey is the epoch timestamp puppet stores alongside the per phase timings
+const summaryLastRunKey = "last_run"
+
+// RunReport is the subset of last_run_report.yaml (Puppet::Transaction::Report) we care about
+type RunReport struct {
+	Host                 string                    `yaml:"host"`
+	Time                 string                    `yaml:"time"`
+	ConfigurationVersion string                    `yaml:"configuration_version"`
+	TransactionUUID      string                    `yaml:"transaction_uuid"`
+	ReportFormat         int                       `yaml:"report_format"`
+	PuppetVersion        string                    `yaml:"puppet_version"`
+	Status               string                    `yaml:"status"`
+	TransactionCompleted bool                      `yaml:"transaction_completed"`
+	Noop                 bool                      `yaml:"noop"`
+	NoopPending          bool                      `yaml:"noop_pending"`
+	Environment          string                    `yaml:"environment"`
+	CachedCatalogStatus  string                    `yaml:"cached_catalog_status"`
+	ServerUsed           string                    `yaml:"server_used"`
+	ResourceStatuses     map[string]ResourceStatus `yaml:"resource_statuses"`
+}
+
+// ResourceStatus is a single entry of resource_statuses in the run report
+type ResourceStatus struct {
+	Title            string          `yaml:"title"`
+	Resource         string          `yaml:"resource"`
+	ResourceType     string          `yaml:"resource_type"`
+	File             string          `yaml:"file"`
+	Line             int             `yaml:"line"`
+	EvaluationTime   float64         `yaml:"evaluation_time"`
+	Failed           bool            `yaml:"failed"`
+	FailedToRestart  bool            `yaml:"failed_to_restart"`
+	Changed          bool            `yaml:"changed"`
+	OutOfSync        bool            `yaml:"out_of_sync"`
+	Skipped          bool            `yaml:"skipped"`
+	CorrectiveChange bool            `yaml:"corrective_change"`
+	Events           []ResourceEvent `yaml:"events"`
+}
+
+// ResourceEvent is a property change (or attempted change) recorded for a resource
+type ResourceEvent struct {
+	Property         string `yaml:"property"`
+	Name             string `yaml:"name"`
+	Status           string `yaml:"status"`
+	Message          string `yaml:"message"`
+	CorrectiveChange bool   `yaml:"corrective_change"`
+}
+
+// RunSummary is last_run_summary.yaml, which holds the aggregated counters of the last run
+type RunSummary struct {
+	Version   SummaryVersion     `yaml:"version"`
+	Resources map[string]int     `yaml:"resources"`
+	Time      map[string]float64 `yaml:"time"`
+	Changes   map[string]int     `yaml:"changes"`
+	Events    map[string]int     `yaml:"events"`
+}
+
+type SummaryVersion struct {
+	Config string `yaml:"config"`
+	Puppet string `yaml:"puppet"`
+}
+
+// LastRun bundles the report and the summary, either of them is nil when the file is missing
+type LastRun struct {
+	Report  *RunReport
+	Summary *RunSummary
+}
+
+// ParseRunReport parses a puppet last_run_report.yaml
+func ParseRunReport(path string) (*RunReport, error) {
+	report := &RunReport{}
+	if err := readYAMLFile(path, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// ParseRunSummary parses a puppet last_run_summary.yaml
+func ParseRunSummary(path string) (*RunSummary, error) {
+	summary := &RunSummary{}
+	if err := readYAMLFile(path, summary); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+func readYAMLFile(path string, out any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return nil
+}
+
+// ReadLastRun reads the report and summary of the last puppet run, missing files are not an error
+func ReadLastRun() (*LastRun, error) {
+	lastRun := &LastRun{}
+
+	report, err := ParseRunReport(constant.PuppetLastRunReportFile)
+	switch {
+	case err == nil:
+		lastRun.Report = report
+	case errors.Is(err, fs.ErrNotExist):
+		slog.Debug("puppet last run report not found", slog.String("path", constant.PuppetLastRunReportFile))
+	default:
+		return nil, err
+	}
+
+	summary, err := ParseRunSummary(constant.PuppetLastRunSummaryFile)
+	switch {
+	case err == nil:
+		lastRun.Summary = summary
+	case errors.Is(err, fs.ErrNotExist):
+		slog.Debug("puppet last run summary not found", slog.String("path", constant.PuppetLastRunSummaryFile))
+	default:
+		return nil, err
+	}
+
+	return lastRun, nil
+}
+
+// LastRunAt returns when the last run happened according to the summary
+func (s *RunSummary) LastRunAt() time.Time {
+	lastRun, ok := s.Time[summaryLastRunKey]
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(int64(lastRun), 0)
+}
+
+// Timing returns the duration of every phase, without the last_run timestamp
+func (s *RunSummary) Timing() map[string]float64 {
+	timing := make(map[string]float64, len(s.Time))
+	for phase, seconds := range s.Time {
+		if phase == summaryLastRunKey {
+			continue
+		}
+		timing[phase] = seconds
+	}
+	return timing
+}
+
+// FailedResources returns the resources which failed, sorted by name
+func (r *RunReport) FailedResources() []ResourceStatus {
+	return r.filterResources(func(rs ResourceStatus) bool { return rs.Failed || rs.FailedToRestart })
+}
+
+// ChangedResources returns the resources which were changed, sorted by name
+func (r *RunReport) ChangedResources() []ResourceStatus {
+	return r.filterResources(func(rs ResourceStatus) bool { return rs.Changed })
+}
+
+// OutOfSyncResources returns the resources which are not in their desired state, sorted by name
+func (r *RunReport) OutOfSyncResources() []ResourceStatus {
+	return r.filterResources(func(rs ResourceStatus) bool { return rs.OutOfSync })
+}
+
+func (r *RunReport) filterResources(match func(ResourceStatus) bool) []ResourceStatus {
+	var resources []ResourceStatus
+	for _, rs := range r.ResourceStatuses {
+		if match(rs) {
+			resources = append(resources, rs)
+		}
+	}
+	sort.Slice(resources, func(i, j int) bool { return resources[i].Resource < resources[j].Resource })
+	return resources
+}
+
+// APIReport converts the last run into the payload expected by the Obmondo API
+func (l *LastRun) APIReport() *api.PuppetLastRunReport {
+	apiReport := &api.PuppetLastRunReport{
+		IsLastRunYamlFileNotPresent: l.Report == nil,
+	}
+
+	if l.Report != nil {
+		apiReport.Time = l.Report.Time
+		apiReport.Status = l.Report.Status
+		apiReport.TransactionCompleted = l.Report.TransactionCompleted
+		apiReport.ConfigurationVersion = l.Report.ConfigurationVersion
+		apiReport.Environment = l.Report.Environment
+		apiReport.PuppetVersion = l.Report.PuppetVersion
+		apiReport.Noop = l.Report.Noop
+		apiReport.FailedResources = toAPIResources(l.Report.FailedResources())
+		apiReport.ChangedResources = toAPIResources(l.Report.ChangedResources())
+		apiReport.OutOfSyncResources = toAPIResources(l.Report.OutOfSyncResources())
+	}
+
+	if l.Summary != nil {
+		apiReport.Resources = l.Summary.Resources
+		apiReport.Changes = l.Summary.Changes
+		apiReport.Events = l.Summary.Events
+		apiReport.Timing = l.Summary.Timing()
+	}
+
+	return apiReport
+}
+
+func toAPIResources(resources []ResourceStatus) []api.PuppetResource {
+	apiResources := make([]api.PuppetResource, 0, len(resources))
+	for _, rs := range resources {
+		events := make([]api.PuppetResourceEvent, 0, len(rs.Events))
+		for _, event := range rs.Events {
+			events = append(events, api.PuppetResourceEvent{
+				Property: event.Property,
+				Name:     event.Name,
+				Status:   event.Status,
+				Message:  event.Message,
+			})
+		}
+
+		apiResources = append(apiResources, api.PuppetResource{
+			Resource: rs.Resource,
+			Type:     rs.ResourceType,
+			Title:    rs.Title,
+			File:     rs.File,
+			Line:     rs.Line,
+			Events:   events,
+		})
+	}
+	return apiResources
+}
+
+// UpdateLastRunReport reads the last puppet run and reports it to the Obmondo API
+func (s *Service) UpdateLastRunReport() (*LastRun, error) {
+	lastRun, err := ReadLastRun()
+	if err != nil {
+		slog.Error("failed to read puppet last run", slog.Any("error", err))
+		return nil, err
+	}
+
+	if err := s.apiClient.UpdatePuppetLastRunReport(lastRun.APIReport()); err != nil {
+		return lastRun, err
+	}
+
+	return lastRun, nil
+}
@@ -0,0 +1,51 @@
+package puppet
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestParseLastRun(t *testing.T) {
+	testDirectory, err := filepath.Abs("../../test/puppet/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := ParseRunReport(filepath.Join(testDirectory, "last_run_report.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary, err := ParseRunSummary(filepath.Join(testDirectory, "last_run_summary.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Status != "failed" || report.ConfigurationVersion != "1741083302" || report.Environment != "master" {
+		t.Errorf("unexpected report header: %+v", report)
+	}
+
+	failed := report.FailedResources()
+	if len(failed) != 1 || failed[0].Resource != "Service[nginx]" || failed[0].Events[0].Status != "failure" {
+		t.Errorf("expected Service[nginx] to be the only failed resource, got: %+v", failed)
+	}
+
+	outOfSync := report.OutOfSyncResources()
+	if len(outOfSync) != 2 || outOfSync[0].Resource != "File[/etc/motd]" {
+		t.Errorf("expected two out of sync resources sorted by name, got: %+v", outOfSync)
+	}
+
+	if summary.Resources["total"] != 3 || summary.Events["failure"] != 1 || summary.Version.Puppet != "8.23.1" {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+	if _, ok := summary.Timing()[summaryLastRunKey]; ok {
+		t.Errorf("timing should not contain %s", summaryLastRunKey)
+	}
+	if summary.LastRunAt().Unix() != 1741083308 {
+		t.Errorf("unexpected last run time: %s", summary.LastRunAt())
+	}
+
+	apiReport := (&LastRun{Report: report, Summary: summary}).APIReport()
+	if apiReport.IsLastRunYamlFileNotPresent || len(apiReport.FailedResources) != 1 || apiReport.Timing["total"] != 6.482 {
+		t.Errorf("unexpected api report: %+v", apiReport)
+	}
+}
@@ -0,0 +1,158 @@
+package puppet
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
+)
+
+// RunMode decides whether the agent only reports (noop) or also applies the catalog
+type RunMode string
+
+const (
+	RunModeNoop    RunMode = "noop"
+	RunModeEnforce RunMode = "enforce"
+	// RunModeServer lets the Obmondo API decide between noop and enforce
+	RunModeServer RunMode = "server"
+)
+
+// Puppet run execution with --detailed-exitcodes returns total 5 status codes
+// [Source: https://www.puppet.com/docs/puppet/7/man/agent.html#usage-notes]
+const (
+	// The run succeeded with no changes or failures; the system was already in the desired state.
+	ExitCodeNoChanges = 0
+	// The run failed, or wasn't attempted due to another run already in progress.
+	ExitCodeFailed = 1
+	// The run succeeded, and some resources were changed.
+	ExitCodeChanged = 2
+	// The run succeeded, and some resources failed.
+	ExitCodeFailures = 4
+	// The run succeeded, and included both changes and failures.
+	ExitCodeChangedWithFailures = 6
+)
+
+var (
+	// Tags are class or resource names, which may be namespaced with ::
+	tagRegex = regexp.MustCompile(`^[a-z0-9_][a-z0-9_:.\-]*$`)
+	// Environment names are restricted by puppet to these characters
+	environmentRegex = regexp.MustCompile(`^[a-z0-9_]+$`)
+)
+
+type RunOptions struct {
+	Mode        RunMode
+	Environment string
+	Tags        []string
+	SkipTags    []string
+	// RemoteLog streams the output of the run to webtee
+	RemoteLog bool
+	// Output gets a local copy of the output. When streaming to webtee a nil Output
+	// discards it, local runs fall back to stdout.
+	Output io.Writer
+}
+
+// ParseRunMode validates a mode given on the command line or in the environment
+func ParseRunMode(mode string) (RunMode, error) {
+	switch RunMode(strings.ToLower(mode)) {
+	case RunModeNoop:
+		return RunModeNoop, nil
+	case RunModeEnforce:
+		return RunModeEnforce, nil
+	case RunModeServer:
+		return RunModeServer, nil
+	default:
+		return "", fmt.Errorf("invalid openvox run mode %q, must be one of noop, enforce or server", mode)
+	}
+}
+
+// Validate checks the options before they end up on a shell command line
+func (o *RunOptions) Validate() error {
+	if o.Mode != RunModeNoop && o.Mode != RunModeEnforce {
+		return fmt.Errorf("unsupported run mode %q", o.Mode)
+	}
+
+	if o.Environment != "" && !environmentRegex.MatchString(o.Environment) {
+		return fmt.Errorf("invalid environment name %q", o.Environment)
+	}
+
+	for _, tag := range slices.Concat(o.Tags, o.SkipTags) {
+		if !tagRegex.MatchString(tag) {
+			return fmt.Errorf("invalid tag %q", tag)
+		}
+	}
+
+	return nil
+}
+
+// args returns the puppet command line for the options
+func (o *RunOptions) args() []string {
+	args := []string{"puppet", "agent", "-t", "--detailed-exitcodes"}
+
+	if o.Mode == RunModeEnforce {
+		args = append(args, "--no-noop")
+	} else {
+		args = append(args, "--noop")
+	}
+
+	if o.Environment != "" {
+		args = append(args, "--environment", o.Environment)
+	}
+	if len(o.Tags) > 0 {
+		args = append(args, "--tags", strings.Join(o.Tags, ","))
+	}
+	if len(o.SkipTags) > 0 {
+		args = append(args, "--skip_tags", strings.Join(o.SkipTags, ","))
+	}
+
+	return args
+}
+
+// ResolveRunMode asks the Obmondo API for the mode when it's left to the server,
+// falling back to noop when the API can't tell
+func (s *Service) ResolveRunMode(mode RunMode) RunMode {
+	if mode != RunModeServer {
+		return mode
+	}
+
+	serverMode, err := s.apiClient.GetPuppetRunMode()
+	if err != nil {
+		slog.Warn("unable to get openvox run mode from api, falling back to noop", slog.Any("error", err))
+		return RunModeNoop
+	}
+
+	resolved, err := ParseRunMode(serverMode)
+	if err != nil || resolved == RunModeServer {
+		slog.Warn("api returned an unknown openvox run mode, falling back to noop", slog.String("mode", serverMode))
+		return RunModeNoop
+	}
+
+	slog.Debug("openvox run mode decided by the api", slog.String("mode", string(resolved)))
+	return resolved
+}
+
+// IsSuccessExitCode reports whether the run applied (or would apply) the catalog without failures
+func IsSuccessExitCode(exitCode int) bool {
+	return slices.Contains(constant.PuppetSuccessExitCodes, exitCode)
+}
+
+// DescribeExitCode explains a --detailed-exitcodes exit code
+func DescribeExitCode(exitCode int) string {
+	switch exitCode {
+	case ExitCodeNoChanges:
+		return "the run succeeded with no changes or failures"
+	case ExitCodeFailed:
+		return "the run failed, or wasn't attempted due to another run already in progress"
+	case ExitCodeChanged:
+		return "the run succeeded, and some resources were changed"
+	case ExitCodeFailures:
+		return "the run succeeded, and some resources failed"
+	case ExitCodeChangedWithFailures:
+		return "the run succeeded, and included both changes and failures"
+	default:
+		return "the run exited with an unknown status"
+	}
+}
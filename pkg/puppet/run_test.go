@@ -0,0 +1,41 @@
+package puppet
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRunOptionsArgs(t *testing.T) {
+	opts := &RunOptions{
+		Mode:        RunModeEnforce,
+		Environment: "staging",
+		Tags:        []string{"nginx", "profile::web"},
+		SkipTags:    []string{"monitoring"},
+	}
+	if err := opts.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"puppet", "agent", "-t", "--detailed-exitcodes", "--no-noop",
+		"--environment", "staging", "--tags", "nginx,profile::web", "--skip_tags", "monitoring",
+	}
+	if args := opts.args(); !slices.Equal(args, expected) {
+		t.Errorf("\n expected: %v\n actual: %v", expected, args)
+	}
+}
+
+func TestRunOptionsValidate(t *testing.T) {
+	invalid := []*RunOptions{
+		{Mode: RunModeServer},
+		{Mode: RunModeNoop, Environment: "master; rm -rf /"},
+		{Mode: RunModeNoop, Tags: []string{"nginx'"}},
+		{Mode: RunModeNoop, SkipTags: []string{"$(id)"}},
+	}
+
+	for _, opts := range invalid {
+		if err := opts.Validate(); err == nil {
+			t.Errorf("expected options to be rejected: %+v", opts)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package webtee
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	rpc "gitea.obmondo.com/EnableIT/linuxaid-cli/rpc"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	logWriterBuffer       = 256
+	logWriterCloseTimeout = 5 * time.Second
+)
+
+// LogWriter streams every line written to it to the webtee server, it's meant as the tee
+// of the logger. Lines are dropped rather than blocking the caller when the server is slow
+// or unreachable, and it never logs itself since that would feed back into the stream.
+type LogWriter struct {
+	mu     sync.Mutex
+	closed bool
+	lines  chan logLine
+	done   chan struct{}
+	conn   *grpc.ClientConn
+}
+
+// NewLogWriter opens a log stream for certname, the stream has to be closed to flush the buffered lines
+func (w *Webtee) NewLogWriter(certname string) (*LogWriter, error) {
+	conn, err := grpc.NewClient(w.obmondoAPIURL, getTLSDialOption(false))
+	if err != nil {
+		return nil, err
+	}
+
+	l := &LogWriter{
+		lines: make(chan logLine, logWriterBuffer),
+		done:  make(chan struct{}),
+		conn:  conn,
+	}
+
+	go l.stream(certname)
+
+	return l, nil
+}
+
+func (l *LogWriter) stream(certname string) {
+	defer close(l.done)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), metadataCertKey, certname)
+	stream, err := rpc.NewWebteeClient(l.conn).SendLog(ctx)
+	if err != nil {
+		// Without a stream the lines are thrown away
+		for range l.lines {
+		}
+		return
+	}
+
+	for m := range l.lines {
+		logLine := rpc.LogLine{
+			Timestamp: uint64(time.Now().Unix()),
+			Line:      m.line,
+			Pipe:      m.pipe,
+		}
+		if err := stream.Send(&logLine); err != nil {
+			for range l.lines {
+			}
+			return
+		}
+	}
+
+	// nolint: errcheck
+	stream.CloseAndRecv()
+}
+
+// Write queues every line of p, it doesn't fail
+func (l *LogWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return len(p), nil
+	}
+
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		select {
+		case l.lines <- logLine{line: string(line), pipe: pipeNameStderr}:
+		default:
+		}
+	}
+
+	return len(p), nil
+}
+
+// Close sends the queued lines and closes the stream, giving up after a few seconds
+func (l *LogWriter) Close() error {
+	l.mu.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.lines)
+	}
+	l.mu.Unlock()
+
+	select {
+	case <-l.done:
+	case <-time.After(logWriterCloseTimeout):
+	}
+
+	return l.conn.Close()
+}
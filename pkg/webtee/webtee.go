@@ -2,18 +2,20 @@ package webtee
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log/slog"
 	"net/url"
-	"os"
 	"os/exec"
-	"slices"
 	"strings"
 	"sync"
+	"time"
 
-	"gitea.obmondo.com/EnableIT/linuxaid-cli/constant"
 	api "gitea.obmondo.com/EnableIT/linuxaid-cli/pkg/obmondo"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
 )
 
 // Pipenames
@@ -27,7 +29,9 @@ type Webtee struct {
 	obmondoAPI    api.ObmondoClient
 }
 
-func (w *Webtee) RemoteLogObmondo(command []string, certname string) {
+// Stream runs the command and sends its output to the webtee server, it leaves it to the caller
+// to act on the exit code. The output is also copied to out, if set.
+func (w *Webtee) Stream(command []string, certname string, out io.Writer) (int, error) {
 	app := &application{
 		config: WebTeeConfig{w.obmondoAPIURL, true, command, certname, false},
 	}
@@ -40,85 +44,70 @@ func (w *Webtee) RemoteLogObmondo(command []string, certname string) {
 	app.wg.Add(1)
 	go webTee(app, lines)
 
+	// Close the lines channel and wait for the grpc stream to finish, whatever happens to the command
+	defer app.wg.Wait()
+	defer close(lines)
+
+	cmd, err := startCommand(app.config.Command(), lines, out)
+	if err != nil {
+		return -1, err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode(), nil
+		}
+		return -1, fmt.Errorf("command execution failed: %w", err)
+	}
+
+	return 0, nil
+}
+
+// startCommand starts the command and feeds every line of stdout & stderr into the lines channel.
+// When it returns without an error both pipes have been read till the end.
+func startCommand(command []string, lines chan logLine, out io.Writer) (*exec.Cmd, error) {
 	// Prepare the command and create pipes for stderr and stdout.
-	cmd := exec.Command("/bin/bash", "-c", strings.Join(app.config.Command(), " "))
+	cmd := exec.Command("/bin/bash", "-c", strings.Join(command, " "))
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		// nolint: errcheck
-		w.obmondoAPI.NotifyInstallScriptFailure(&api.InstallScriptInput{
-			Certname: certname,
-		})
-
-		slog.Error("failed to connect to stdout pipe", slog.String("error", err.Error()))
-		os.Exit(1)
+		return nil, fmt.Errorf("failed to connect to stdout pipe: %w", err)
 	}
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
-		//nolint:errcheck
-		w.obmondoAPI.NotifyInstallScriptFailure(&api.InstallScriptInput{
-			Certname: certname,
-		})
-		slog.Error("failed to connect to stderr pipe", slog.String("error", err.Error()))
-		os.Exit(1)
+		return nil, fmt.Errorf("failed to connect to stderr pipe: %w", err)
 	}
 
 	// Start command execution.
-	err = cmd.Start()
-	if err != nil {
-		//nolint:errcheck
-		w.obmondoAPI.NotifyInstallScriptFailure(&api.InstallScriptInput{
-			Certname: certname,
-		})
-		slog.Error("failed to start command", slog.String("error", err.Error()))
-		os.Exit(1)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start command: %w", err)
 	}
 
 	// For each line in stdout & stderr, wrap it in an "echo" command and send it to webtee server.
 	var pipeWg sync.WaitGroup
 	pipeWg.Add(1)
-	go readPipe(stderr, lines, false, &pipeWg)
+	go readPipe(stderr, lines, false, out, &pipeWg)
 	pipeWg.Add(1)
-	go readPipe(stdout, lines, true, &pipeWg)
+	go readPipe(stdout, lines, true, out, &pipeWg)
 
 	// Now wait for the pipes to finish reading & sending to lines channel.
 	pipeWg.Wait()
 
-	err = cmd.Wait()
-
-	// Don't complain if the command being run is puppet agent and the exit status is mentioned in the constant.PuppetSuccessExitCodes.
-	// Else, check the error and complain about the same.
-	if !shouldIgnorePuppetAgentError(command, cmd.ProcessState.ExitCode()) {
-		if err != nil {
-			slog.Debug("command execution failed", slog.String("command", strings.Join(command, " ")), slog.String("error", err.Error()))
-			//nolint:forbidigo, errcheck
-			w.obmondoAPI.NotifyInstallScriptFailure(&api.InstallScriptInput{
-				Certname: certname,
-			})
-
-			os.Exit(1)
-		}
-	}
-
-	// Close the lines channel.
-	close(lines)
-
-	// Wait for goroutines (like the grpc stream) to finish.
-	app.wg.Wait()
-}
-
-func shouldIgnorePuppetAgentError(command []string, exitCode int) bool {
-	return strings.Contains(strings.Join(command, " "), "puppet agent") && slices.Contains(constant.PuppetSuccessExitCodes, exitCode)
+	return cmd, nil
 }
 
-// readPipe reads a pipe, wraps every line in an "echo" command, prints it, and sends the line to
+// readPipe reads a pipe, wraps every line in an "echo" command, prints it to out (if set), and sends the line to
 // the lines channel. It should always be run in a separate goroutine because
 // we decrement wg waitgroup after execution.
-func readPipe(pipe io.ReadCloser, lines chan logLine, isStdout bool, wg *sync.WaitGroup) {
+func readPipe(pipe io.ReadCloser, lines chan logLine, isStdout bool, out io.Writer, wg *sync.WaitGroup) {
 	defer wg.Done()
 	scanner := bufio.NewScanner(pipe)
 	for scanner.Scan() {
 		m := scanner.Text()
+		if out != nil {
+			fmt.Fprintln(out, m) //nolint:errcheck
+		}
 		if isStdout {
 			lines <- logLine{
 				line: m,
@@ -141,3 +130,49 @@ func NewWebtee(obmondoAPI api.ObmondoClient) *Webtee {
 		obmondoAPI:    obmondoAPI,
 	}
 }
+
+// Send streams the lines to the webtee server, as if they were the output of a command
+func (w *Webtee) Send(lines []string, certname string) {
+	app := &application{
+		config: WebTeeConfig{w.obmondoAPIURL, true, nil, certname, false},
+	}
+	connectToServer(app)
+	// nolint: errcheck
+	defer app.conn.Close()
+
+	logLines := make(chan logLine)
+
+	app.wg.Add(1)
+	go webTee(app, logLines)
+
+	for _, line := range lines {
+		logLines <- logLine{line: line, pipe: pipeNameStdout}
+	}
+
+	close(logLines)
+	app.wg.Wait()
+}
+
+// CheckConnection does the gRPC (and TLS) handshake with the webtee server
+func (w *Webtee) CheckConnection(timeout time.Duration) error {
+	conn, err := grpc.NewClient(w.obmondoAPIURL, getTLSDialOption(false))
+	if err != nil {
+		return fmt.Errorf("failed to create webtee client: %w", err)
+	}
+	// nolint: errcheck
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	conn.Connect()
+	for {
+		state := conn.GetState()
+		if state == connectivity.Ready {
+			return nil
+		}
+		if !conn.WaitForStateChange(ctx, state) {
+			return fmt.Errorf("webtee server %s is not ready, connection is %s", w.obmondoAPIURL, strings.ToLower(state.String()))
+		}
+	}
+}